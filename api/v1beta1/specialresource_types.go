@@ -18,6 +18,7 @@ package v1beta1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // SpecialResourceImages defines the observed state of SpecialResource
@@ -85,9 +86,27 @@ type SpecialResourceSource struct {
 	Git SpecialResourceGit `json:"git,omitempty"`
 }
 
+// SpecialResourceRetention defines which driver-container images are kept
+type SpecialResourceRetention struct {
+	// PreviousKernels is the number of images kept for kernels that are
+	// not running on any node anymore, images of running kernels are never pruned
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	PreviousKernels int `json:"previousKernels,omitempty"`
+}
+
 // SpecialResourceDriverContainer defines the desired state of SpecialResource
 type SpecialResourceDriverContainer struct {
 
+	// Image is a template of a prebuilt driver-container image e.g.
+	// quay.io/vendor/driver:{{.KernelVersion}}-{{.OperatingSystemDecimal}}
+	// it is used if it exists in the registry, otherwise it is built
+	// +kubebuilder:validation:Optional
+	Image string `json:"image,omitempty"`
+	// ImagePullSecret is a dockerconfigjson Secret in the specialresource
+	// namespace used to look up Image
+	// +kubebuilder:validation:Optional
+	ImagePullSecret string `json:"imagePullSecret,omitempty"`
 	// +kubebuilder:validation:Optional
 	Source SpecialResourceSource `json:"source,omitempty"`
 	// +kubebuilder:validation:Optional
@@ -96,6 +115,8 @@ type SpecialResourceDriverContainer struct {
 	RunArgs []SpecialResourceRunArgs `json:"runArgs,omitempty"`
 	// +kubebuilder:validation:Optional
 	Artifacts SpecialResourceArtifacts `json:"artifacts,omitempty"`
+	// +kubebuilder:validation:Optional
+	Retention SpecialResourceRetention `json:"retention,omitempty"`
 }
 
 // SpecialResourceDependency is a SpecialResource that needs to be Complete
@@ -105,6 +126,45 @@ type SpecialResourceDependency struct {
 	ImageReference string `json:"imageReference"`
 }
 
+// SpecialResourceRuntimeInformation pins a key of the runtime information,
+// Name has to be a key of a runtime provider e.g. KernelVersion
+type SpecialResourceRuntimeInformation struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SpecialResourceMirror rewrites images and git URIs starting with Source
+// to the first of Mirrors, like an ImageContentSourcePolicy does for images
+type SpecialResourceMirror struct {
+	// Source is an image repository e.g. quay.io/vendor or a git URI prefix
+	// e.g. https://github.com/vendor
+	Source  string   `json:"source"`
+	Mirrors []string `json:"mirrors"`
+}
+
+// SpecialResourceUpgrade replaces the driver-container node by node instead
+// of rolling the DaemonSet, each node is cordoned unless disabled, its
+// workloads are waited for or evicted, the driver Pod is replaced and the
+// node is uncordoned once the new Pod is Ready
+type SpecialResourceUpgrade struct {
+	// MaxUnavailable is the number or percentage of nodes upgraded at once
+	// +kubebuilder:validation:Optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// ResourceName is the extended resource workloads request e.g.
+	// nvidia.com/gpu, only Pods requesting it block the upgrade of a node
+	// +kubebuilder:validation:Optional
+	ResourceName string `json:"resourceName,omitempty"`
+	// Drain evicts the workloads, otherwise the upgrade of a node waits for
+	// them to finish
+	// +kubebuilder:validation:Optional
+	Drain bool `json:"drain,omitempty"`
+	// Cordon keeps new workloads off a node while it is upgraded, defaults
+	// to true. Without it workloads scheduled during the upgrade may fail
+	// until the new driver is Ready.
+	// +kubebuilder:validation:Optional
+	Cordon *bool `json:"cordon,omitempty"`
+}
+
 // SpecialResourceSpec defines the desired state of SpecialResource
 type SpecialResourceSpec struct {
 	// +kubebuilder:validation:Required
@@ -117,11 +177,139 @@ type SpecialResourceSpec struct {
 	Node SpecialResourceNode `json:"node,omitempty"`
 	// +kubebuilder:validation:Optional
 	DependsOn []SpecialResourceDependency `json:"dependsOn,omitempty"`
+	// RuntimeInformation overrides values the operator would detect e.g.
+	// KernelVersion to render for a kernel that is not running (yet)
+	// +kubebuilder:validation:Optional
+	RuntimeInformation []SpecialResourceRuntimeInformation `json:"runtimeInformation,omitempty"`
+	// Mirrors take precedence over the mirrors configured for the operator
+	// +kubebuilder:validation:Optional
+	Mirrors []SpecialResourceMirror `json:"mirrors,omitempty"`
+	// Rollback re-applies the last Ready snapshot of a failing state
+	// +kubebuilder:validation:Optional
+	Rollback *SpecialResourceRollback `json:"rollback,omitempty"`
+	// RecipeVersion pins the recipe to a version e.g. 1.2.0 or constrains
+	// it e.g. ">=1.1.0,<2.0.0". Without it the SpecialResource stays on the
+	// version in status, the latest version is used for new ones.
+	// +kubebuilder:validation:Optional
+	RecipeVersion string `json:"recipeVersion,omitempty"`
+	// Upgrade orchestrates driver-container updates, the DaemonSet rolls
+	// its Pods if not set
+	// +kubebuilder:validation:Optional
+	Upgrade *SpecialResourceUpgrade `json:"upgrade,omitempty"`
+}
+
+// SpecialResourceRollback defines when a state failed, a state can always
+// be rolled back with the specialresource.openshift.io/rollback annotation
+type SpecialResourceRollback struct {
+	// Automatic rolls back a state once Timeout or CrashLoopThreshold is hit
+	// +kubebuilder:validation:Optional
+	Automatic bool `json:"automatic,omitempty"`
+	// Timeout is how long an applied state may not be Ready
+	// +kubebuilder:validation:Optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// CrashLoopThreshold is how often a container of a DaemonSet of the
+	// state may restart in CrashLoopBackOff
+	// +kubebuilder:validation:Optional
+	CrashLoopThreshold int32 `json:"crashLoopThreshold,omitempty"`
+}
+
+// SpecialResourceHookStatus is the result of the last run of a hook Job
+type SpecialResourceHookStatus struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	// Target is the object the hook ran for as Kind/Name
+	Target string `json:"target"`
+	// State is Succeeded or Failed
+	State string `json:"state"`
+	// Output is the tail of the logs of the hook Job
+	// +kubebuilder:validation:Optional
+	Output string `json:"output,omitempty"`
+}
+
+// SpecialResourceNodeStatus is the result of a check of an object on a node
+type SpecialResourceNodeStatus struct {
+	Node string `json:"node"`
+	// Object is the checked object as Kind/Name
+	Object string `json:"object"`
+	// Check is what was checked e.g. wait-for-logs
+	Check string `json:"check"`
+	Ready bool   `json:"ready"`
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+}
+
+// SpecialResourceStateStatus is a state of the recipe and its place in the
+// state graph
+type SpecialResourceStateStatus struct {
+	Name string `json:"name"`
+	// Partition is the partition of nodes the state was reconciled for
+	// +kubebuilder:validation:Optional
+	Partition string `json:"partition,omitempty"`
+	// +kubebuilder:validation:Optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Parallel states run concurrently with the states they do not depend
+	// on
+	// +kubebuilder:validation:Optional
+	Parallel bool `json:"parallel,omitempty"`
+	// Phase is Blocked, Applied, Ready, Skipped, RolledBack or Failed
+	Phase string `json:"phase"`
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+	// +kubebuilder:validation:Optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+	// RolledBack is the hash of the rendered manifests that failed, the
+	// snapshot is applied until the manifests render differently
+	// +kubebuilder:validation:Optional
+	RolledBack string `json:"rolledBack,omitempty"`
+}
+
+// SpecialResourceSkippedStatus is an object or state that was not applied
+// because its include-if condition is false
+type SpecialResourceSkippedStatus struct {
+	// Object is Kind/Name of the object or State/Name of the state
+	Object string `json:"object"`
+	// +kubebuilder:validation:Optional
+	Partition string `json:"partition,omitempty"`
+	// Condition is the include-if condition that is false
+	Condition string `json:"condition"`
+}
+
+// SpecialResourceInventoryObject is an object applied for the SpecialResource
+type SpecialResourceInventoryObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
 }
 
 // SpecialResourceStatus defines the observed state of SpecialResource
 type SpecialResourceStatus struct {
 	State string `json:"state"`
+	// +kubebuilder:validation:Optional
+	Hooks []SpecialResourceHookStatus `json:"hooks,omitempty"`
+	// +kubebuilder:validation:Optional
+	Nodes []SpecialResourceNodeStatus `json:"nodes,omitempty"`
+	// +kubebuilder:validation:Optional
+	States []SpecialResourceStateStatus `json:"states,omitempty"`
+	// +kubebuilder:validation:Optional
+	Skipped []SpecialResourceSkippedStatus `json:"skipped,omitempty"`
+	// Inventory are the objects of the last reconcile, objects that are
+	// no longer rendered are pruned
+	// +kubebuilder:validation:Optional
+	Inventory []SpecialResourceInventoryObject `json:"inventory,omitempty"`
+	// RecipeVersion is the version of the recipe in use
+	// +kubebuilder:validation:Optional
+	RecipeVersion string `json:"recipeVersion,omitempty"`
+	// AvailableRecipeVersions are the versions of the recipe shipped with
+	// the operator
+	// +kubebuilder:validation:Optional
+	AvailableRecipeVersions []string `json:"availableRecipeVersions,omitempty"`
+	// Conditions e.g. Degraded if a state was rolled back
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
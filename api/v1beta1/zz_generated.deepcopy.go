@@ -5,7 +5,9 @@
 package v1beta1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -14,7 +16,7 @@ func (in *SpecialResource) DeepCopyInto(out *SpecialResource) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResource.
@@ -147,6 +149,7 @@ func (in *SpecialResourceDriverContainer) DeepCopyInto(out *SpecialResourceDrive
 		copy(*out, *in)
 	}
 	in.Artifacts.DeepCopyInto(&out.Artifacts)
+	out.Retention = in.Retention
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceDriverContainer.
@@ -174,6 +177,21 @@ func (in *SpecialResourceGit) DeepCopy() *SpecialResourceGit {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceHookStatus) DeepCopyInto(out *SpecialResourceHookStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceHookStatus.
+func (in *SpecialResourceHookStatus) DeepCopy() *SpecialResourceHookStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceHookStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourceImages) DeepCopyInto(out *SpecialResourceImages) {
 	*out = *in
@@ -194,6 +212,21 @@ func (in *SpecialResourceImages) DeepCopy() *SpecialResourceImages {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceInventoryObject) DeepCopyInto(out *SpecialResourceInventoryObject) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceInventoryObject.
+func (in *SpecialResourceInventoryObject) DeepCopy() *SpecialResourceInventoryObject {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceInventoryObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourceList) DeepCopyInto(out *SpecialResourceList) {
 	*out = *in
@@ -226,6 +259,26 @@ func (in *SpecialResourceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceMirror) DeepCopyInto(out *SpecialResourceMirror) {
+	*out = *in
+	if in.Mirrors != nil {
+		in, out := &in.Mirrors, &out.Mirrors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceMirror.
+func (in *SpecialResourceMirror) DeepCopy() *SpecialResourceMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourceNode) DeepCopyInto(out *SpecialResourceNode) {
 	*out = *in
@@ -241,6 +294,21 @@ func (in *SpecialResourceNode) DeepCopy() *SpecialResourceNode {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceNodeStatus) DeepCopyInto(out *SpecialResourceNodeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceNodeStatus.
+func (in *SpecialResourceNodeStatus) DeepCopy() *SpecialResourceNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourcePaths) DeepCopyInto(out *SpecialResourcePaths) {
 	*out = *in
@@ -256,6 +324,41 @@ func (in *SpecialResourcePaths) DeepCopy() *SpecialResourcePaths {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceRetention) DeepCopyInto(out *SpecialResourceRetention) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceRetention.
+func (in *SpecialResourceRetention) DeepCopy() *SpecialResourceRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceRollback) DeepCopyInto(out *SpecialResourceRollback) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceRollback.
+func (in *SpecialResourceRollback) DeepCopy() *SpecialResourceRollback {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceRollback)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourceRunArgs) DeepCopyInto(out *SpecialResourceRunArgs) {
 	*out = *in
@@ -271,6 +374,36 @@ func (in *SpecialResourceRunArgs) DeepCopy() *SpecialResourceRunArgs {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceRuntimeInformation) DeepCopyInto(out *SpecialResourceRuntimeInformation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceRuntimeInformation.
+func (in *SpecialResourceRuntimeInformation) DeepCopy() *SpecialResourceRuntimeInformation {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceRuntimeInformation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceSkippedStatus) DeepCopyInto(out *SpecialResourceSkippedStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceSkippedStatus.
+func (in *SpecialResourceSkippedStatus) DeepCopy() *SpecialResourceSkippedStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceSkippedStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourceSource) DeepCopyInto(out *SpecialResourceSource) {
 	*out = *in
@@ -304,6 +437,28 @@ func (in *SpecialResourceSpec) DeepCopyInto(out *SpecialResourceSpec) {
 		*out = make([]SpecialResourceDependency, len(*in))
 		copy(*out, *in)
 	}
+	if in.RuntimeInformation != nil {
+		in, out := &in.RuntimeInformation, &out.RuntimeInformation
+		*out = make([]SpecialResourceRuntimeInformation, len(*in))
+		copy(*out, *in)
+	}
+	if in.Mirrors != nil {
+		in, out := &in.Mirrors, &out.Mirrors
+		*out = make([]SpecialResourceMirror, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Rollback != nil {
+		in, out := &in.Rollback, &out.Rollback
+		*out = new(SpecialResourceRollback)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Upgrade != nil {
+		in, out := &in.Upgrade, &out.Upgrade
+		*out = new(SpecialResourceUpgrade)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceSpec.
@@ -316,9 +471,72 @@ func (in *SpecialResourceSpec) DeepCopy() *SpecialResourceSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceStateStatus) DeepCopyInto(out *SpecialResourceStateStatus) {
+	*out = *in
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceStateStatus.
+func (in *SpecialResourceStateStatus) DeepCopy() *SpecialResourceStateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceStateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpecialResourceStatus) DeepCopyInto(out *SpecialResourceStatus) {
 	*out = *in
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = make([]SpecialResourceHookStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]SpecialResourceNodeStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.States != nil {
+		in, out := &in.States, &out.States
+		*out = make([]SpecialResourceStateStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Skipped != nil {
+		in, out := &in.Skipped, &out.Skipped
+		*out = make([]SpecialResourceSkippedStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Inventory != nil {
+		in, out := &in.Inventory, &out.Inventory
+		*out = make([]SpecialResourceInventoryObject, len(*in))
+		copy(*out, *in)
+	}
+	if in.AvailableRecipeVersions != nil {
+		in, out := &in.AvailableRecipeVersions, &out.AvailableRecipeVersions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceStatus.
@@ -330,3 +548,28 @@ func (in *SpecialResourceStatus) DeepCopy() *SpecialResourceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecialResourceUpgrade) DeepCopyInto(out *SpecialResourceUpgrade) {
+	*out = *in
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.Cordon != nil {
+		in, out := &in.Cordon, &out.Cordon
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecialResourceUpgrade.
+func (in *SpecialResourceUpgrade) DeepCopy() *SpecialResourceUpgrade {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecialResourceUpgrade)
+	in.DeepCopyInto(out)
+	return out
+}
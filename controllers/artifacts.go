@@ -0,0 +1,416 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// artifactsAnnotation "true" or "false" overrides which objects get the
+// artifacts of spec.driverContainer.artifacts. By default these are all
+// BuildConfigs and the pods of the driver-container state.
+const artifactsAnnotation = "specialresource.openshift.io/artifacts"
+
+const (
+	artifactsVolume         = "specialresource-artifacts"
+	artifactsMountPath      = "/specialresource-artifacts"
+	artifactsToolsVolume    = "specialresource-artifacts-tools"
+	artifactsToolsMountPath = "/specialresource-artifacts-tools"
+)
+
+// DefaultArtifactsToolsImage provides the static busybox that copies the
+// artifacts if the operator image cannot be found e.g. out of cluster
+const DefaultArtifactsToolsImage = "docker.io/library/busybox:1.32"
+
+// OperatorImage returns the image of the manager container of the operator
+// Pod POD_NAME, the operator image ships a static /bin/busybox so artifact
+// images need no shell and can be FROM scratch
+func OperatorImage() (string, error) {
+
+	namespace, name := os.Getenv("OPERATOR_NAMESPACE"), os.Getenv("POD_NAME")
+	if namespace == "" || name == "" {
+		return "", errs.New("OPERATOR_NAMESPACE or POD_NAME not set")
+	}
+
+	pod, err := kubeclient.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot get operator Pod "+name)
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "manager" {
+			return container.Image, nil
+		}
+	}
+
+	return "", errs.New("Operator Pod " + name + " has no manager container")
+}
+
+// artifactsCopyScript copies pairs of <sourcePath> <destinationDir> from
+// the image it runs in to $ROOT with the busybox $BB. The source is not
+// quoted so it can be a glob e.g. /firmware/*.bin.
+const artifactsCopyScript = `set -e
+while [ $# -gt 1 ]; do
+  "$BB" mkdir -p "$ROOT/$2"
+  "$BB" cp -a $1 "$ROOT/$2/"
+  shift 2
+done
+`
+
+// artifactsToolsContainer copies the busybox of image to the tools volume
+// before the artifacts are copied
+func artifactsToolsContainer(image string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         "artifacts-tools",
+		"image":        image,
+		"command":      []interface{}{"/bin/busybox", "cp", "/bin/busybox", artifactsToolsMountPath + "/busybox"},
+		"volumeMounts": []interface{}{artifactsToolsMount()},
+	}
+}
+
+func artifactsToolsMount() map[string]interface{} {
+	return map[string]interface{}{"name": artifactsToolsVolume, "mountPath": artifactsToolsMountPath}
+}
+
+func artifactsToolsVolumeSpec() map[string]interface{} {
+	return map[string]interface{}{"name": artifactsToolsVolume, "emptyDir": map[string]interface{}{}}
+}
+
+// artifactsCopyContainer runs artifactsCopyScript with args in image, the
+// artifacts are copied to root in mount
+func artifactsCopyContainer(name string, image string, args []interface{}, root string, mount map[string]interface{}) map[string]interface{} {
+
+	busybox := artifactsToolsMountPath + "/busybox"
+	command := append([]interface{}{busybox, "sh", "-c", artifactsCopyScript, "artifacts"}, args...)
+
+	return map[string]interface{}{
+		"name":    name,
+		"image":   image,
+		"command": command,
+		"env": []interface{}{
+			map[string]interface{}{"name": "BB", "value": busybox},
+			map[string]interface{}{"name": "ROOT", "value": root},
+		},
+		"volumeMounts": []interface{}{mount, artifactsToolsMount()},
+	}
+}
+
+// validateArtifactDestinations fails if two artifacts or an artifact and an
+// existing mount of a container would be mounted at the same path, paths of
+// images share the artifacts volume and can have the same destination
+func validateArtifactDestinations(artifacts srov1beta1.SpecialResourceArtifacts, containers []interface{}) error {
+
+	destinations := map[string]string{}
+
+	add := func(destination string, artifact string) error {
+		destination = path.Clean(destination)
+		if other, found := destinations[destination]; found && other != artifact {
+			return errs.New("Artifacts " + other + " and " + artifact + " are both mounted at " + destination)
+		}
+		destinations[destination] = artifact
+		return nil
+	}
+
+	for _, image := range artifacts.Images {
+		for _, p := range image.Paths {
+			if err := add(p.DestinationDir, "images"); err != nil {
+				return err
+			}
+		}
+	}
+	for _, hostPath := range artifacts.HostPaths {
+		if err := add(hostPath.DestinationDir, "hostPath "+hostPath.SourcePath); err != nil {
+			return err
+		}
+	}
+	for _, claim := range artifacts.Claims {
+		if err := add(claim.MountPath, "claim "+claim.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range containers {
+		container, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mounts, _, _ := unstructured.NestedSlice(container, "volumeMounts")
+		for _, mount := range mounts {
+			mount, ok := mount.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			mountPath, _ := mount["mountPath"].(string)
+			if artifact, found := destinations[path.Clean(mountPath)]; found {
+				return errs.New("Artifacts " + artifact + " are mounted at " + mountPath + " which is already mounted by container " + fmt.Sprint(container["name"]))
+			}
+		}
+	}
+
+	return nil
+}
+
+// artifactsTarget returns true if the artifacts are injected into obj
+func artifactsTarget(obj *unstructured.Unstructured) bool {
+
+	switch obj.GetAnnotations()[artifactsAnnotation] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if obj.GetKind() == "BuildConfig" {
+		return true
+	}
+	if _, found := podSpecPaths[obj.GetKind()]; !found {
+		return false
+	}
+
+	return obj.GetAnnotations()["specialresource.openshift.io/state"] == runInfo.GroupName.DriverContainer ||
+		strings.Contains(obj.GetName(), "-"+runInfo.GroupName.DriverContainer)
+}
+
+// artifactImage resolves the image artifacts are copied from, DockerImage
+// (default) is pulled as is and ImageStreamTags from the registry we build to
+func artifactImage(image srov1beta1.SpecialResourceImages, namespace string, r *SpecialResourceReconciler) (string, error) {
+
+	switch image.Kind {
+	case "", "DockerImage":
+		return image.Name, nil
+	case "ImageStreamTag":
+		if image.Namespace != "" {
+			namespace = image.Namespace
+		}
+		return buildRegistryHost(r) + "/" + namespace + "/" + image.Name, nil
+	}
+
+	return "", errs.New("Cannot copy artifacts from " + image.Kind + " " + image.Name)
+}
+
+// injectArtifacts copies the artifacts of the SpecialResource into
+// BuildConfigs as source images and into pods with init containers
+// and volumes
+func injectArtifacts(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	artifacts := r.specialresource.Spec.DriverContainer.Artifacts
+	if len(artifacts.Images) == 0 && len(artifacts.HostPaths) == 0 && len(artifacts.Claims) == 0 {
+		return nil
+	}
+	if !artifactsTarget(obj) {
+		return nil
+	}
+
+	if obj.GetKind() == "BuildConfig" {
+		return injectBuildArtifacts(obj, artifacts)
+	}
+
+	path, found := podSpecPaths[obj.GetKind()]
+	if !found {
+		log.Info("Cannot inject artifacts, no pod spec", "Kind", obj.GetKind(), "Name", obj.GetName())
+		return nil
+	}
+
+	spec, _, err := unstructured.NestedMap(obj.Object, path...)
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract pod spec of "+obj.GetName())
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+
+	if err := injectPodArtifacts(spec, artifacts, obj.GetNamespace(), r); err != nil {
+		return errs.Wrap(err, "Cannot inject artifacts into "+obj.GetName())
+	}
+
+	log.Info("Injecting artifacts", "Kind", obj.GetKind(), "Name", obj.GetName())
+
+	return unstructured.SetNestedMap(obj.Object, spec, path...)
+}
+
+// injectBuildArtifacts adds the images to the source images of a
+// BuildConfig, destination dirs are relative to the context dir
+func injectBuildArtifacts(obj *unstructured.Unstructured, artifacts srov1beta1.SpecialResourceArtifacts) error {
+
+	if len(artifacts.HostPaths) > 0 || len(artifacts.Claims) > 0 {
+		log.Info("Builds cannot mount host paths or claims, skipping them", "Name", obj.GetName())
+	}
+	if len(artifacts.Images) == 0 {
+		return nil
+	}
+
+	images, _, err := unstructured.NestedSlice(obj.Object, "spec", "source", "images")
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract source images of "+obj.GetName())
+	}
+
+	for _, image := range artifacts.Images {
+
+		kind := image.Kind
+		if kind == "" {
+			kind = "DockerImage"
+		}
+		from := map[string]interface{}{"kind": kind, "name": image.Name}
+		if image.Namespace != "" {
+			from["namespace"] = image.Namespace
+		}
+
+		paths := []interface{}{}
+		for _, p := range image.Paths {
+			paths = append(paths, map[string]interface{}{
+				"sourcePath":     p.SourcePath,
+				"destinationDir": strings.TrimPrefix(p.DestinationDir, "/"),
+			})
+		}
+
+		source := map[string]interface{}{"from": from, "paths": paths}
+		if image.PullSecret != "" {
+			source["pullSecret"] = map[string]interface{}{"name": image.PullSecret}
+		}
+		images = append(images, source)
+	}
+
+	log.Info("Injecting artifacts", "Kind", obj.GetKind(), "Name", obj.GetName())
+
+	return unstructured.SetNestedSlice(obj.Object, images, "spec", "source", "images")
+}
+
+// injectPodArtifacts copies the paths of each image with an init container
+// to an emptyDir that all containers mount read only at the destination
+// dirs. Host paths are mounted read only, claims read write.
+func injectPodArtifacts(spec map[string]interface{}, artifacts srov1beta1.SpecialResourceArtifacts, namespace string, r *SpecialResourceReconciler) error {
+
+	volumes, _, err := unstructured.NestedSlice(spec, "volumes")
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract volumes")
+	}
+	initContainers, _, err := unstructured.NestedSlice(spec, "initContainers")
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract initContainers")
+	}
+	pullSecrets, _, err := unstructured.NestedSlice(spec, "imagePullSecrets")
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract imagePullSecrets")
+	}
+
+	containers, _, err := unstructured.NestedSlice(spec, "containers")
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract containers")
+	}
+	if err := validateArtifactDestinations(artifacts, containers); err != nil {
+		return err
+	}
+
+	mounts := []interface{}{}
+
+	// Each destination dir is a subPath of the artifacts volume
+	destinations := map[string]int{}
+	for i, image := range artifacts.Images {
+
+		name, err := artifactImage(image, namespace, r)
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{}
+		for _, p := range image.Paths {
+			dir, found := destinations[p.DestinationDir]
+			if !found {
+				dir = len(destinations)
+				destinations[p.DestinationDir] = dir
+				mounts = append(mounts, map[string]interface{}{
+					"name":      artifactsVolume,
+					"mountPath": p.DestinationDir,
+					"subPath":   strconv.Itoa(dir),
+					"readOnly":  true,
+				})
+			}
+			args = append(args, p.SourcePath, strconv.Itoa(dir))
+		}
+
+		if i == 0 {
+			initContainers = append(initContainers, artifactsToolsContainer(r.ArtifactsToolsImage))
+		}
+		mount := map[string]interface{}{"name": artifactsVolume, "mountPath": artifactsMountPath}
+		initContainers = append(initContainers, artifactsCopyContainer("artifacts-"+strconv.Itoa(i), name, args, artifactsMountPath, mount))
+
+		if image.PullSecret != "" && !hasPullSecret(pullSecrets, image.PullSecret) {
+			pullSecrets = append(pullSecrets, map[string]interface{}{"name": image.PullSecret})
+		}
+	}
+
+	if len(artifacts.Images) > 0 {
+		volumes = append(volumes,
+			map[string]interface{}{"name": artifactsVolume, "emptyDir": map[string]interface{}{}},
+			artifactsToolsVolumeSpec())
+	}
+
+	for i, hostPath := range artifacts.HostPaths {
+		volume := "specialresource-host-" + strconv.Itoa(i)
+		volumes = append(volumes, map[string]interface{}{
+			"name":     volume,
+			"hostPath": map[string]interface{}{"path": hostPath.SourcePath},
+		})
+		mounts = append(mounts, map[string]interface{}{
+			"name":      volume,
+			"mountPath": hostPath.DestinationDir,
+			"readOnly":  true,
+		})
+	}
+
+	for i, claim := range artifacts.Claims {
+		volume := "specialresource-claim-" + strconv.Itoa(i)
+		volumes = append(volumes, map[string]interface{}{
+			"name":                  volume,
+			"persistentVolumeClaim": map[string]interface{}{"claimName": claim.Name},
+		})
+		mounts = append(mounts, map[string]interface{}{
+			"name":      volume,
+			"mountPath": claim.MountPath,
+		})
+	}
+
+	for i, container := range containers {
+		container, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		volumeMounts, _, err := unstructured.NestedSlice(container, "volumeMounts")
+		if err != nil {
+			return errs.Wrap(err, "Cannot extract volumeMounts")
+		}
+		for _, mount := range mounts {
+			volumeMounts = append(volumeMounts, runtime.DeepCopyJSONValue(mount))
+		}
+		container["volumeMounts"] = volumeMounts
+		containers[i] = container
+	}
+
+	spec["containers"] = containers
+	spec["volumes"] = volumes
+	if len(initContainers) > 0 {
+		spec["initContainers"] = initContainers
+	}
+	if len(pullSecrets) > 0 {
+		spec["imagePullSecrets"] = pullSecrets
+	}
+
+	return nil
+}
+
+func hasPullSecret(pullSecrets []interface{}, name string) bool {
+	for _, s := range pullSecrets {
+		if s, ok := s.(map[string]interface{}); ok && s["name"] == name {
+			return true
+		}
+	}
+	return false
+}
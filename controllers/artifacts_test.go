@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func artifactsReconciler() *SpecialResourceReconciler {
+	r := &SpecialResourceReconciler{ArtifactsToolsImage: "quay.io/openshift-psap/special-resource-operator:latest"}
+	r.specialresource.Spec.DriverContainer.Artifacts = srov1beta1.SpecialResourceArtifacts{
+		Images: []srov1beta1.SpecialResourceImages{
+			{
+				Name:       "quay.io/vendor/firmware:1.0",
+				PullSecret: "vendor-pull-secret",
+				Paths: []srov1beta1.SpecialResourcePaths{
+					{SourcePath: "/firmware/*.bin", DestinationDir: "/lib/firmware/vendor"},
+					{SourcePath: "/LICENSE", DestinationDir: "/licenses"},
+				},
+			},
+			{
+				Name:      "license:latest",
+				Kind:      "ImageStreamTag",
+				Namespace: "vendor",
+				Paths: []srov1beta1.SpecialResourcePaths{
+					{SourcePath: "/EULA", DestinationDir: "/licenses"},
+				},
+			},
+		},
+		HostPaths: []srov1beta1.SpecialResourcePaths{
+			{SourcePath: "/etc/vendor", DestinationDir: "/etc/vendor"},
+		},
+		Claims: []srov1beta1.SpecialResourceClaims{
+			{Name: "vendor-cache", MountPath: "/var/cache/vendor"},
+		},
+	}
+	return r
+}
+
+func TestArtifactsTarget(t *testing.T) {
+
+	tests := []struct {
+		kind        string
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{"BuildConfig", "simple-kmod-driver-build", nil, true},
+		{"DaemonSet", "simple-kmod-driver-container-rhel8", nil, true},
+		{"DaemonSet", "nvidia-gpu-driver", map[string]string{"specialresource.openshift.io/state": "driver-container"}, true},
+		{"DaemonSet", "nvidia-gpu-device-plugin", map[string]string{"specialresource.openshift.io/state": "device-plugin"}, false},
+		{"DaemonSet", "nvidia-gpu-device-plugin", map[string]string{artifactsAnnotation: "true"}, true},
+		{"DaemonSet", "simple-kmod-driver-container-rhel8", map[string]string{artifactsAnnotation: "false"}, false},
+		{"ConfigMap", "simple-kmod-driver-container", nil, false},
+	}
+
+	for _, test := range tests {
+		obj := &unstructured.Unstructured{}
+		obj.SetKind(test.kind)
+		obj.SetName(test.name)
+		obj.SetAnnotations(test.annotations)
+		if got := artifactsTarget(obj); got != test.want {
+			t.Errorf("artifactsTarget(%s/%s %v) = %v, want %v", test.kind, test.name, test.annotations, got, test.want)
+		}
+	}
+}
+
+func TestInjectPodArtifacts(t *testing.T) {
+
+	r := artifactsReconciler()
+
+	ds := &unstructured.Unstructured{}
+	ds.SetKind("DaemonSet")
+	ds.SetName("simple-kmod-driver-container-rhel8")
+	ds.SetNamespace("simple-kmod")
+	ds.Object["spec"] = map[string]interface{}{
+		"template": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"imagePullSecrets": []interface{}{map[string]interface{}{"name": "vendor-pull-secret"}},
+				"containers": []interface{}{
+					map[string]interface{}{"name": "driver", "image": "driver:v1"},
+				},
+			},
+		},
+	}
+
+	if err := injectArtifacts(ds, r); err != nil {
+		t.Fatalf("injectArtifacts failed: %v", err)
+	}
+
+	spec, _, _ := unstructured.NestedMap(ds.Object, "spec", "template", "spec")
+
+	initContainers := spec["initContainers"].([]interface{})
+	if len(initContainers) != 3 {
+		t.Fatalf("got %d init containers, want tools and 2 images", len(initContainers))
+	}
+
+	// Artifact images need no shell, they run the busybox of the tools
+	tools := initContainers[0].(map[string]interface{})
+	if tools["image"] != r.ArtifactsToolsImage {
+		t.Errorf("tools init container = %v", tools)
+	}
+
+	busybox := artifactsToolsMountPath + "/busybox"
+	first := initContainers[1].(map[string]interface{})
+	want := []interface{}{busybox, "sh", "-c", artifactsCopyScript, "artifacts", "/firmware/*.bin", "0", "/LICENSE", "1"}
+	if first["image"] != "quay.io/vendor/firmware:1.0" || !reflect.DeepEqual(first["command"], want) {
+		t.Errorf("first init container = %v", first)
+	}
+
+	// Destination dirs shared by images are copied to the same subPath
+	second := initContainers[2].(map[string]interface{})
+	want = []interface{}{busybox, "sh", "-c", artifactsCopyScript, "artifacts", "/EULA", "1"}
+	if second["image"] != "image-registry.openshift-image-registry.svc:5000/vendor/license:latest" || !reflect.DeepEqual(second["command"], want) {
+		t.Errorf("second init container = %v", second)
+	}
+
+	container := spec["containers"].([]interface{})[0].(map[string]interface{})
+	mounts := map[string]string{}
+	for _, m := range container["volumeMounts"].([]interface{}) {
+		m := m.(map[string]interface{})
+		mounts[m["mountPath"].(string)] = m["name"].(string)
+	}
+	wantMounts := map[string]string{
+		"/lib/firmware/vendor": artifactsVolume,
+		"/licenses":            artifactsVolume,
+		"/etc/vendor":          "specialresource-host-0",
+		"/var/cache/vendor":    "specialresource-claim-0",
+	}
+	if !reflect.DeepEqual(mounts, wantMounts) {
+		t.Errorf("volumeMounts = %v, want %v", mounts, wantMounts)
+	}
+
+	if volumes := spec["volumes"].([]interface{}); len(volumes) != 4 {
+		t.Errorf("got %d volumes, want 4: %v", len(volumes), volumes)
+	}
+
+	if pullSecrets := spec["imagePullSecrets"].([]interface{}); len(pullSecrets) != 1 {
+		t.Errorf("imagePullSecrets = %v, want vendor-pull-secret once", pullSecrets)
+	}
+}
+
+func TestInjectBuildArtifacts(t *testing.T) {
+
+	r := artifactsReconciler()
+	r.BuildRegistry = "registry.local:5000"
+
+	bc := &unstructured.Unstructured{}
+	bc.SetKind("BuildConfig")
+	bc.SetName("simple-kmod-driver-build")
+	bc.SetNamespace("simple-kmod")
+	bc.Object["spec"] = map[string]interface{}{
+		"source": map[string]interface{}{
+			"contextDir": "kmod",
+			"dockerfile": "FROM ubi8\n",
+		},
+		"output": map[string]interface{}{
+			"to": map[string]interface{}{"kind": "ImageStreamTag", "name": "simple-kmod-driver-container:v1"},
+		},
+	}
+
+	if err := injectArtifacts(bc, r); err != nil {
+		t.Fatalf("injectArtifacts failed: %v", err)
+	}
+
+	images, _, _ := unstructured.NestedSlice(bc.Object, "spec", "source", "images")
+	if len(images) != 2 {
+		t.Fatalf("got %d source images, want 2", len(images))
+	}
+	first := images[0].(map[string]interface{})
+	if kind, _, _ := unstructured.NestedString(first, "from", "kind"); kind != "DockerImage" {
+		t.Errorf("source image kind = %s, want DockerImage", kind)
+	}
+	if secret, _, _ := unstructured.NestedString(first, "pullSecret", "name"); secret != "vendor-pull-secret" {
+		t.Errorf("source image pullSecret = %s", secret)
+	}
+	if dir, _, _ := unstructured.NestedString(first["paths"].([]interface{})[0].(map[string]interface{}), "destinationDir"); dir != "lib/firmware/vendor" {
+		t.Errorf("destinationDir = %s, want lib/firmware/vendor", dir)
+	}
+
+	job, err := kanikoJobFrom(bc, r)
+	if err != nil {
+		t.Fatalf("kanikoJobFrom failed: %v", err)
+	}
+
+	initContainers, _, _ := unstructured.NestedSlice(job.Object, "spec", "template", "spec", "initContainers")
+	if len(initContainers) != 4 {
+		t.Fatalf("got %d init containers, want source, tools and 2 source images", len(initContainers))
+	}
+	license := initContainers[3].(map[string]interface{})
+	if license["image"] != "registry.local:5000/vendor/license:latest" {
+		t.Errorf("source image = %v", license["image"])
+	}
+	root, _, _ := unstructured.NestedSlice(license, "env")
+	if value := root[1].(map[string]interface{})["value"]; value != "/workspace/source/kmod" {
+		t.Errorf("ROOT = %v, want /workspace/source/kmod", value)
+	}
+
+	pullSecrets, _, _ := unstructured.NestedSlice(job.Object, "spec", "template", "spec", "imagePullSecrets")
+	if len(pullSecrets) != 1 {
+		t.Errorf("imagePullSecrets = %v, want vendor-pull-secret", pullSecrets)
+	}
+}
+
+func TestValidateArtifactDestinations(t *testing.T) {
+
+	artifacts := artifactsReconciler().specialresource.Spec.DriverContainer.Artifacts
+	containers := []interface{}{
+		map[string]interface{}{"name": "driver", "volumeMounts": []interface{}{
+			map[string]interface{}{"name": "run", "mountPath": "/run/nvidia"},
+		}},
+	}
+
+	if err := validateArtifactDestinations(artifacts, containers); err != nil {
+		t.Errorf("validateArtifactDestinations failed: %v", err)
+	}
+
+	conflicting := artifacts
+	conflicting.HostPaths = []srov1beta1.SpecialResourcePaths{{SourcePath: "/usr/share/licenses", DestinationDir: "/licenses/"}}
+	if err := validateArtifactDestinations(conflicting, containers); err == nil {
+		t.Errorf("validateArtifactDestinations of a host path at an image destination succeeded")
+	}
+
+	conflicting = artifacts
+	conflicting.Claims = []srov1beta1.SpecialResourceClaims{{Name: "run", MountPath: "/run/nvidia"}}
+	if err := validateArtifactDestinations(conflicting, containers); err == nil {
+		t.Errorf("validateArtifactDestinations of a claim at an existing mount succeeded")
+	}
+}
@@ -0,0 +1,428 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openshift-psap/special-resource-operator/registry"
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type imageStreamTag struct {
+	name    string
+	created string
+}
+
+const (
+	buildBackendOpenShift = "openshift"
+	buildBackendKaniko    = "kaniko"
+)
+
+const conditionDriverImageValid = "DriverImageValid"
+
+// getBuildBackend uses OpenShift builds if build.openshift.io and
+// image.openshift.io are served, kaniko Jobs otherwise
+func getBuildBackend() string {
+
+	if runInfo.Capabilities.Builds && runInfo.Capabilities.ImageStreams {
+		return buildBackendOpenShift
+	}
+
+	log.Info("OpenShift builds not available, building with kaniko")
+	return buildBackendKaniko
+}
+
+// buildOutput returns the kind and name the build of a BuildConfig or a
+// kaniko Job is pushed to
+func buildOutput(obj *unstructured.Unstructured) (string, string, error) {
+
+	if obj.GetKind() == "Job" {
+		return "DockerImage", obj.GetAnnotations()["specialresource.openshift.io/build-output"], nil
+	}
+
+	kind, _, err := unstructured.NestedString(obj.Object, "spec", "output", "to", "kind")
+	if err != nil {
+		return "", "", errs.Wrap(err, "Cannot extract output kind of BuildConfig")
+	}
+	name, _, err := unstructured.NestedString(obj.Object, "spec", "output", "to", "name")
+	if err != nil {
+		return "", "", errs.Wrap(err, "Cannot extract output name of BuildConfig")
+	}
+
+	return kind, name, nil
+}
+
+// driverBuildNeeded decides if a build has to run. A build is only needed
+// if there is no image for the kernel we are rendering for. Stale kernel
+// tags of ImageStreams are garbage collected on the way.
+func driverBuildNeeded(obj *unstructured.Unstructured, r *SpecialResourceReconciler) (bool, error) {
+
+	kind, output, err := buildOutput(obj)
+	if err != nil {
+		return false, err
+	}
+
+	// A prebuilt driver-container was found in the registry, other builds
+	// of the recipe e.g. of a device plugin still run
+	if runInfo.DriverImagePrebuilt && buildsDriverImage(obj, kind, output, r) {
+		return false, nil
+	}
+
+	switch kind {
+	case "ImageStreamTag":
+		build, err := imageStreamTagBuildNeeded(obj, output, r)
+		if err != nil || !build {
+			return false, err
+		}
+	case "DockerImage":
+		auths, err := getPushSecretAuths(obj.GetNamespace(), r)
+		if err != nil {
+			return false, err
+		}
+		exists, err := newRegistryClient(auths).Exists(output)
+		if err != nil {
+			return false, errs.Wrap(err, "Cannot look up driver-container image "+output)
+		}
+		if exists {
+			log.Info("Driver-container image found", "Image", output)
+			return false, nil
+		}
+	default:
+		// We can only track images, everything else is built as before
+		return true, nil
+	}
+
+	log.Info("Driver-container image not found, need to build", "Output", output)
+
+	return true, prepareDriverBuild(obj, output, r)
+}
+
+// buildsDriverImage is true if the build pushes the driver-container, to
+// the image we would build without a prebuilt one or to the prebuilt one
+func buildsDriverImage(obj *unstructured.Unstructured, kind string, output string, r *SpecialResourceReconciler) bool {
+
+	image := output
+	if kind == "ImageStreamTag" {
+		namespace, _, _ := unstructured.NestedString(obj.Object, "spec", "output", "to", "namespace")
+		if namespace == "" {
+			namespace = obj.GetNamespace()
+		}
+		image = buildRegistryHost(r) + "/" + namespace + "/" + output
+	}
+
+	return image == internalDriverImage(r) || image == runInfo.DriverImage
+}
+
+func imageStreamTagBuildNeeded(obj *unstructured.Unstructured, output string, r *SpecialResourceReconciler) (bool, error) {
+
+	s := strings.SplitN(output, ":", 2)
+	if len(s) != 2 {
+		return false, errs.New("Output ImageStreamTag has no tag: " + output)
+	}
+	stream, tag := s[0], s[1]
+
+	tags, err := getImageStreamTags(obj.GetNamespace(), stream, r)
+	if err != nil {
+		return false, err
+	}
+
+	if err := pruneDriverImages(obj.GetNamespace(), stream, tags, r); err != nil {
+		return false, errs.Wrap(err, "Cannot prune driver-container images")
+	}
+
+	for _, t := range tags {
+		if t.name == tag {
+			log.Info("Driver-container image found", "ImageStreamTag", output)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// prepareDriverBuild deletes a BuildConfig or Job that builds for another
+// kernel. BuildConfigs are not updated (updates trigger builds in 4.6 but
+// not in <4.6) and Jobs cannot be updated, creating them again triggers the
+// build for the new kernel.
+func prepareDriverBuild(obj *unstructured.Unstructured, output string, r *SpecialResourceReconciler) error {
+
+	found := obj.DeepCopy()
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errs.Wrap(err, "Cannot get "+obj.GetKind())
+	}
+
+	_, current, err := buildOutput(found)
+	if err != nil {
+		return err
+	}
+	switch {
+	// A kaniko Job is not retried once it reached its backoffLimit
+	case isKanikoJob(found) && jobFailed(found):
+		log.Info("Build failed, deleting to retry", "Kind", found.GetKind(), "Name", found.GetName())
+	// A build for this kernel is already running or waiting, nothing to do
+	case current == output:
+		return nil
+	default:
+		log.Info("Build outputs to another kernel, deleting", "Kind", found.GetKind(), "Name", found.GetName(), "Output", current)
+	}
+
+	propagation := client.PropagationPolicy(metav1.DeletePropagationBackground)
+	if err := deleteWithHooks(found, r, propagation); err != nil && !apierrors.IsNotFound(err) {
+		return errs.Wrap(err, "Cannot delete "+found.GetKind())
+	}
+
+	return waitForDeletion(found, r)
+}
+
+func getImageStreamTags(namespace string, stream string, r *SpecialResourceReconciler) ([]imageStreamTag, error) {
+
+	is := &unstructured.Unstructured{}
+	is.SetAPIVersion("image.openshift.io/v1")
+	is.SetKind("ImageStream")
+
+	tags := []imageStreamTag{}
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: stream}, is)
+	if apierrors.IsNotFound(err) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot get ImageStream "+stream)
+	}
+
+	statusTags, _, err := unstructured.NestedSlice(is.Object, "status", "tags")
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot extract tags of ImageStream "+stream)
+	}
+
+	for _, statusTag := range statusTags {
+		switch statusTag := statusTag.(type) {
+		case map[string]interface{}:
+			name, _, _ := unstructured.NestedString(statusTag, "tag")
+			items, _, _ := unstructured.NestedSlice(statusTag, "items")
+			// A tag without items has no image (yet)
+			if len(items) == 0 {
+				continue
+			}
+			created := ""
+			if item, ok := items[0].(map[string]interface{}); ok {
+				created, _, _ = unstructured.NestedString(item, "created")
+			}
+			tags = append(tags, imageStreamTag{name: name, created: created})
+		default:
+			log.Info("tag", "DEFAULT NOT THE CORRECT TYPE", statusTag)
+		}
+	}
+
+	return tags, nil
+}
+
+// runningKernels returns the kernel versions of all nodes selected by the
+// specialresource
+func runningKernels() []string {
+
+	kernels := []string{}
+	for _, node := range node.list.Items {
+		if kernel, found := node.GetLabels()["feature.node.kubernetes.io/kernel-version.full"]; found {
+			kernels = append(kernels, kernel)
+		}
+	}
+	return kernels
+}
+
+// pruneDriverImages keeps all tags of running kernels and the newest
+// Retention.PreviousKernels tags of kernels not running anymore.
+func pruneDriverImages(namespace string, stream string, tags []imageStreamTag, r *SpecialResourceReconciler) error {
+
+	kernels := runningKernels()
+	// Without nodes we cannot tell what is stale
+	if len(kernels) == 0 {
+		return nil
+	}
+
+	stale := []imageStreamTag{}
+	for _, tag := range tags {
+		running := false
+		for _, kernel := range kernels {
+			// Tags are v<kernel>, 4.18.0-240.el8.x86_64 is not
+			// 4.18.0-240.el8.x86_64+debug
+			if tag.name == "v"+kernel {
+				running = true
+				break
+			}
+		}
+		if !running {
+			stale = append(stale, tag)
+		}
+	}
+
+	// RFC3339 timestamps sort lexically, newest first
+	sort.Slice(stale, func(i, j int) bool { return stale[i].created > stale[j].created })
+
+	keep := r.specialresource.Spec.DriverContainer.Retention.PreviousKernels
+	if keep >= len(stale) {
+		return nil
+	}
+
+	for _, tag := range stale[keep:] {
+
+		ist := &unstructured.Unstructured{}
+		ist.SetAPIVersion("image.openshift.io/v1")
+		ist.SetKind("ImageStreamTag")
+		ist.SetNamespace(namespace)
+		ist.SetName(stream + ":" + tag.name)
+
+		log.Info("Pruning stale driver-container image", "ImageStreamTag", ist.GetName())
+		if err := r.Delete(context.TODO(), ist); err != nil && !apierrors.IsNotFound(err) {
+			return errs.Wrap(err, "Cannot delete ImageStreamTag "+ist.GetName())
+		}
+	}
+
+	return nil
+}
+
+// getDriverImage renders the prebuilt image template of the specialresource
+// and looks it up in the registry. Without a template or if the image does
+// not exist (yet) we fall back to the image built in-cluster.
+// buildRegistryHost is the registry builds push to
+func buildRegistryHost(r *SpecialResourceReconciler) string {
+	if runInfo.BuildBackend == buildBackendKaniko {
+		return r.BuildRegistry
+	}
+	return "image-registry.openshift-image-registry.svc:5000"
+}
+
+// internalDriverImage is the driver-container the recipe builds for the
+// kernel we are rendering for
+func internalDriverImage(r *SpecialResourceReconciler) string {
+	return buildRegistryHost(r) + "/" +
+		r.specialresource.Spec.Namespace + "/" +
+		r.specialresource.Name + "-" + runInfo.GroupName.DriverContainer +
+		":v" + runInfo.KernelVersion
+}
+
+func getDriverImage(r *SpecialResourceReconciler) (string, bool, error) {
+
+	internal := internalDriverImage(r)
+
+	if r.specialresource.Spec.DriverContainer.Image == "" {
+		return internal, false, nil
+	}
+
+	// The image is a template from the SpecialResource, report mistakes in
+	// its status
+	image := []byte(r.specialresource.Spec.DriverContainer.Image)
+	err := templateRuntimeInformation(&image, runInfo)
+	setValidCondition(conditionDriverImageValid, err, r)
+	if err != nil {
+		return "", false, errs.Wrap(err, "Cannot render driver-container image")
+	}
+
+	auths, err := getRegistryAuths(r)
+	if err != nil {
+		return "", false, err
+	}
+
+	// The first mirror that has the image wins, Pods pull from the mirrors
+	// of ImageContentSourcePolicies through CRI-O
+	client := newRegistryClient(auths)
+	for _, candidate := range buildMirrorImages(string(image)) {
+		exists, err := client.Exists(candidate)
+		if err != nil {
+			log.Info("Cannot look up prebuilt driver-container", "Image", candidate, "error", fmt.Sprintf("%v", err))
+			continue
+		}
+		if !exists {
+			continue
+		}
+		log.Info("Prebuilt driver-container found", "Image", candidate)
+		if !containsString(mirrorImages(string(image)), candidate) {
+			return string(image), true, nil
+		}
+		return candidate, true, nil
+	}
+
+	log.Info("Prebuilt driver-container not found, building", "Image", string(image))
+	return internal, false, nil
+}
+
+// getInsecureRegistries returns the insecure registries of the operator
+// and of the cluster image config
+func getInsecureRegistries(r *SpecialResourceReconciler) ([]string, error) {
+
+	insecure := append([]string{}, r.InsecureRegistries...)
+
+	if !runInfo.Capabilities.OpenShift {
+		return insecure, nil
+	}
+
+	image, err := configclient.Images().Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return insecure, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, "ConfigClient unable to get Image cluster")
+	}
+
+	return append(insecure, image.Spec.RegistrySources.InsecureRegistries...), nil
+}
+
+// newRegistryClient looks up images in insecure registries without
+// verifying their certificate
+func newRegistryClient(auths map[string]registry.Credentials) *registry.Client {
+	client := registry.NewClient(auths)
+	client.Insecure = runInfo.InsecureRegistries
+	return client
+}
+
+func getRegistryAuths(r *SpecialResourceReconciler) (map[string]registry.Credentials, error) {
+	return getDockerConfigAuths(r.specialresource.Spec.Namespace, r.specialresource.Spec.DriverContainer.ImagePullSecret)
+}
+
+func getPushSecretAuths(namespace string, r *SpecialResourceReconciler) (map[string]registry.Credentials, error) {
+	return getDockerConfigAuths(namespace, r.BuildPushSecret)
+}
+
+func getDockerConfigAuths(namespace string, name string) (map[string]registry.Credentials, error) {
+
+	if name == "" {
+		return nil, nil
+	}
+
+	secret, key, err := getDockerConfigSecret(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.ParseDockerConfig(secret.Data[key])
+}
+
+// getDockerConfigSecret returns the Secret and the key of its docker
+// config, .dockerconfigjson or the legacy .dockercfg
+func getDockerConfigSecret(namespace string, name string) (*corev1.Secret, string, error) {
+
+	secret, err := kubeclient.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", errs.Wrap(err, "Cannot get docker config secret "+name)
+	}
+
+	for _, key := range []string{".dockerconfigjson", ".dockercfg"} {
+		if _, found := secret.Data[key]; found {
+			return secret, key, nil
+		}
+	}
+
+	return nil, "", errs.New("Secret has no docker config: " + name)
+}
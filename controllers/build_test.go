@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGetImageStreamTags(t *testing.T) {
+
+	is := &unstructured.Unstructured{}
+	is.SetKind("ImageStream")
+	is.SetNamespace("simple-kmod")
+	is.SetName("simple-kmod-driver-container")
+	is.Object["status"] = map[string]interface{}{
+		"tags": []interface{}{
+			map[string]interface{}{"tag": "v4.18.0-193", "items": []interface{}{
+				map[string]interface{}{"created": "2020-10-01T10:00:00Z"},
+			}},
+			// A tag without items has no image yet
+			map[string]interface{}{"tag": "v4.18.0-240"},
+		},
+	}
+
+	r := &SpecialResourceReconciler{Client: &fakeClient{objects: []*unstructured.Unstructured{is}}}
+
+	tags, err := getImageStreamTags("simple-kmod", "simple-kmod-driver-container", r)
+	if err != nil {
+		t.Fatalf("getImageStreamTags failed: %v", err)
+	}
+	want := []imageStreamTag{{name: "v4.18.0-193", created: "2020-10-01T10:00:00Z"}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("getImageStreamTags = %v, want %v", tags, want)
+	}
+
+	// A missing ImageStream has no tags
+	tags, err = getImageStreamTags("simple-kmod", "does-not-exist", r)
+	if err != nil || len(tags) != 0 {
+		t.Errorf("getImageStreamTags of a missing ImageStream = %v, %v", tags, err)
+	}
+}
+
+func TestPruneDriverImages(t *testing.T) {
+
+	defer func() { node.list = &unstructured.UnstructuredList{} }()
+
+	running := []unstructured.Unstructured{
+		newNode("worker-0", map[string]string{kernelLabel: "4.18.0-240.el8.x86_64"}),
+		newNode("worker-1", map[string]string{kernelLabel: "4.18.0-240.el8.x86_64"}),
+	}
+
+	tags := []imageStreamTag{
+		{name: "v4.18.0-147.el8.x86_64", created: "2020-06-01T10:00:00Z"},
+		{name: "v4.18.0-240.el8.x86_64", created: "2020-09-01T10:00:00Z"},
+		{name: "v4.18.0-193.el8.x86_64", created: "2020-08-01T10:00:00Z"},
+		{name: "v4.18.0-80.el8.x86_64", created: "2020-01-01T10:00:00Z"},
+		// Contains the running kernel but is another kernel
+		{name: "v4.18.0-240.el8.x86_64+debug", created: "2020-05-01T10:00:00Z"},
+	}
+
+	stream := "simple-kmod-driver-container"
+	ist := func(tag string) string { return "ImageStreamTag/" + stream + ":" + tag }
+
+	tests := []struct {
+		name  string
+		nodes []unstructured.Unstructured
+		keep  int
+		want  []string
+	}{
+		{"keep none", running, 0, []string{ist("v4.18.0-193.el8.x86_64"), ist("v4.18.0-147.el8.x86_64"), ist("v4.18.0-240.el8.x86_64+debug"), ist("v4.18.0-80.el8.x86_64")}},
+		{"keep the newest", running, 1, []string{ist("v4.18.0-147.el8.x86_64"), ist("v4.18.0-240.el8.x86_64+debug"), ist("v4.18.0-80.el8.x86_64")}},
+		{"keep all stale", running, 4, nil},
+		{"keep more than stale", running, 5, nil},
+		{"no nodes", nil, 0, nil},
+	}
+
+	for _, test := range tests {
+
+		node.list = &unstructured.UnstructuredList{Items: test.nodes}
+
+		c := &fakeClient{}
+		r := &SpecialResourceReconciler{Client: c}
+		r.specialresource.Spec.DriverContainer.Retention.PreviousKernels = test.keep
+
+		if err := pruneDriverImages("simple-kmod", stream, tags, r); err != nil {
+			t.Errorf("%s: pruneDriverImages failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.deleted, test.want) {
+			t.Errorf("%s: pruned %v, want %v", test.name, c.deleted, test.want)
+		}
+		// The image of a running kernel must never be pruned
+		for _, deleted := range c.deleted {
+			if deleted == ist("v4.18.0-240.el8.x86_64") {
+				t.Errorf("%s: pruned the image of a running kernel", test.name)
+			}
+		}
+	}
+}
+
+func TestBuildsDriverImage(t *testing.T) {
+
+	saved := runInfo
+	defer func() { runInfo = saved }()
+
+	runInfo.KernelVersion = "4.18.0-240.el8.x86_64"
+	runInfo.DriverImage = "quay.io/vendor/simple-kmod:v4.18.0-240.el8.x86_64"
+	runInfo.DriverImagePrebuilt = true
+
+	r := &SpecialResourceReconciler{BuildRegistry: "registry.local:5000"}
+	r.specialresource.Name = "simple-kmod"
+	r.specialresource.Spec.Namespace = "simple-kmod"
+
+	build := func(namespace string) *unstructured.Unstructured {
+		bc := &unstructured.Unstructured{}
+		bc.SetKind("BuildConfig")
+		bc.SetNamespace(namespace)
+		return bc
+	}
+
+	tests := []struct {
+		backend string
+		kind    string
+		output  string
+		want    bool
+	}{
+		{buildBackendOpenShift, "ImageStreamTag", "simple-kmod-driver-container:v4.18.0-240.el8.x86_64", true},
+		// Other builds of the recipe still run
+		{buildBackendOpenShift, "ImageStreamTag", "simple-kmod-device-plugin:latest", false},
+		{buildBackendOpenShift, "DockerImage", "quay.io/vendor/simple-kmod:v4.18.0-240.el8.x86_64", true},
+		{buildBackendKaniko, "DockerImage", "registry.local:5000/simple-kmod/simple-kmod-driver-container:v4.18.0-240.el8.x86_64", true},
+		{buildBackendKaniko, "DockerImage", "registry.local:5000/simple-kmod/simple-kmod-device-plugin:latest", false},
+	}
+
+	for _, test := range tests {
+		runInfo.BuildBackend = test.backend
+		if got := buildsDriverImage(build("simple-kmod"), test.kind, test.output, r); got != test.want {
+			t.Errorf("buildsDriverImage(%s %s) = %v, want %v", test.kind, test.output, got, test.want)
+		}
+	}
+}
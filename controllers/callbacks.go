@@ -2,154 +2,258 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"sort"
+	"strings"
 
 	errs "github.com/pkg/errors"
-	client "sigs.k8s.io/controller-runtime/pkg/client"
-
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func init() {
+type hookPhase string
+
+// Phases an object goes through, PreRender runs on the templated object
+// before the operator adapts it to the cluster (partition, mirrors, build
+// backend), PreApply before and PostApply after it is created or updated,
+// Ready once PostApply hooks (e.g. waiting) succeeded and PreDelete before
+// the operator deletes it.
+const (
+	hookPreRender hookPhase = "PreRender"
+	hookPreApply  hookPhase = "PreApply"
+	hookPostApply hookPhase = "PostApply"
+	hookReady     hookPhase = "Ready"
+	hookPreDelete hookPhase = "PreDelete"
+)
 
-	customCallback = make(resourceCallbacks)
-	customCallback["specialresource-grafana-configmap"] = customGrafanaConfigMap
+// hookAnnotationPrefix configures a hook per object, the value is a comma
+// separated list of parameters e.g.
+//
+//	hook.specialresource.openshift.io/wait-for-logs: "pattern=loaded"
+//
+// "true" or an empty value run the hook without parameters, "false"
+// disables it.
+const hookAnnotationPrefix = "hook.specialresource.openshift.io/"
+
+// hook runs in one or more phases of an object
+type hook interface {
+	Phases() []hookPhase
+	Run(phase hookPhase, obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error
 }
 
-type resourceCallbacks map[string]func(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error
+// hookFunc adapts a function to a hook that runs in a single phase
+type hookFunc struct {
+	phase hookPhase
+	run   func(obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error
+}
 
-var customCallback resourceCallbacks
+func (h hookFunc) Phases() []hookPhase { return []hookPhase{h.phase} }
 
-func beforeCRUDhooks(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+func (h hookFunc) Run(phase hookPhase, obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error {
+	return h.run(obj, params, r)
+}
 
-	var found bool
-	todo := ""
-	annotations := obj.GetAnnotations()
+var hooks = map[string]hook{}
 
-	if proxy, found := annotations["specialresource.openshift.io/proxy"]; found && proxy == "true" {
-		if err := setupProxy(obj, r); err != nil {
-			return errs.Wrap(err, "Could not setup Proxy")
-		}
+func registerHook(name string, h hook) {
+	if _, found := hooks[name]; found {
+		panic("hook registered twice: " + name)
 	}
+	hooks[name] = h
+}
 
-	if todo, found = annotations["specialresource.openshift.io/callback"]; !found {
-		return nil
-	}
+// legacyHookAnnotations maps the annotations used before hooks were
+// configurable to their hook, the value is passed as parameter
+var legacyHookAnnotations = map[string]struct {
+	hook  string
+	param string
+}{
+	"specialresource.openshift.io/proxy":         {hook: "proxy"},
+	"specialresource.openshift.io/wait":          {hook: "wait"},
+	"specialresource.openshift.io/wait-for":      {hook: "wait", param: "for"},
+	"specialresource.openshift.io/wait-for-logs": {hook: "wait-for-logs", param: "pattern"},
+	// A quorum of Pods is enough e.g. "50%" or "2", all Pods by default
+	"specialresource.openshift.io/wait-for-logs-quorum": {hook: "wait-for-logs", param: "quorum"},
+	"specialresource.openshift.io/state":                {hook: "state", param: "state"},
+}
 
-	if prefix, ok := customCallback[todo]; ok {
-		if err := prefix(obj, r); err != nil {
-			return errs.Wrap(err, "Could not run prefix callback")
-		}
-	}
-	return nil
+func init() {
+
+	registerHook("proxy", hookFunc{hookPreApply, func(obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error {
+		return setupProxy(obj, r)
+	}})
+
+	registerHook("wait", hookFunc{hookPostApply, func(obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error {
+		return waitForResource(obj, r, params)
+	}})
+
+	registerHook("wait-for-logs", hookFunc{hookPostApply, func(obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error {
+		return waitForDaemonSetLogs(obj, r, params)
+	}})
+
+	// Label the nodes according to the state of their Pod
+	// if e.g driver-container ready -> specialresource.openshift.io/driver-container:ready
+	registerHook("state", stateHook{})
+
+	// Replace the driver-container node by node if the specialresource
+	// configures an upgrade
+	registerHook("upgrade", upgradeHook{})
 }
 
-func afterCRUDhooks(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+// parseHookParams splits k=v,k2=v2, a parameter without = has an empty value.
+// Values with commas are quoted e.g. pattern="a{1,3}" or pattern='a{1,3}'.
+func parseHookParams(value string) map[string]string {
 
-	annotations := obj.GetAnnotations()
-	for key, element := range annotations {
-		log.Info("Annotations", "Key:", key, "Element:", element)
+	params := map[string]string{}
+	if value == "" || value == "true" {
+		return params
 	}
 
-	if state, found := annotations["specialresource.openshift.io/state"]; found && state == "driver-container" {
-		log.Info("specialresource.openshift.io/state")
-		if err := checkForImagePullBackOff(obj, r); err != nil {
-			return errs.Wrap(err, "Cannot check for ImagePullBackOff")
+	for _, param := range splitHookParams(value) {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if kv[0] == "" {
+			continue
 		}
-	}
-
-	if wait, found := annotations["specialresource.openshift.io/wait"]; found && wait == "true" {
-		log.Info("specialresource.openshift.io/wait")
-		if err := waitForResource(obj, r); err != nil {
-			return errs.Wrap(err, "Could not wait for resource")
+		if len(kv) == 1 {
+			params[kv[0]] = ""
+			continue
 		}
+		params[kv[0]] = unquoteHookParam(kv[1])
 	}
 
-	if pattern, found := annotations["specialresource.openshift.io/wait-for-logs"]; found && len(pattern) > 0 {
-		log.Info("specialresource.openshift.io/wait-for-logs")
-		if err := waitForDaemonSetLogs(obj, r, pattern); err != nil {
-			return errs.Wrap(err, "Could not wait for DaemonSet logs")
+	return params
+}
+
+// splitHookParams splits value at commas that are not quoted, a quote only
+// starts right after the = of a parameter so values may contain apostrophes
+func splitHookParams(value string) []string {
+
+	params := []string{}
+	var quote rune
+	var prev rune
+	start := 0
+
+	for i, c := range value {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && prev == '=':
+			quote = c
+		case c == ',':
+			params = append(params, value[start:i])
+			start = i + 1
 		}
+		prev = c
 	}
 
-	// If resource available, label the nodes according to the current state
-	// if e.g driver-container ready -> specialresource.openshift.io/driver-container:ready
-	return labelNodesAccordingToState(obj, r)
+	return append(params, value[start:])
 }
 
-func checkForImagePullBackOff(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
-
-	if err := waitForDaemonSet(obj, r); err == nil {
-		return nil
+func unquoteHookParam(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
 	}
+	return value
+}
 
-	labels := obj.GetLabels()
-	value := labels["app"]
+// objectHooks returns the parameters of all hooks configured on obj by
+// name, unknown hooks are an error
+func objectHooks(obj *unstructured.Unstructured) (map[string]map[string]string, error) {
 
-	find := make(map[string]string)
-	find["app"] = value
+	configured := map[string]map[string]string{}
+
+	// Several annotations can configure the same hook e.g. wait and wait-for
+	configure := func(name string, params map[string]string) {
+		if configured[name] == nil {
+			configured[name] = map[string]string{}
+		}
+		for k, v := range params {
+			configured[name][k] = v
+		}
+	}
 
-	// DaemonSet is not coming up, lets check if we have to rebuild
-	pods := &unstructured.UnstructuredList{}
-	pods.SetAPIVersion("v1")
-	pods.SetKind("PodList")
+	for key, value := range obj.GetAnnotations() {
 
-	log.Info("checkForImagePullBackOff get PodList from: " + r.specialresource.Spec.Namespace)
+		if legacy, found := legacyHookAnnotations[key]; found {
+			if legacy.param != "" {
+				if value != "" {
+					configure(legacy.hook, map[string]string{legacy.param: value})
+				}
+				continue
+			}
+			if value == "true" {
+				configure(legacy.hook, nil)
+			}
+			continue
+		}
 
-	opts := []client.ListOption{
-		client.InNamespace(r.specialresource.Spec.Namespace),
-		client.MatchingLabels(find),
-	}
+		if key == "specialresource.openshift.io/callback" {
+			configure(value, nil)
+			continue
+		}
 
-	err := r.List(context.TODO(), pods, opts...)
-	if err != nil {
-		log.Error(err, "Could not get PodList")
-		return err
+		if strings.HasPrefix(key, hookAnnotationPrefix) {
+			if value == "false" {
+				continue
+			}
+			configure(strings.TrimPrefix(key, hookAnnotationPrefix), parseHookParams(value))
+		}
 	}
 
-	if len(pods.Items) == 0 {
-		return fmt.Errorf("No Pods found, reconciling")
+	for name := range configured {
+		if _, found := hooks[name]; !found {
+			return nil, errs.New("Unknown hook " + name + " on " + obj.GetKind() + " " + obj.GetName())
+		}
 	}
 
-	var reason string
+	return configured, nil
+}
 
-	for _, pod := range pods.Items {
-		log.Info("checkForImagePullBackOff", "PodName", pod.GetName())
+// runHooks runs all hooks of obj registered for phase ordered by name
+func runHooks(phase hookPhase, obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
 
-		var err error
-		var found bool
-		var containerStatuses []interface{}
+	configured, err := objectHooks(obj)
+	if err != nil {
+		return err
+	}
 
-		if containerStatuses, found, err = unstructured.NestedSlice(pod.Object, "status", "containerStatuses"); !found || err != nil {
-			phase, found, err := unstructured.NestedString(pod.Object, "status", "phase")
-			exitOnErrorOrNotFound(found, err)
-			log.Info("Pod is in phase: " + phase)
-			continue
-		}
+	names := []string{}
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		for _, containerStatus := range containerStatuses {
-			switch containerStatus := containerStatus.(type) {
-			case map[string]interface{}:
-				reason, found, err = unstructured.NestedString(containerStatus, "state", "waiting", "reason")
-				log.Info("Reason", "reason", reason)
-			default:
-				log.Info("checkForImagePullBackOff", "DEFAULT NOT THE CORRECT TYPE", containerStatus)
+	for _, name := range names {
+		h := hooks[name]
+		for _, p := range h.Phases() {
+			if p != phase {
+				continue
 			}
-			break
-		}
-
-		if reason == "ImagePullBackOff" || reason == "ErrImagePull" {
-			annotations := obj.GetAnnotations()
-			if vendor, ok := annotations["specialresource.openshift.io/driver-container-vendor"]; ok {
-				runInfo.UpdateVendor = vendor
-				return errs.New("ImagePullBackOff need to rebuild" + runInfo.UpdateVendor + "driver-container")
+			log.Info("Running hook", "Phase", phase, "Hook", name, "Kind", obj.GetKind(), "Name", obj.GetName())
+			if err := h.Run(phase, obj, configured[name], r); err != nil {
+				return errs.Wrap(err, string(phase)+" hook "+name+" failed")
 			}
 		}
+	}
+
+	// Hooks of the recipe run out of process after the builtin hooks
+	return runJobHooks(phase, obj, r)
+}
+
+func afterCRUDhooks(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
 
-		log.Info("Unsetting updateVendor, Pods not in ImagePullBackOff or ErrImagePull")
-		runInfo.UpdateVendor = ""
-		return nil
+	if err := runHooks(hookPostApply, obj, r); err != nil {
+		return err
 	}
+	return runHooks(hookReady, obj, r)
+}
+
+// deleteWithHooks runs the PreDelete hooks of obj before deleting it
+func deleteWithHooks(obj *unstructured.Unstructured, r *SpecialResourceReconciler, opts ...client.DeleteOption) error {
 
-	return errs.New("Unexpected Phase of Pods in DameonSet: " + obj.GetName())
+	if err := runHooks(hookPreDelete, obj, r); err != nil {
+		return err
+	}
+	return r.Delete(context.TODO(), obj, opts...)
 }
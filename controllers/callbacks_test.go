@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestObjectHooks(t *testing.T) {
+
+	obj := &unstructured.Unstructured{}
+	obj.SetKind("DaemonSet")
+	obj.SetName("driver")
+	obj.SetAnnotations(map[string]string{
+		"specialresource.openshift.io/wait":          "true",
+		"specialresource.openshift.io/proxy":         "false",
+		"specialresource.openshift.io/wait-for-logs": "loaded, ready",
+		"specialresource.openshift.io/state":         "driver-container",
+		"hook.specialresource.openshift.io/proxy":    "true",
+	})
+
+	configured, err := objectHooks(obj)
+	if err != nil {
+		t.Fatalf("objectHooks failed: %v", err)
+	}
+
+	want := map[string]map[string]string{
+		"wait":          {},
+		"proxy":         {},
+		"wait-for-logs": {"pattern": "loaded, ready"},
+		"state":         {"state": "driver-container"},
+	}
+	if !reflect.DeepEqual(configured, want) {
+		t.Errorf("objectHooks = %v, want %v", configured, want)
+	}
+
+	obj.SetAnnotations(map[string]string{"hook.specialresource.openshift.io/wait": "false"})
+	configured, err = objectHooks(obj)
+	if err != nil {
+		t.Fatalf("objectHooks failed: %v", err)
+	}
+	if _, found := configured["wait"]; found {
+		t.Errorf("objectHooks configured a hook disabled with false: %v", configured)
+	}
+
+	obj.SetAnnotations(map[string]string{"hook.specialresource.openshift.io/does-not-exist": ""})
+	if _, err := objectHooks(obj); err == nil {
+		t.Errorf("objectHooks should fail for unknown hooks")
+	}
+
+	obj.SetAnnotations(map[string]string{"specialresource.openshift.io/callback": "does-not-exist"})
+	if _, err := objectHooks(obj); err == nil {
+		t.Errorf("objectHooks should fail for unknown callbacks")
+	}
+}
+
+func TestParseHookParams(t *testing.T) {
+
+	got := parseHookParams("timeout=5m, phase=Running,force")
+	want := map[string]string{"timeout": "5m", "phase": "Running", "force": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHookParams = %v, want %v", got, want)
+	}
+
+	// Quoted values keep their commas
+	got = parseHookParams(`pattern="a{1,3}",quorum=2, since='1m,',note=it's`)
+	want = map[string]string{"pattern": "a{1,3}", "quorum": "2", "since": "1m,", "note": "it's"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHookParams = %v, want %v", got, want)
+	}
+
+	if got := parseHookParams("true"); len(got) != 0 {
+		t.Errorf("parseHookParams(true) = %v, want no parameters", got)
+	}
+}
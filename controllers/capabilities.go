@@ -0,0 +1,36 @@
+package controllers
+
+// capabilities describe which optional APIs the cluster serves, recipes can
+// branch on them e.g. {{if .Capabilities.OpenShift}}
+type capabilities struct {
+	OpenShift                  bool
+	Builds                     bool
+	ImageStreams               bool
+	Routes                     bool
+	SecurityContextConstraints bool
+	Monitoring                 bool
+}
+
+// getCapabilities asks the discovery API for the group versions of the
+// optional APIs, a missing API is not an error
+func getCapabilities() (capabilities, error) {
+
+	caps := capabilities{}
+
+	for groupVersion, capability := range map[string]*bool{
+		"config.openshift.io/v1":   &caps.OpenShift,
+		"build.openshift.io/v1":    &caps.Builds,
+		"image.openshift.io/v1":    &caps.ImageStreams,
+		"route.openshift.io/v1":    &caps.Routes,
+		"security.openshift.io/v1": &caps.SecurityContextConstraints,
+		"monitoring.coreos.com/v1": &caps.Monitoring,
+	} {
+		available, err := apiGroupVersionAvailable(groupVersion)
+		if err != nil {
+			return caps, err
+		}
+		*capability = available
+	}
+
+	return caps, nil
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// notServedDiscovery answers like the API server for group versions that
+// are not served, the fake discovery returns a plain error
+type notServedDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (d notServedDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+
+	for _, resources := range d.Resources {
+		if resources.GroupVersion == groupVersion {
+			return resources, nil
+		}
+	}
+
+	gv, err := schema.ParseGroupVersion(groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Group: gv.Group, Resource: gv.Version}, "")
+}
+
+type discoveryClientset struct {
+	*fake.Clientset
+	discovery discovery.DiscoveryInterface
+}
+
+func (c discoveryClientset) Discovery() discovery.DiscoveryInterface { return c.discovery }
+
+func newDiscoveryClientset(groupVersions ...string) kubernetes.Interface {
+
+	clientset := fake.NewSimpleClientset()
+	d := clientset.Discovery().(*fakediscovery.FakeDiscovery)
+	for _, groupVersion := range groupVersions {
+		d.Resources = append(d.Resources, &metav1.APIResourceList{GroupVersion: groupVersion})
+	}
+
+	return discoveryClientset{Clientset: clientset, discovery: notServedDiscovery{d}}
+}
+
+func TestCapabilities(t *testing.T) {
+
+	saved, savedInfo := kubeclient, runInfo
+	defer func() { kubeclient, runInfo = saved, savedInfo }()
+
+	kubeclient = newDiscoveryClientset(
+		"v1", "apps/v1",
+		"config.openshift.io/v1", "build.openshift.io/v1", "image.openshift.io/v1",
+		"route.openshift.io/v1", "security.openshift.io/v1", "monitoring.coreos.com/v1",
+	)
+	caps, err := getCapabilities()
+	if err != nil {
+		t.Fatalf("getCapabilities failed on OpenShift: %v", err)
+	}
+	if caps != (capabilities{true, true, true, true, true, true}) {
+		t.Errorf("getCapabilities on OpenShift = %+v", caps)
+	}
+
+	// Kubernetes without any OpenShift API
+	kubeclient = newDiscoveryClientset("v1", "apps/v1")
+	if runInfo.Capabilities, err = getCapabilities(); err != nil {
+		t.Fatalf("getCapabilities failed on Kubernetes: %v", err)
+	}
+	if runInfo.Capabilities != (capabilities{}) {
+		t.Errorf("getCapabilities on Kubernetes = %+v", runInfo.Capabilities)
+	}
+
+	if backend := getBuildBackend(); backend != buildBackendKaniko {
+		t.Errorf("getBuildBackend = %s, want %s", backend, buildBackendKaniko)
+	}
+
+	r := &SpecialResourceReconciler{}
+	r.dependency.ImageReference = "true"
+	if err := createImagePullerRoleBinding(r); err != nil {
+		t.Errorf("createImagePullerRoleBinding failed without ImageStreams: %v", err)
+	}
+
+	if _, cluster, err := getMirrors(r); err != nil || len(cluster) != 0 {
+		t.Errorf("getMirrors without ImageContentSourcePolicies = %v, %v", cluster, err)
+	}
+
+	// The required providers read the nodes, there are none in this test
+	pins := map[string]string{
+		"OperatingSystemMajor":      "rhel8",
+		"OperatingSystemMajorMinor": "rhel8.2",
+		"OperatingSystemDecimal":    "8.2",
+		"KernelVersion":             "4.18.0-193.el8.x86_64",
+	}
+	if err := runRuntimeProviders(r, pins); err != nil {
+		t.Fatalf("runRuntimeProviders failed on Kubernetes: %v", err)
+	}
+	for _, name := range []string{"ClusterVersion", "PushSecretName", "OSImageURL", "Proxy", "Monitoring"} {
+		if available, found := runInfo.Available[name]; !found || available {
+			t.Errorf("%s should be unavailable without its API", name)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+)
+
+const conditionConfigurationValid = "ConfigurationValid"
+
+// configSchema is the subset of JSON schema recipes describe the keys of
+// spec.configuration with in the recipe file e.g.
+//
+//	configuration:
+//	  properties:
+//	    KMOD_NAMES:
+//	      type: array
+//	      items:
+//	        type: string
+//	    KMOD_LOG_LEVEL:
+//	      type: string
+//	      enum: [debug, info]
+//	      default: info
+//	  required: [KMOD_NAMES]
+//
+// Keys that are not a property are typos and rejected unless
+// additionalProperties is true. Templates get the typed and defaulted
+// values as .Config e.g. {{range .Config.KMOD_NAMES}}.
+type configSchema struct {
+	// Type is string (default), integer, number, boolean or array
+	Type        string                  `json:"type,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	Properties  map[string]configSchema `json:"properties,omitempty"`
+	// Items is the schema of the values of an array
+	Items                *configSchema `json:"items,omitempty"`
+	Required             []string      `json:"required,omitempty"`
+	Enum                 []interface{} `json:"enum,omitempty"`
+	Default              interface{}   `json:"default,omitempty"`
+	AdditionalProperties bool          `json:"additionalProperties,omitempty"`
+}
+
+// scalar parses a value of a string, integer, number or boolean property
+func (s configSchema) scalar(value string) (interface{}, error) {
+
+	var v interface{}
+	var err error
+
+	switch s.Type {
+	case "", "string":
+		v = value
+	case "integer":
+		v, err = strconv.ParseInt(value, 10, 64)
+	case "number":
+		v, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		if value != "true" && value != "false" {
+			err = errs.New("not true or false")
+		}
+		v = value == "true"
+	default:
+		return nil, errs.New("unsupported type " + s.Type)
+	}
+	if err != nil {
+		return nil, errs.New(strconv.Quote(value) + " is not " + s.Type)
+	}
+
+	if len(s.Enum) > 0 {
+		allowed := []string{}
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				return v, nil
+			}
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		return nil, errs.New(strconv.Quote(value) + " is not one of " + strings.Join(allowed, ","))
+	}
+
+	return v, nil
+}
+
+// value converts the values of a key to the type of its property
+func (s configSchema) value(values []string) (interface{}, error) {
+
+	if s.Type != "array" {
+		if len(values) != 1 {
+			return nil, errs.New("expects a single value, got " + strconv.Itoa(len(values)))
+		}
+		return s.scalar(values[0])
+	}
+
+	items := configSchema{}
+	if s.Items != nil {
+		items = *s.Items
+	}
+
+	array := []interface{}{}
+	for _, value := range values {
+		v, err := items.scalar(value)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+// defaultValue converts the default of a property, defaults are YAML so
+// numbers are float64 and arrays []interface{}
+func (s configSchema) defaultValue() (interface{}, error) {
+
+	values := []string{}
+	if array, ok := s.Default.([]interface{}); ok {
+		for _, v := range array {
+			values = append(values, fmt.Sprint(v))
+		}
+	} else {
+		values = append(values, fmt.Sprint(s.Default))
+	}
+
+	return s.value(values)
+}
+
+// validateConfiguration returns the configuration of a SpecialResource as
+// typed values with defaults, all violations of the schema otherwise.
+// Without schema values are passed as they are.
+func validateConfiguration(schema *configSchema, configuration []srov1beta1.SpecialResourceConfiguration) (map[string]interface{}, error) {
+
+	config := map[string]interface{}{}
+	violations := []string{}
+
+	seen := map[string]bool{}
+	for _, c := range configuration {
+		if seen[c.Name] {
+			violations = append(violations, c.Name+": set more than once")
+		}
+		seen[c.Name] = true
+
+		// Without schema or for additional keys values are passed as they are
+		property := configSchema{Type: "array"}
+		if schema != nil {
+			p, found := schema.Properties[c.Name]
+			switch {
+			case found:
+				property = p
+			case !schema.AdditionalProperties:
+				violations = append(violations, c.Name+": unknown key")
+				continue
+			}
+		}
+
+		v, err := property.value(c.Value)
+		if err != nil {
+			violations = append(violations, c.Name+": "+err.Error())
+			continue
+		}
+		config[c.Name] = v
+	}
+
+	if schema != nil {
+		for _, name := range schema.Required {
+			if !seen[name] {
+				violations = append(violations, name+": required")
+			}
+		}
+
+		names := []string{}
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property := schema.Properties[name]
+			if seen[name] || property.Default == nil {
+				continue
+			}
+			v, err := property.defaultValue()
+			if err != nil {
+				violations = append(violations, name+": invalid default, "+err.Error())
+				continue
+			}
+			config[name] = v
+		}
+	}
+
+	if len(violations) > 0 {
+		return nil, errs.New("Invalid configuration: " + strings.Join(violations, "; "))
+	}
+
+	return config, nil
+}
+
+// setConfigurationCondition reports if the configuration is valid
+func setConfigurationCondition(err error, r *SpecialResourceReconciler) {
+	setValidCondition(conditionConfigurationValid, err, r)
+}
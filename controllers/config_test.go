@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const testConfigSchema = `
+properties:
+  KMOD_NAMES:
+    type: array
+    items:
+      type: string
+  KMOD_LOG_LEVEL:
+    type: string
+    enum: [debug, info]
+    default: info
+  RETRIES:
+    type: integer
+    default: 3
+  SIGN:
+    type: boolean
+  PORTS:
+    type: array
+    items:
+      type: integer
+    default: [8080, 9090]
+required: [KMOD_NAMES]
+`
+
+func parseTestConfigSchema(t *testing.T) *configSchema {
+	schema := &configSchema{}
+	if err := yaml.Unmarshal([]byte(testConfigSchema), schema); err != nil {
+		t.Fatalf("Cannot parse schema: %v", err)
+	}
+	return schema
+}
+
+func TestValidateConfiguration(t *testing.T) {
+
+	schema := parseTestConfigSchema(t)
+
+	config, err := validateConfiguration(schema, []srov1beta1.SpecialResourceConfiguration{
+		{Name: "KMOD_NAMES", Value: []string{"simple-kmod", "simple-procfs-kmod"}},
+		{Name: "SIGN", Value: []string{"true"}},
+	})
+	if err != nil {
+		t.Fatalf("validateConfiguration: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"KMOD_NAMES":     []interface{}{"simple-kmod", "simple-procfs-kmod"},
+		"SIGN":           true,
+		"KMOD_LOG_LEVEL": "info",
+		"RETRIES":        int64(3),
+		"PORTS":          []interface{}{int64(8080), int64(9090)},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %#v, want %#v", config, want)
+	}
+}
+
+func TestValidateConfigurationViolations(t *testing.T) {
+
+	schema := parseTestConfigSchema(t)
+
+	_, err := validateConfiguration(schema, []srov1beta1.SpecialResourceConfiguration{
+		{Name: "KMOD_NAME", Value: []string{"simple-kmod"}},
+		{Name: "KMOD_LOG_LEVEL", Value: []string{"trace"}},
+		{Name: "RETRIES", Value: []string{"1", "2"}},
+		{Name: "SIGN", Value: []string{"yes"}},
+		{Name: "SIGN", Value: []string{"true"}},
+	})
+	if err == nil {
+		t.Fatalf("invalid configuration should fail")
+	}
+
+	for _, violation := range []string{
+		"KMOD_NAME: unknown key",
+		"KMOD_NAMES: required",
+		`KMOD_LOG_LEVEL: "trace" is not one of debug,info`,
+		"RETRIES: expects a single value",
+		`SIGN: "yes" is not boolean`,
+		"SIGN: set more than once",
+	} {
+		if !strings.Contains(err.Error(), violation) {
+			t.Errorf("%v does not report %s", err, violation)
+		}
+	}
+
+	schema.AdditionalProperties = true
+	config, err := validateConfiguration(schema, []srov1beta1.SpecialResourceConfiguration{
+		{Name: "KMOD_NAMES", Value: []string{"simple-kmod"}},
+		{Name: "EXTRA", Value: []string{"a"}},
+	})
+	if err != nil || !reflect.DeepEqual(config["EXTRA"], []interface{}{"a"}) {
+		t.Errorf("additional key = %v, %v", config, err)
+	}
+}
+
+func TestValidateConfigurationWithoutSchema(t *testing.T) {
+
+	config, err := validateConfiguration(nil, []srov1beta1.SpecialResourceConfiguration{
+		{Name: "KMOD_NAMES", Value: []string{"simple-kmod"}},
+	})
+	if err != nil || !reflect.DeepEqual(config["KMOD_NAMES"], []interface{}{"simple-kmod"}) {
+		t.Errorf("config without schema = %v, %v", config, err)
+	}
+}
+
+func TestConfigTemplate(t *testing.T) {
+
+	ri := runInfo
+	defer func() { runInfo = ri }()
+	runInfo.Config = map[string]interface{}{"KMOD_NAMES": []interface{}{"simple-kmod", "simple-procfs-kmod"}}
+
+	spec := []byte(`value: "{{range $i, $kmod := .Config.KMOD_NAMES}}{{if $i}} {{end}}{{$kmod}}{{end}}"`)
+	if err := templateRuntimeInformation(&spec, runInfo); err != nil {
+		t.Fatalf("Cannot render: %v", err)
+	}
+	if string(spec) != `value: "simple-kmod simple-procfs-kmod"` {
+		t.Errorf("rendered %s", spec)
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func init() {
+	// The reconciler sets the logger, tests call helpers directly
+	log = logf.NullLogger{}
+}
+
+func newNode(name string, labels map[string]string) unstructured.Unstructured {
+	node := unstructured.Unstructured{}
+	node.SetName(name)
+	node.SetLabels(labels)
+	return node
+}
+
+// fakeClient serves Get, Create and Update of unstructured objects and
+// records deletes, all other calls panic on the embedded nil client
+type fakeClient struct {
+	client.Client
+	objects []*unstructured.Unstructured
+	deleted []string
+}
+
+func (c *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+
+	u := obj.(*unstructured.Unstructured)
+	for _, o := range c.objects {
+		if o.GetKind() == u.GetKind() && o.GetNamespace() == key.Namespace && o.GetName() == key.Name {
+			u.Object = o.DeepCopy().Object
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Resource: u.GetKind()}, key.Name)
+}
+
+func (c *fakeClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	u := obj.(*unstructured.Unstructured)
+	c.deleted = append(c.deleted, u.GetKind()+"/"+u.GetName())
+	return nil
+}
+
+func (c *fakeClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.objects = append(c.objects, obj.(*unstructured.Unstructured).DeepCopy())
+	return nil
+}
+
+func (c *fakeClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	u := obj.(*unstructured.Unstructured)
+	for i, o := range c.objects {
+		if o.GetKind() == u.GetKind() && o.GetNamespace() == u.GetNamespace() && o.GetName() == u.GetName() {
+			c.objects[i] = u.DeepCopy()
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Resource: u.GetKind()}, u.GetName())
+}
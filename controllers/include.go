@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"github.com/openshift-psap/special-resource-operator/yamlutil"
+	errs "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// includeIfAnnotation is a template condition, the object is only applied
+// if it is true and pruned otherwise e.g.
+//
+//	specialresource.openshift.io/include-if: "not .DriverImagePrebuilt"
+//	specialresource.openshift.io/include-if: ".Available.Monitoring"
+//
+// States of a recipe have the same condition as includeIf.
+const includeIfAnnotation = "specialresource.openshift.io/include-if"
+
+// evaluateIncludeIf evaluates condition against the runtime information,
+// an empty condition is true
+func evaluateIncludeIf(condition string) (bool, error) {
+
+	if condition == "" {
+		return true, nil
+	}
+
+	t, err := template.New("include-if").Funcs(runInfo.templateFuncs()).Parse("{{if " + condition + "}}true{{end}}")
+	if err != nil {
+		return false, errs.Wrap(err, "Cannot parse include-if "+condition)
+	}
+
+	var buff bytes.Buffer
+	if err := t.Execute(&buff, runInfo.templateContext()); err != nil {
+		return false, errs.Wrap(err, "Cannot evaluate include-if "+condition)
+	}
+
+	return buff.String() == "true", nil
+}
+
+// includeObject returns false if the include-if condition of obj is false,
+// any previous instance of obj is pruned then
+func includeObject(obj *unstructured.Unstructured, r *SpecialResourceReconciler) (bool, error) {
+
+	condition := obj.GetAnnotations()[includeIfAnnotation]
+	object := obj.GetKind() + "/" + obj.GetName()
+
+	include, err := evaluateIncludeIf(condition)
+	if err != nil {
+		return false, errs.Wrap(err, "Cannot evaluate "+object)
+	}
+	if include {
+		updateSkippedStatus(object, "", r)
+		return true, nil
+	}
+
+	log.Info("Skipping", "Object", object, "IncludeIf", condition)
+	if err := pruneObject(obj, r); err != nil {
+		return false, err
+	}
+	updateSkippedStatus(object, condition, r)
+
+	return false, nil
+}
+
+// renderObject renders a document of a manifest, nil if it rendered empty
+func renderObject(yamlSpec []byte, namespace string) (*unstructured.Unstructured, error) {
+
+	// We can pass template information from the CR to the yamls
+	// thats why we are running 2 passes.
+	if err := templateRuntimeInformation(&yamlSpec, runInfo); err != nil {
+		return nil, errs.Wrap(err, "Cannot inject runtime information 1st pass")
+	}
+
+	if err := templateRuntimeInformation(&yamlSpec, runInfo); err != nil {
+		return nil, errs.Wrap(err, "Cannot inject runtime information 2nd pass")
+	}
+
+	// Objects can be rendered conditionally e.g. {{if .Available.Monitoring}}
+	if len(bytes.TrimSpace(yamlSpec)) == 0 {
+		return nil, nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	jsonSpec, err := yaml.YAMLToJSON(yamlSpec)
+	if err != nil {
+		return nil, errs.Wrap(err, "Could not convert yaml file to json"+string(yamlSpec))
+	}
+
+	err = obj.UnmarshalJSON(jsonSpec)
+	exitOnError(errs.Wrap(err, "Cannot unmarshall json spec, check your manifests"))
+
+	if resourceNamespaced(obj.GetKind()) {
+		obj.SetNamespace(namespace)
+	}
+
+	return obj, nil
+}
+
+// pruneFromYAML prunes all objects of a skipped state
+func pruneFromYAML(yamlFile []byte, r *SpecialResourceReconciler, namespace string) error {
+
+	scanner := yamlutil.NewYAMLScanner(yamlFile)
+
+	for scanner.Scan() {
+		obj, err := renderObject(scanner.Bytes(), namespace)
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			continue
+		}
+		if err := pruneObject(obj, r); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errs.Wrap(err, "Failed to scan manifest")
+	}
+	return nil
+}
+
+// pruneObject deletes obj if it exists and is controlled by the
+// SpecialResource, objects created by others or retained are left alone
+func pruneObject(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	found := &unstructured.Unstructured{}
+	found.SetAPIVersion(obj.GetAPIVersion())
+	found.SetKind(obj.GetKind())
+
+	object := obj.GetKind() + "/" + obj.GetName()
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found)
+	// Without the CRD there is nothing to prune
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return errs.Wrap(err, "Cannot get "+object)
+	}
+
+	if found.GetAnnotations()[retainAnnotation] == "true" {
+		log.Info("Not pruning, retained", "Object", object)
+		return nil
+	}
+
+	if owner := metav1.GetControllerOf(found); owner == nil || owner.UID != r.specialresource.GetUID() {
+		log.Info("Not pruning, not controlled by SpecialResource", "Object", object)
+		return nil
+	}
+
+	log.Info("Pruning", "Object", object)
+	if err := r.Delete(context.TODO(), found); err != nil && !apierrors.IsNotFound(err) {
+		return errs.Wrap(err, "Cannot prune "+object)
+	}
+
+	return nil
+}
+
+// updateSkippedStatus records that object was skipped because condition
+// is false, an empty condition removes the record
+func updateSkippedStatus(object string, condition string, r *SpecialResourceReconciler) {
+
+	changed := condition != ""
+	skipped := []srov1beta1.SpecialResourceSkippedStatus{}
+	for _, s := range r.specialresource.Status.Skipped {
+		if s.Object != object || s.Partition != runInfo.Partition {
+			skipped = append(skipped, s)
+			continue
+		}
+		// Nothing to do if the record did not change
+		changed = s.Condition != condition
+	}
+	if !changed {
+		return
+	}
+
+	if condition != "" {
+		skipped = append(skipped, srov1beta1.SpecialResourceSkippedStatus{
+			Object:    object,
+			Partition: runInfo.Partition,
+			Condition: condition,
+		})
+	}
+	r.specialresource.Status.Skipped = skipped
+
+	if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+		log.Error(err, "Failed to update SpecialResource skipped status")
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestEvaluateIncludeIf(t *testing.T) {
+
+	ri := runInfo
+	defer func() { runInfo = ri }()
+
+	runInfo.DriverImagePrebuilt = true
+	runInfo.Available = map[string]bool{"Monitoring": false}
+	runInfo.Capabilities.Monitoring = true
+
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{"", true},
+		{"not .DriverImagePrebuilt", false},
+		{".Available.Monitoring", false},
+		{".Capabilities.Monitoring", true},
+		{"and .Capabilities.Monitoring .DriverImagePrebuilt", true},
+		{`eq (stateLabel "driver") "specialresource.openshift.io/driver-"`, true},
+	}
+
+	for _, tt := range tests {
+		got, err := evaluateIncludeIf(tt.condition)
+		if err != nil {
+			t.Errorf("evaluateIncludeIf(%q): %v", tt.condition, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("evaluateIncludeIf(%q) = %v, want %v", tt.condition, got, tt.want)
+		}
+	}
+
+	if _, err := evaluateIncludeIf("}}{{"); err == nil {
+		t.Errorf("invalid condition should fail")
+	}
+}
+
+func TestRenderObject(t *testing.T) {
+
+	obj, err := renderObject([]byte("{{if .DriverImagePrebuilt}}\napiVersion: v1\nkind: ConfigMap\n{{end}}"), "ns")
+	if err != nil || obj != nil {
+		t.Errorf("empty document = %v, %v, want nil", obj, err)
+	}
+
+	obj, err = renderObject([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n"), "ns")
+	if err != nil {
+		t.Fatalf("renderObject: %v", err)
+	}
+	if obj.GetKind() != "ConfigMap" || obj.GetNamespace() != "ns" {
+		t.Errorf("rendered %s in %s", obj.GetKind(), obj.GetNamespace())
+	}
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// retainAnnotation keeps an object that is no longer rendered or skipped
+// from being pruned, it is checked on the object in the cluster
+const retainAnnotation = "specialresource.openshift.io/retain"
+
+// inventory are the objects rendered for the SpecialResource we are
+// reconciling, across all partitions
+var inventory = map[srov1beta1.SpecialResourceInventoryObject]bool{}
+
+func inventoryObjectFrom(obj *unstructured.Unstructured) srov1beta1.SpecialResourceInventoryObject {
+	return srov1beta1.SpecialResourceInventoryObject{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+// recordInventory records obj as rendered, objects that are rendered but
+// not applied e.g. a build that is not needed are kept as well
+func recordInventory(obj *unstructured.Unstructured) {
+	inventory[inventoryObjectFrom(obj)] = true
+}
+
+// removedObjects returns the objects of previous that are not rendered
+func removedObjects(previous []srov1beta1.SpecialResourceInventoryObject, rendered map[srov1beta1.SpecialResourceInventoryObject]bool) []srov1beta1.SpecialResourceInventoryObject {
+
+	removed := []srov1beta1.SpecialResourceInventoryObject{}
+	for _, obj := range previous {
+		if !rendered[obj] {
+			removed = append(removed, obj)
+		}
+	}
+	return removed
+}
+
+// sortedInventory returns objects sorted by kind, namespace and name
+func sortedInventory(objects map[srov1beta1.SpecialResourceInventoryObject]bool) []srov1beta1.SpecialResourceInventoryObject {
+
+	sorted := make([]srov1beta1.SpecialResourceInventoryObject, 0, len(objects))
+	for obj := range objects {
+		sorted = append(sorted, obj)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.APIVersion < b.APIVersion
+	})
+	return sorted
+}
+
+// reconcileInventory prunes the objects of the last inventory that were not
+// rendered, only if complete i.e. all states were rendered. Otherwise the
+// inventory grows until a reconcile completes.
+func reconcileInventory(r *SpecialResourceReconciler, complete bool) {
+
+	objects := map[srov1beta1.SpecialResourceInventoryObject]bool{}
+	for obj := range inventory {
+		objects[obj] = true
+	}
+
+	for _, removed := range removedObjects(r.specialresource.Status.Inventory, inventory) {
+		if !complete {
+			objects[removed] = true
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(removed.APIVersion)
+		obj.SetKind(removed.Kind)
+		obj.SetNamespace(removed.Namespace)
+		obj.SetName(removed.Name)
+
+		log.Info("Not rendered anymore", "Object", removed.Kind+"/"+removed.Name)
+		if err := pruneObject(obj, r); err != nil {
+			// Try again with the next reconcile
+			objects[removed] = true
+			log.Error(err, "Cannot prune", "Object", removed.Kind+"/"+removed.Name)
+		}
+	}
+
+	sorted := sortedInventory(objects)
+	if reflect.DeepEqual(sorted, r.specialresource.Status.Inventory) {
+		return
+	}
+	r.specialresource.Status.Inventory = sorted
+
+	if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+		log.Error(err, "Failed to update SpecialResource inventory")
+	}
+}
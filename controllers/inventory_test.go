@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestInventory(t *testing.T) {
+
+	defer func() { inventory = map[srov1beta1.SpecialResourceInventoryObject]bool{} }()
+	inventory = map[srov1beta1.SpecialResourceInventoryObject]bool{}
+
+	for _, o := range []struct{ kind, namespace, name string }{
+		{"DaemonSet", "nvidia-gpu", "driver"},
+		{"ConfigMap", "nvidia-gpu", "entrypoint"},
+		{"Namespace", "", "nvidia-gpu"},
+		// Rendered twice e.g. BuildConfig and the kaniko Job of it
+		{"DaemonSet", "nvidia-gpu", "driver"},
+	} {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind(o.kind)
+		obj.SetNamespace(o.namespace)
+		obj.SetName(o.name)
+		recordInventory(obj)
+	}
+
+	sorted := sortedInventory(inventory)
+	want := []srov1beta1.SpecialResourceInventoryObject{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "nvidia-gpu", Name: "entrypoint"},
+		{APIVersion: "v1", Kind: "DaemonSet", Namespace: "nvidia-gpu", Name: "driver"},
+		{APIVersion: "v1", Kind: "Namespace", Name: "nvidia-gpu"},
+	}
+	if !reflect.DeepEqual(sorted, want) {
+		t.Errorf("sortedInventory = %+v, want %+v", sorted, want)
+	}
+
+	renamed := srov1beta1.SpecialResourceInventoryObject{APIVersion: "v1", Kind: "DaemonSet", Namespace: "nvidia-gpu", Name: "driver-old"}
+	previous := append([]srov1beta1.SpecialResourceInventoryObject{renamed}, want...)
+
+	removed := removedObjects(previous, inventory)
+	if !reflect.DeepEqual(removed, []srov1beta1.SpecialResourceInventoryObject{renamed}) {
+		t.Errorf("removedObjects = %+v, want %+v", removed, renamed)
+	}
+}
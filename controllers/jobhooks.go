@@ -0,0 +1,391 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A Job of a recipe annotated with a hook phase and target is not created
+// with the other objects, it runs whenever the target reaches the phase e.g.
+//
+//	specialresource.openshift.io/hook-phase: PostApply
+//	specialresource.openshift.io/hook-target: ConfigMap/grafana-datasources
+//
+// The Job has to come before its target in the manifests.
+const (
+	jobHookPhaseAnnotation  = "specialresource.openshift.io/hook-phase"
+	jobHookTargetAnnotation = "specialresource.openshift.io/hook-target"
+	jobHookHashAnnotation   = "specialresource.openshift.io/hook-hash"
+)
+
+// jobHookOutputLines and jobHookOutputBytes limit how much of the logs of
+// a hook is kept in status
+const (
+	jobHookOutputLines = 20
+	jobHookOutputBytes = 1024
+)
+
+// jobHooks are the hook Jobs of the recipe we are reconciling
+var jobHooks = []*unstructured.Unstructured{}
+
+func isJobHook(obj *unstructured.Unstructured) bool {
+	_, found := obj.GetAnnotations()[jobHookPhaseAnnotation]
+	return obj.GetKind() == "Job" && found
+}
+
+// registerJobHook validates and keeps a hook Job until its target reaches
+// the phase
+func registerJobHook(obj *unstructured.Unstructured) error {
+
+	annotations := obj.GetAnnotations()
+
+	phase := hookPhase(annotations[jobHookPhaseAnnotation])
+	switch phase {
+	case hookPreRender, hookPreApply, hookPostApply, hookReady, hookPreDelete:
+	default:
+		return errs.New("Unknown hook phase " + string(phase) + " of Job " + obj.GetName())
+	}
+
+	if !strings.Contains(annotations[jobHookTargetAnnotation], "/") {
+		return errs.New("Job " + obj.GetName() + " needs a hook target as Kind/Name")
+	}
+
+	if addJobHook(obj) {
+		log.Info("Registering hook Job", "Name", obj.GetName(), "Phase", phase, "Target", annotations[jobHookTargetAnnotation])
+	}
+
+	return nil
+}
+
+// addJobHook replaces the hook Job of the same name, it is true if obj is
+// a new hook Job
+func addJobHook(obj *unstructured.Unstructured) bool {
+
+	for i, h := range jobHooks {
+		if h.GetName() == obj.GetName() {
+			jobHooks[i] = obj
+			return false
+		}
+	}
+	jobHooks = append(jobHooks, obj)
+
+	return true
+}
+
+// runJobHooks runs all hook Jobs for obj and phase one after the other
+func runJobHooks(phase hookPhase, obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	target := obj.GetKind() + "/" + obj.GetName()
+
+	for _, h := range jobHooks {
+		annotations := h.GetAnnotations()
+		if hookPhase(annotations[jobHookPhaseAnnotation]) != phase || annotations[jobHookTargetAnnotation] != target {
+			continue
+		}
+
+		log.Info("Running hook Job", "Phase", phase, "Name", h.GetName(), "Target", target)
+
+		output, err := runJobHook(h, phase, obj, r)
+
+		status := srov1beta1.SpecialResourceHookStatus{
+			Name:   h.GetName(),
+			Phase:  string(phase),
+			Target: target,
+			State:  "Succeeded",
+			Output: output,
+		}
+		if err != nil {
+			status.State = "Failed"
+		}
+		updateHookStatus(status, r)
+
+		if err != nil {
+			return errs.Wrap(err, "Hook Job "+h.GetName()+" failed")
+		}
+	}
+
+	return nil
+}
+
+// runJobHook creates the Job with the runtime information as env, waits
+// for its completion and returns the tail of its logs. A Job that already
+// ran with the same spec is not run again.
+func runJobHook(hook *unstructured.Unstructured, phase hookPhase, target *unstructured.Unstructured, r *SpecialResourceReconciler) (string, error) {
+
+	job := hook.DeepCopy()
+
+	// The env differs per kernel, each partition runs its own Job
+	job.SetName(jobHookName(hook.GetName(), runInfo.Partition))
+	recordInventory(job)
+
+	if err := setJobHookEnv(job, jobHookEnv(phase, target)); err != nil {
+		return "", err
+	}
+
+	// The hook image is pulled like every other image of the recipe
+	if err := rewriteMirrors(job, nil); err != nil {
+		return "", errs.Wrap(err, "Cannot rewrite mirrors of hook Job "+job.GetName())
+	}
+	if err := runHooks(hookPreApply, job, r); err != nil {
+		return "", err
+	}
+
+	hash, err := jobHookHash(job)
+	if err != nil {
+		return "", err
+	}
+	annotations := job.GetAnnotations()
+	annotations[jobHookHashAnnotation] = hash
+	job.SetAnnotations(annotations)
+
+	found := job.DeepCopy()
+	err = r.Get(context.TODO(), types.NamespacedName{Namespace: job.GetNamespace(), Name: job.GetName()}, found)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", errs.Wrap(err, "Cannot get hook Job "+job.GetName())
+	}
+
+	// Jobs cannot be updated, a Job of an older spec or a Job that gave up
+	// is replaced
+	if err == nil && (found.GetAnnotations()[jobHookHashAnnotation] != hash || jobFailed(found)) {
+		log.Info("Hook Job changed or failed, deleting", "Name", job.GetName())
+		propagation := client.PropagationPolicy(metav1.DeletePropagationBackground)
+		if err := r.Delete(context.TODO(), found, propagation); err != nil && !apierrors.IsNotFound(err) {
+			return "", errs.Wrap(err, "Cannot delete hook Job "+job.GetName())
+		}
+		if err := waitForDeletion(found, r); err != nil {
+			return "", errs.Wrap(err, "Hook Job "+job.GetName()+" was not deleted")
+		}
+	}
+
+	if err := CRUD(job, r); err != nil {
+		return "", errs.Wrap(err, "Cannot create hook Job "+job.GetName())
+	}
+
+	waitErr := waitForJobHook(job, r)
+
+	output, err := jobHookOutput(job)
+	if err != nil {
+		log.Info("Cannot get output of hook Job", "Name", job.GetName(), "error", err.Error())
+	}
+
+	return output, waitErr
+}
+
+// waitForJobHook waits until the Job succeeded or gave up
+func waitForJobHook(job *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	if err := waitForResourceAvailability(job, r); err != nil {
+		return err
+	}
+
+	failed := false
+	if err := waitForResourceFullAvailability(job, r, func(obj *unstructured.Unstructured) bool {
+		failed = jobFailed(obj)
+		return failed || waitForJobCallback(obj)
+	}); err != nil {
+		return err
+	}
+	if failed {
+		return errs.New("Hook Job " + job.GetName() + " failed")
+	}
+
+	return nil
+}
+
+// jobHookName appends the partition to the name of a hook Job, names that
+// would be too long for the job-name label of its Pods are shortened with a
+// hash
+func jobHookName(name string, partition string) string {
+
+	if partition == "" || strings.Contains(name, partition) {
+		return name
+	}
+
+	name = name + "-" + partition
+	if len(name) <= 63 {
+		return name
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return strings.TrimRight(name[:54], "-.") + "-" + fmt.Sprintf("%08x", h.Sum32())
+}
+
+// jobHookEnv exposes the phase, target and every scalar runtime information
+// e.g. KernelVersion as SRO_KERNEL_VERSION
+func jobHookEnv(phase hookPhase, target *unstructured.Unstructured) []interface{} {
+
+	values := map[string]string{
+		"SRO_HOOK_PHASE":       string(phase),
+		"SRO_TARGET_KIND":      target.GetKind(),
+		"SRO_TARGET_NAME":      target.GetName(),
+		"SRO_TARGET_NAMESPACE": target.GetNamespace(),
+	}
+
+	for key, value := range runInfo.templateContext() {
+		switch value.(type) {
+		case string, bool, int, int32, int64, float64:
+			values["SRO_"+envName(key)] = fmt.Sprintf("%v", value)
+		}
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := []interface{}{}
+	for _, name := range names {
+		env = append(env, map[string]interface{}{"name": name, "value": values[name]})
+	}
+
+	return env
+}
+
+// envName converts KernelVersion to KERNEL_VERSION and OSImageURL to
+// OS_IMAGE_URL
+func envName(key string) string {
+
+	runes := []rune(key)
+	var b strings.Builder
+
+	for i, c := range runes {
+		if i > 0 && unicode.IsUpper(c) {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+
+	return b.String()
+}
+
+func setJobHookEnv(job *unstructured.Unstructured, env []interface{}) error {
+
+	path := podSpecPaths["Job"]
+
+	for _, field := range []string{"initContainers", "containers"} {
+
+		fields := append(append([]string{}, path...), field)
+		containers, found, err := unstructured.NestedSlice(job.Object, fields...)
+		if err != nil {
+			return errs.Wrap(err, "Cannot extract "+field+" of hook Job "+job.GetName())
+		}
+		if !found {
+			continue
+		}
+
+		for i, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			current, _, _ := unstructured.NestedSlice(container, "env")
+			container["env"] = mergeEnv(current, env)
+			containers[i] = container
+		}
+
+		if err := unstructured.SetNestedSlice(job.Object, containers, fields...); err != nil {
+			return errs.Wrap(err, "Cannot set "+field+" of hook Job "+job.GetName())
+		}
+	}
+
+	return nil
+}
+
+// jobHookHash identifies the rendered spec of a hook Job
+func jobHookHash(job *unstructured.Unstructured) (string, error) {
+
+	spec, _, err := unstructured.NestedMap(job.Object, "spec")
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot extract spec of hook Job "+job.GetName())
+	}
+
+	data, err := (&unstructured.Unstructured{Object: spec}).MarshalJSON()
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot marshal spec of hook Job "+job.GetName())
+	}
+
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32()), nil
+}
+
+// jobHookOutput returns the tail of the logs of the newest Pod of job
+func jobHookOutput(job *unstructured.Unstructured) (string, error) {
+
+	pods, err := kubeclient.CoreV1().Pods(job.GetNamespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=" + job.GetName(),
+	})
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot list Pods of hook Job "+job.GetName())
+	}
+	if len(pods.Items) == 0 {
+		return "", nil
+	}
+
+	newest := pods.Items[0]
+	for _, pod := range pods.Items {
+		if newest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			newest = pod
+		}
+	}
+
+	lines := int64(jobHookOutputLines)
+	req := kubeclient.CoreV1().Pods(newest.Namespace).GetLogs(newest.Name, &corev1.PodLogOptions{TailLines: &lines})
+	logs, err := req.Stream(context.TODO())
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot open logs of Pod "+newest.Name)
+	}
+	defer logs.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, logs); err != nil {
+		return "", errs.Wrap(err, "Cannot read logs of Pod "+newest.Name)
+	}
+
+	return tail(buf.String(), jobHookOutputBytes), nil
+}
+
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// updateHookStatus replaces the last result of the same hook, phase and
+// target in the status of the specialresource
+func updateHookStatus(status srov1beta1.SpecialResourceHookStatus, r *SpecialResourceReconciler) {
+
+	hooks := []srov1beta1.SpecialResourceHookStatus{}
+	for _, h := range r.specialresource.Status.Hooks {
+		if h.Name != status.Name || h.Phase != status.Phase || h.Target != status.Target {
+			hooks = append(hooks, h)
+		}
+	}
+	r.specialresource.Status.Hooks = append(hooks, status)
+
+	if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+		log.Error(err, "Failed to update SpecialResource hook status")
+	}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestEnvName(t *testing.T) {
+
+	tests := map[string]string{
+		"KernelVersion":             "KERNEL_VERSION",
+		"OSImageURL":                "OS_IMAGE_URL",
+		"OperatingSystemMajorMinor": "OPERATING_SYSTEM_MAJOR_MINOR",
+		"Arch":                      "ARCH",
+		"DriverImagePrebuilt":       "DRIVER_IMAGE_PREBUILT",
+	}
+
+	for key, want := range tests {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%s) = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func newHookJob(name string, phase string, target string) *unstructured.Unstructured {
+
+	job := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":  "hook",
+							"image": "quay.io/vendor/hook:latest",
+							"env": []interface{}{
+								map[string]interface{}{"name": "SRO_TARGET_NAME", "value": "stale"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+	job.SetAPIVersion("batch/v1")
+	job.SetKind("Job")
+	job.SetName(name)
+	job.SetAnnotations(map[string]string{
+		jobHookPhaseAnnotation:  phase,
+		jobHookTargetAnnotation: target,
+	})
+
+	return job
+}
+
+func TestRegisterJobHook(t *testing.T) {
+
+	defer func() { jobHooks = []*unstructured.Unstructured{} }()
+	jobHooks = []*unstructured.Unstructured{}
+
+	if err := registerJobHook(newHookJob("hook", "PostApply", "ConfigMap/grafana")); err != nil {
+		t.Fatalf("registerJobHook failed: %v", err)
+	}
+	// Registering a Job with the same name again replaces it
+	if err := registerJobHook(newHookJob("hook", "Ready", "ConfigMap/grafana")); err != nil {
+		t.Fatalf("registerJobHook failed: %v", err)
+	}
+	if len(jobHooks) != 1 || jobHooks[0].GetAnnotations()[jobHookPhaseAnnotation] != "Ready" {
+		t.Errorf("registerJobHook did not replace the hook: %v", jobHooks)
+	}
+
+	if err := registerJobHook(newHookJob("bad", "AfterLunch", "ConfigMap/grafana")); err == nil {
+		t.Errorf("registerJobHook should fail for unknown phases")
+	}
+	if err := registerJobHook(newHookJob("bad", "PostApply", "grafana")); err == nil {
+		t.Errorf("registerJobHook should fail for targets without kind")
+	}
+}
+
+func TestSetJobHookEnv(t *testing.T) {
+
+	target := &unstructured.Unstructured{}
+	target.SetKind("ConfigMap")
+	target.SetName("grafana")
+	target.SetNamespace("nvidia-gpu")
+
+	job := newHookJob("hook", "PostApply", "ConfigMap/grafana")
+	if err := setJobHookEnv(job, jobHookEnv(hookPostApply, target)); err != nil {
+		t.Fatalf("setJobHookEnv failed: %v", err)
+	}
+
+	containers, _, _ := unstructured.NestedSlice(job.Object, "spec", "template", "spec", "containers")
+	env := map[string]interface{}{}
+	count := 0
+	for _, e := range containers[0].(map[string]interface{})["env"].([]interface{}) {
+		e := e.(map[string]interface{})
+		env[e["name"].(string)] = e["value"]
+		if e["name"] == "SRO_TARGET_NAME" {
+			count++
+		}
+	}
+
+	if count != 1 || env["SRO_TARGET_NAME"] != "grafana" {
+		t.Errorf("SRO_TARGET_NAME not replaced: %v", env)
+	}
+	if env["SRO_HOOK_PHASE"] != "PostApply" || env["SRO_TARGET_NAMESPACE"] != "nvidia-gpu" {
+		t.Errorf("hook env missing: %v", env)
+	}
+	if _, found := env["SRO_KERNEL_VERSION"]; !found {
+		t.Errorf("runtime information missing in env: %v", env)
+	}
+}
+
+func TestJobHookName(t *testing.T) {
+
+	partition := partitionID("amd64", "4.18.0-240.el8.x86_64")
+
+	if got := jobHookName("grafana-datasource", ""); got != "grafana-datasource" {
+		t.Errorf("jobHookName without partition = %s", got)
+	}
+	if got := jobHookName("grafana-datasource", partition); got != "grafana-datasource-"+partition {
+		t.Errorf("jobHookName = %s", got)
+	}
+	// Names rendered with {{.Partition}} are already unique
+	if got := jobHookName("hook-"+partition+"-x", partition); got != "hook-"+partition+"-x" {
+		t.Errorf("jobHookName of a partition name = %s", got)
+	}
+
+	long := "a-very-long-hook-name-that-almost-fills-the-label-limit"
+	a := jobHookName(long, partition)
+	b := jobHookName(long, partitionID("arm64", "4.18.0-240.el8.aarch64"))
+	if len(a) > 63 || len(b) > 63 || a == b {
+		t.Errorf("jobHookName of long names = %s, %s", a, b)
+	}
+}
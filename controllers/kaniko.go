@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	errs "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var (
+	kanikoImage = "gcr.io/kaniko-project/executor:v1.3.0"
+	gitImage    = "docker.io/alpine/git:v2.26.2"
+)
+
+// The source is prepared in an init container, this way we can override the
+// first FROM of any Dockerfile like a BuildConfig dockerStrategy.from does.
+const kanikoSourceScript = `set -e
+if [ -n "$GIT_URI" ] && [ -n "$GIT_REF" ]; then
+  git clone --depth 1 --branch "$GIT_REF" "$GIT_URI" /workspace/source
+elif [ -n "$GIT_URI" ]; then
+  git clone --depth 1 "$GIT_URI" /workspace/source
+else
+  mkdir -p /workspace/source
+fi
+mkdir -p "/workspace/source/$CONTEXT_DIR"
+cd "/workspace/source/$CONTEXT_DIR"
+if [ -n "$DOCKERFILE" ]; then
+  printf '%s\n' "$DOCKERFILE" > "$DOCKERFILE_PATH"
+fi
+if [ -n "$FROM" ]; then
+  awk -v from="$FROM" '!done && /^FROM / { print "FROM " from; done = 1; next } { print }' "$DOCKERFILE_PATH" > /tmp/Dockerfile
+  mv /tmp/Dockerfile "$DOCKERFILE_PATH"
+fi
+`
+
+// kanikoJobFrom translates a BuildConfig into a Job that builds the same
+// source with kaniko and pushes it to the build registry. Used on clusters
+// without build.openshift.io.
+func kanikoJobFrom(bc *unstructured.Unstructured, r *SpecialResourceReconciler) (*unstructured.Unstructured, error) {
+
+	if r.BuildRegistry == "" {
+		return nil, errs.New("No build registry configured, cannot build " + bc.GetName() + " without OpenShift builds")
+	}
+
+	spec, _, err := unstructured.NestedMap(bc.Object, "spec")
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot extract spec of BuildConfig")
+	}
+
+	gitURI, _, _ := unstructured.NestedString(spec, "source", "git", "uri")
+	gitRef, _, _ := unstructured.NestedString(spec, "source", "git", "ref")
+	contextDir, _, _ := unstructured.NestedString(spec, "source", "contextDir")
+	dockerfile, _, _ := unstructured.NestedString(spec, "source", "dockerfile")
+
+	dockerfilePath, _, _ := unstructured.NestedString(spec, "strategy", "dockerStrategy", "dockerfilePath")
+	if dockerfilePath == "" {
+		dockerfilePath = "Dockerfile"
+	}
+
+	from, err := kanikoImageFrom(spec, bc.GetNamespace(), r, "strategy", "dockerStrategy", "from")
+	if err != nil {
+		return nil, err
+	}
+
+	destination, err := kanikoImageFrom(spec, bc.GetNamespace(), r, "output", "to")
+	if err != nil {
+		return nil, err
+	}
+	if destination == "" {
+		return nil, errs.New("BuildConfig has no output: " + bc.GetName())
+	}
+
+	args := []interface{}{
+		"--context=dir:///workspace/source/" + contextDir,
+		"--dockerfile=" + dockerfilePath,
+		"--destination=" + destination,
+	}
+
+	buildArgs, _, _ := unstructured.NestedSlice(spec, "strategy", "dockerStrategy", "buildArgs")
+	for _, buildArg := range buildArgs {
+		switch buildArg := buildArg.(type) {
+		case map[string]interface{}:
+			name, _, _ := unstructured.NestedString(buildArg, "name")
+			value, _, _ := unstructured.NestedString(buildArg, "value")
+			args = append(args, "--build-arg="+name+"="+value)
+		default:
+			log.Info("buildArg", "DEFAULT NOT THE CORRECT TYPE", buildArg)
+		}
+	}
+
+	workspace := map[string]interface{}{"name": "workspace", "mountPath": "/workspace"}
+	volumes := []interface{}{
+		map[string]interface{}{"name": "workspace", "emptyDir": map[string]interface{}{}},
+	}
+	kanikoMounts := []interface{}{workspace}
+
+	// kaniko reads /kaniko/.docker/config.json, a legacy .dockercfg has no
+	// auths wrapper and is wrapped by an init container
+	var dockerConfig map[string]interface{}
+	if r.BuildPushSecret != "" {
+		_, key, err := getDockerConfigSecret(bc.GetNamespace(), r.BuildPushSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		path := "config.json"
+		if key == ".dockercfg" {
+			path = key
+		}
+		volumes = append(volumes, map[string]interface{}{
+			"name": "push-secret",
+			"secret": map[string]interface{}{
+				"secretName": r.BuildPushSecret,
+				"items": []interface{}{
+					map[string]interface{}{"key": key, "path": path},
+				},
+			},
+		})
+
+		if key == ".dockercfg" {
+			volumes = append(volumes, map[string]interface{}{"name": "docker-config", "emptyDir": map[string]interface{}{}})
+			dockerConfig = map[string]interface{}{
+				"name":    "docker-config",
+				"image":   mirrorImage(gitImage),
+				"command": []interface{}{"/bin/sh", "-c", `printf '{"auths": %s}' "$(cat /push-secret/.dockercfg)" > /kaniko/.docker/config.json`},
+				"volumeMounts": []interface{}{
+					map[string]interface{}{"name": "push-secret", "mountPath": "/push-secret"},
+					map[string]interface{}{"name": "docker-config", "mountPath": "/kaniko/.docker"},
+				},
+			}
+			kanikoMounts = append(kanikoMounts, map[string]interface{}{"name": "docker-config", "mountPath": "/kaniko/.docker"})
+		} else {
+			kanikoMounts = append(kanikoMounts, map[string]interface{}{"name": "push-secret", "mountPath": "/kaniko/.docker"})
+		}
+	}
+
+	env := func(name string, value string) interface{} {
+		return map[string]interface{}{"name": name, "value": value}
+	}
+
+	source := map[string]interface{}{
+		"name":    "source",
+		"image":   mirrorImage(gitImage),
+		"command": []interface{}{"/bin/sh", "-c", kanikoSourceScript},
+		"env": []interface{}{
+			env("GIT_URI", gitURI),
+			env("GIT_REF", gitRef),
+			env("CONTEXT_DIR", contextDir),
+			env("DOCKERFILE", dockerfile),
+			env("DOCKERFILE_PATH", dockerfilePath),
+			env("FROM", from),
+		},
+		"volumeMounts": []interface{}{workspace},
+	}
+
+	kaniko := map[string]interface{}{
+		"name":         "kaniko",
+		"image":        mirrorImage(kanikoImage),
+		"args":         args,
+		"volumeMounts": kanikoMounts,
+	}
+
+	initContainers := []interface{}{source}
+	if dockerConfig != nil {
+		initContainers = append(initContainers, dockerConfig)
+	}
+	pullSecrets := []interface{}{}
+
+	// Source images are copied into the context dir after the source
+	images, _, err := unstructured.NestedSlice(spec, "source", "images")
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot extract source images")
+	}
+	for i, image := range images {
+		image, ok := image.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, err := kanikoImageFrom(image, bc.GetNamespace(), r, "from")
+		if err != nil {
+			return nil, err
+		}
+
+		args := []interface{}{}
+		paths, _, _ := unstructured.NestedSlice(image, "paths")
+		for _, p := range paths {
+			p, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			sourcePath, _, _ := unstructured.NestedString(p, "sourcePath")
+			destinationDir, _, _ := unstructured.NestedString(p, "destinationDir")
+			args = append(args, sourcePath, destinationDir)
+		}
+
+		if i == 0 {
+			tools := artifactsToolsContainer(mirrorImage(r.ArtifactsToolsImage))
+			initContainers = append(initContainers, tools)
+			volumes = append(volumes, artifactsToolsVolumeSpec())
+		}
+		initContainers = append(initContainers, artifactsCopyContainer("source-image-"+strconv.Itoa(i), mirrorImage(name), args, "/workspace/source/"+contextDir, workspace))
+
+		if pullSecret, _, _ := unstructured.NestedString(image, "pullSecret", "name"); pullSecret != "" && !hasPullSecret(pullSecrets, pullSecret) {
+			pullSecrets = append(pullSecrets, map[string]interface{}{"name": pullSecret})
+		}
+	}
+
+	podSpec := map[string]interface{}{
+		"restartPolicy":  "Never",
+		"initContainers": initContainers,
+		"containers":     []interface{}{kaniko},
+		"volumes":        volumes,
+	}
+
+	if len(pullSecrets) > 0 {
+		podSpec["imagePullSecrets"] = pullSecrets
+	}
+
+	if nodeSelector, found, _ := unstructured.NestedMap(spec, "nodeSelector"); found {
+		podSpec["nodeSelector"] = nodeSelector
+	}
+
+	job := &unstructured.Unstructured{}
+	job.SetAPIVersion("batch/v1")
+	job.SetKind("Job")
+	job.SetName(bc.GetName())
+	job.SetNamespace(bc.GetNamespace())
+	job.SetLabels(bc.GetLabels())
+
+	annotations := bc.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations["specialresource.openshift.io/build-output"] = destination
+	job.SetAnnotations(annotations)
+
+	if err := unstructured.SetNestedField(job.Object, int64(2), "spec", "backoffLimit"); err != nil {
+		return nil, errs.Wrap(err, "Cannot set backoffLimit")
+	}
+	if err := unstructured.SetNestedMap(job.Object, podSpec, "spec", "template", "spec"); err != nil {
+		return nil, errs.Wrap(err, "Cannot set Job pod spec")
+	}
+
+	return job, nil
+}
+
+// kanikoImageFrom resolves an ObjectReference of a BuildConfig to an image
+// in the build registry, ImageStreamTags are mapped to <ns>/<stream>:<tag>
+func kanikoImageFrom(spec map[string]interface{}, namespace string, r *SpecialResourceReconciler, fields ...string) (string, error) {
+
+	ref, found, err := unstructured.NestedMap(spec, fields...)
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot extract "+strings.Join(fields, "."))
+	}
+	if !found {
+		return "", nil
+	}
+
+	kind, _, _ := unstructured.NestedString(ref, "kind")
+	name, _, _ := unstructured.NestedString(ref, "name")
+
+	switch kind {
+	case "DockerImage":
+		return name, nil
+	case "ImageStreamTag":
+		if ns, _, _ := unstructured.NestedString(ref, "namespace"); ns != "" {
+			namespace = ns
+		}
+		return r.BuildRegistry + "/" + namespace + "/" + name, nil
+	}
+
+	return "", errs.New("Cannot build from " + kind + " without OpenShift builds")
+}
+
+func isKanikoJob(obj *unstructured.Unstructured) bool {
+	_, found := obj.GetAnnotations()["specialresource.openshift.io/build-output"]
+	return obj.GetKind() == "Job" && found
+}
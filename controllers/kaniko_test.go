@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPushSecret(key string) *corev1.Secret {
+	secret := &corev1.Secret{Data: map[string][]byte{key: []byte(`{}`)}}
+	secret.Name = "push-secret"
+	secret.Namespace = "simple-kmod"
+	return secret
+}
+
+func TestKanikoJobFrom(t *testing.T) {
+
+	saved := kubeclient
+	defer func() { kubeclient = saved }()
+	kubeclient = fake.NewSimpleClientset(newPushSecret(".dockerconfigjson"))
+
+	r := &SpecialResourceReconciler{BuildRegistry: "registry.local:5000", BuildPushSecret: "push-secret"}
+
+	bc := &unstructured.Unstructured{}
+	bc.SetKind("BuildConfig")
+	bc.SetName("simple-kmod-driver-build-x86-64")
+	bc.SetNamespace("simple-kmod")
+	bc.SetLabels(map[string]string{"app": "simple-kmod-driver-build"})
+	bc.Object["spec"] = map[string]interface{}{
+		"nodeSelector": map[string]interface{}{"kubernetes.io/arch": "amd64"},
+		"source": map[string]interface{}{
+			"git":        map[string]interface{}{"uri": "https://github.com/vendor/kmod.git"},
+			"contextDir": "kmod",
+		},
+		"strategy": map[string]interface{}{
+			"dockerStrategy": map[string]interface{}{
+				"dockerfilePath": "Dockerfile.SRO",
+				"from":           map[string]interface{}{"kind": "ImageStreamTag", "name": "driver-container-base:v1", "namespace": "driver-container-base"},
+				"buildArgs": []interface{}{
+					map[string]interface{}{"name": "KVER", "value": "4.18.0"},
+				},
+			},
+		},
+		"output": map[string]interface{}{
+			"to": map[string]interface{}{"kind": "ImageStreamTag", "name": "simple-kmod-driver-container:v4.18.0"},
+		},
+	}
+
+	job, err := kanikoJobFrom(bc, r)
+	if err != nil {
+		t.Fatalf("kanikoJobFrom failed: %v", err)
+	}
+
+	if !isKanikoJob(job) {
+		t.Errorf("Job is not recognized as kaniko Job")
+	}
+	if job.GetName() != bc.GetName() || job.GetNamespace() != bc.GetNamespace() || !reflect.DeepEqual(job.GetLabels(), bc.GetLabels()) {
+		t.Errorf("Job metadata = %s/%s %v", job.GetNamespace(), job.GetName(), job.GetLabels())
+	}
+
+	spec, _, _ := unstructured.NestedMap(job.Object, "spec", "template", "spec")
+
+	initContainers := spec["initContainers"].([]interface{})
+	if len(initContainers) != 1 {
+		t.Fatalf("got %d init containers, want the source", len(initContainers))
+	}
+	env := map[string]string{}
+	for _, e := range initContainers[0].(map[string]interface{})["env"].([]interface{}) {
+		e := e.(map[string]interface{})
+		env[e["name"].(string)] = e["value"].(string)
+	}
+	wantEnv := map[string]string{
+		"GIT_URI":         "https://github.com/vendor/kmod.git",
+		"GIT_REF":         "",
+		"CONTEXT_DIR":     "kmod",
+		"DOCKERFILE":      "",
+		"DOCKERFILE_PATH": "Dockerfile.SRO",
+		"FROM":            "registry.local:5000/driver-container-base/driver-container-base:v1",
+	}
+	if !reflect.DeepEqual(env, wantEnv) {
+		t.Errorf("source env = %v, want %v", env, wantEnv)
+	}
+
+	kaniko := spec["containers"].([]interface{})[0].(map[string]interface{})
+	wantArgs := []interface{}{
+		"--context=dir:///workspace/source/kmod",
+		"--dockerfile=Dockerfile.SRO",
+		"--destination=registry.local:5000/simple-kmod/simple-kmod-driver-container:v4.18.0",
+		"--build-arg=KVER=4.18.0",
+	}
+	if !reflect.DeepEqual(kaniko["args"], wantArgs) {
+		t.Errorf("kaniko args = %v, want %v", kaniko["args"], wantArgs)
+	}
+
+	pushSecret := false
+	for _, m := range kaniko["volumeMounts"].([]interface{}) {
+		if m.(map[string]interface{})["mountPath"] == "/kaniko/.docker" {
+			pushSecret = true
+		}
+	}
+	if !pushSecret {
+		t.Errorf("push secret is not mounted at /kaniko/.docker")
+	}
+	if secret, _, _ := unstructured.NestedString(spec["volumes"].([]interface{})[1].(map[string]interface{}), "secret", "secretName"); secret != "push-secret" {
+		t.Errorf("push secret volume = %s, want push-secret", secret)
+	}
+
+	if !reflect.DeepEqual(spec["nodeSelector"], map[string]interface{}{"kubernetes.io/arch": "amd64"}) {
+		t.Errorf("nodeSelector = %v", spec["nodeSelector"])
+	}
+
+	if output := job.GetAnnotations()["specialresource.openshift.io/build-output"]; output != "registry.local:5000/simple-kmod/simple-kmod-driver-container:v4.18.0" {
+		t.Errorf("build-output = %s", output)
+	}
+
+	// A legacy .dockercfg is wrapped into a config.json by an init container
+	kubeclient = fake.NewSimpleClientset(newPushSecret(".dockercfg"))
+	if job, err = kanikoJobFrom(bc, r); err != nil {
+		t.Fatalf("kanikoJobFrom with .dockercfg failed: %v", err)
+	}
+	spec, _, _ = unstructured.NestedMap(job.Object, "spec", "template", "spec")
+
+	initContainers = spec["initContainers"].([]interface{})
+	if len(initContainers) != 2 || initContainers[1].(map[string]interface{})["name"] != "docker-config" {
+		t.Fatalf("init containers = %v, want the source and docker-config", initContainers)
+	}
+	items, _, _ := unstructured.NestedSlice(spec["volumes"].([]interface{})[1].(map[string]interface{}), "secret", "items")
+	if !reflect.DeepEqual(items, []interface{}{map[string]interface{}{"key": ".dockercfg", "path": ".dockercfg"}}) {
+		t.Errorf("push secret items = %v", items)
+	}
+	kaniko = spec["containers"].([]interface{})[0].(map[string]interface{})
+	mounts := map[string]string{}
+	for _, m := range kaniko["volumeMounts"].([]interface{}) {
+		m := m.(map[string]interface{})
+		mounts[m["mountPath"].(string)] = m["name"].(string)
+	}
+	if mounts["/kaniko/.docker"] != "docker-config" {
+		t.Errorf("kaniko mounts %v, want docker-config at /kaniko/.docker", mounts)
+	}
+
+	// Without a registry there is nowhere to push to
+	if _, err := kanikoJobFrom(bc, &SpecialResourceReconciler{}); err == nil {
+		t.Errorf("kanikoJobFrom without build registry succeeded")
+	}
+}
+
+func TestJobFailed(t *testing.T) {
+
+	tests := []struct {
+		status       map[string]interface{}
+		backoffLimit int64
+		want         bool
+	}{
+		{map[string]interface{}{"active": int64(1)}, 2, false},
+		{map[string]interface{}{"failed": int64(2)}, 2, false},
+		{map[string]interface{}{"failed": int64(3)}, 2, true},
+		{map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Failed", "status": "True", "reason": "BackoffLimitExceeded"},
+		}}, 2, true},
+		{map[string]interface{}{"succeeded": int64(1)}, 2, false},
+	}
+
+	for _, test := range tests {
+		job := &unstructured.Unstructured{}
+		job.SetKind("Job")
+		job.Object["spec"] = map[string]interface{}{"backoffLimit": test.backoffLimit}
+		job.Object["status"] = test.status
+		if got := jobFailed(job); got != test.want {
+			t.Errorf("jobFailed(%v) = %v, want %v", test.status, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// mirrorsConfigMap in the operator namespace holds the operator wide
+// mirrors as a list of source and mirrors under the key mirrors
+const mirrorsConfigMap = "special-resource-mirrors"
+
+// getMirrors collects the mirrors of the specialresource and the operator
+// in this order, the first matching source wins. The mirrors of all
+// ImageContentSourcePolicies are returned separately, CRI-O already pulls
+// the images of Pods from them.
+func getMirrors(r *SpecialResourceReconciler) ([]srov1beta1.SpecialResourceMirror, []srov1beta1.SpecialResourceMirror, error) {
+
+	mirrors := append([]srov1beta1.SpecialResourceMirror{}, r.specialresource.Spec.Mirrors...)
+
+	if namespace := os.Getenv("OPERATOR_NAMESPACE"); namespace != "" {
+		cm, err := kubeclient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), mirrorsConfigMap, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, nil, errs.Wrap(err, "Cannot get ConfigMap "+mirrorsConfigMap)
+		}
+		if err == nil {
+			operator := []srov1beta1.SpecialResourceMirror{}
+			if err := yaml.Unmarshal([]byte(cm.Data["mirrors"]), &operator); err != nil {
+				return nil, nil, errs.Wrap(err, "Cannot unmarshal mirrors of ConfigMap "+mirrorsConfigMap)
+			}
+			mirrors = append(mirrors, operator...)
+		}
+	}
+
+	cluster := []srov1beta1.SpecialResourceMirror{}
+
+	available, err := apiGroupVersionAvailable("operator.openshift.io/v1alpha1")
+	if err != nil || !available {
+		return mirrors, cluster, err
+	}
+
+	icsps := &unstructured.UnstructuredList{}
+	icsps.SetAPIVersion("operator.openshift.io/v1alpha1")
+	icsps.SetKind("ImageContentSourcePolicyList")
+
+	if err := r.List(context.TODO(), icsps); err != nil {
+		return nil, nil, errs.Wrap(err, "Client cannot get ImageContentSourcePolicyList")
+	}
+
+	for _, icsp := range icsps.Items {
+		rdms, _, err := unstructured.NestedSlice(icsp.Object, "spec", "repositoryDigestMirrors")
+		if err != nil {
+			return nil, nil, errs.Wrap(err, "Cannot extract repositoryDigestMirrors of "+icsp.GetName())
+		}
+		for _, rdm := range rdms {
+			rdm, ok := rdm.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			source, _, _ := unstructured.NestedString(rdm, "source")
+			sourceMirrors, _, _ := unstructured.NestedStringSlice(rdm, "mirrors")
+			cluster = append(cluster, srov1beta1.SpecialResourceMirror{Source: source, Mirrors: sourceMirrors})
+		}
+	}
+
+	return mirrors, cluster, nil
+}
+
+// imageMatches is true if image is in the repository source, quay.io/vendor
+// matches quay.io/vendor/driver:tag but not quay.io/vendor-other
+func imageMatches(image string, source string) bool {
+	if !strings.HasPrefix(image, source) {
+		return false
+	}
+	rest := image[len(source):]
+	return rest == "" || strings.ContainsAny(rest[:1], "/:@")
+}
+
+// sourceMirrors returns image in the mirrors of the first matching source
+// of mirrors, all matching sources if all is set
+func sourceMirrors(image string, mirrors []srov1beta1.SpecialResourceMirror, all bool) []string {
+
+	images := []string{}
+	for _, m := range mirrors {
+		if m.Source == "" || !imageMatches(image, m.Source) {
+			continue
+		}
+		for _, mirror := range m.Mirrors {
+			images = append(images, mirror+image[len(m.Source):])
+		}
+		if !all {
+			break
+		}
+	}
+	return images
+}
+
+// mirrorImages returns the image in all mirrors of the first matching
+// source followed by the image itself
+func mirrorImages(image string) []string {
+	return append(sourceMirrors(image, runInfo.Mirrors, false), image)
+}
+
+// mirrorImage rewrites image to its first mirror, mirrors of
+// ImageContentSourcePolicies are left to CRI-O which falls back to the
+// next mirror and the source
+func mirrorImage(image string) string {
+	return mirrorImages(image)[0]
+}
+
+// buildMirrorImages returns the image in the mirrors of the specialresource
+// and operator, in the mirrors of all ImageContentSourcePolicies and the
+// image itself in this order
+func buildMirrorImages(image string) []string {
+
+	images := sourceMirrors(image, runInfo.Mirrors, false)
+	for _, mirror := range sourceMirrors(image, runInfo.ClusterMirrors, true) {
+		if !containsString(images, mirror) {
+			images = append(images, mirror)
+		}
+	}
+	return append(images, image)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func mirrorGitURI(uri string) string {
+
+	for _, m := range runInfo.Mirrors {
+		// Repositories are matched like images, github.com/foo is not
+		// github.com/foobar
+		if m.Source != "" && len(m.Mirrors) > 0 && imageMatches(uri, m.Source) {
+			return m.Mirrors[0] + uri[len(m.Source):]
+		}
+	}
+	return uri
+}
+
+// rewriteMirrors points all container images, build images and git URIs of
+// obj to their mirrors, base images of builds to what they resolved to, see
+// resolveBaseImages
+func rewriteMirrors(obj *unstructured.Unstructured, baseImages map[string]string) error {
+
+	if obj.GetKind() == "BuildConfig" {
+		return rewriteBuildConfigMirrors(obj, baseImages)
+	}
+
+	if len(runInfo.Mirrors) == 0 {
+		return nil
+	}
+
+	path, found := podSpecPaths[obj.GetKind()]
+	if !found {
+		return nil
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+
+		fields := append(append([]string{}, path...), field)
+		containers, found, err := unstructured.NestedSlice(obj.Object, fields...)
+		if err != nil {
+			return errs.Wrap(err, "Cannot extract "+field)
+		}
+		if !found {
+			continue
+		}
+
+		for i, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if image, ok := container["image"].(string); ok {
+				container["image"] = mirrorImage(image)
+			}
+			containers[i] = container
+		}
+
+		if err := unstructured.SetNestedSlice(obj.Object, containers, fields...); err != nil {
+			return errs.Wrap(err, "Cannot set "+field)
+		}
+	}
+
+	return nil
+}
+
+func rewriteBuildConfigMirrors(obj *unstructured.Unstructured, baseImages map[string]string) error {
+
+	// Base images that did not resolve are built from as they are
+	baseImage := func(image string) string {
+		if resolved, found := baseImages[image]; found {
+			return resolved
+		}
+		return image
+	}
+
+	if uri, found, _ := unstructured.NestedString(obj.Object, "spec", "source", "git", "uri"); found {
+		if err := unstructured.SetNestedField(obj.Object, mirrorGitURI(uri), "spec", "source", "git", "uri"); err != nil {
+			return errs.Wrap(err, "Cannot set git uri")
+		}
+	}
+
+	if dockerfile, found, _ := unstructured.NestedString(obj.Object, "spec", "source", "dockerfile"); found {
+		lines := strings.Split(dockerfile, "\n")
+		for i, line := range lines {
+			if image, ok := dockerfileFrom(line); ok {
+				lines[i] = strings.Replace(line, image, baseImage(image), 1)
+			}
+		}
+		if err := unstructured.SetNestedField(obj.Object, strings.Join(lines, "\n"), "spec", "source", "dockerfile"); err != nil {
+			return errs.Wrap(err, "Cannot set dockerfile")
+		}
+	}
+
+	for _, strategy := range []string{"dockerStrategy", "sourceStrategy", "customStrategy"} {
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "strategy", strategy, "from", "kind")
+		name, _, _ := unstructured.NestedString(obj.Object, "spec", "strategy", strategy, "from", "name")
+		if kind != "DockerImage" {
+			continue
+		}
+		if err := unstructured.SetNestedField(obj.Object, baseImage(name), "spec", "strategy", strategy, "from", "name"); err != nil {
+			return errs.Wrap(err, "Cannot set from of "+strategy)
+		}
+	}
+
+	images, _, err := unstructured.NestedSlice(obj.Object, "spec", "source", "images")
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract source images")
+	}
+	for i, image := range images {
+		image, ok := image.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		kind, _, _ := unstructured.NestedString(image, "from", "kind")
+		name, _, _ := unstructured.NestedString(image, "from", "name")
+		if kind != "DockerImage" {
+			continue
+		}
+		if err := unstructured.SetNestedField(image, mirrorImage(name), "from", "name"); err != nil {
+			return errs.Wrap(err, "Cannot set source image")
+		}
+		images[i] = image
+	}
+	if len(images) > 0 {
+		if err := unstructured.SetNestedSlice(obj.Object, images, "spec", "source", "images"); err != nil {
+			return errs.Wrap(err, "Cannot set source images")
+		}
+	}
+
+	return nil
+}
+
+// dockerfileFrom returns the image of a FROM line, stages, scratch and
+// images with build args cannot be resolved and are skipped
+func dockerfileFrom(line string) (string, bool) {
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.ToUpper(fields[0]) != "FROM" {
+		return "", false
+	}
+
+	image := fields[1]
+	if strings.HasPrefix(image, "--") && len(fields) > 2 {
+		image = fields[2]
+	}
+
+	if image == "scratch" || strings.Contains(image, "$") || !strings.ContainsAny(image, "/:.") {
+		return "", false
+	}
+
+	return image, true
+}
+
+// buildBaseImages returns the external images a BuildConfig builds from,
+// ImageStreamTags are resolved in-cluster and not returned
+func buildBaseImages(obj *unstructured.Unstructured) []string {
+
+	images := []string{}
+
+	kind, _, _ := unstructured.NestedString(obj.Object, "spec", "strategy", "dockerStrategy", "from", "kind")
+	name, _, _ := unstructured.NestedString(obj.Object, "spec", "strategy", "dockerStrategy", "from", "name")
+	if kind == "DockerImage" {
+		images = append(images, name)
+	}
+
+	// The first FROM is replaced by dockerStrategy.from
+	overridden := kind != ""
+
+	dockerfile, _, _ := unstructured.NestedString(obj.Object, "spec", "source", "dockerfile")
+	for _, line := range strings.Split(dockerfile, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.ToUpper(fields[0]) != "FROM" {
+			continue
+		}
+		if overridden {
+			overridden = false
+			continue
+		}
+		if image, ok := dockerfileFrom(line); ok {
+			images = append(images, image)
+		}
+	}
+
+	return images
+}
+
+// resolveBaseImages looks up each base image in its mirrors and itself in
+// the order of buildMirrorImages, an image resolves to the first that has
+// it. Images that cannot be found are returned, they only fail a build that
+// is needed. Without mirrors we are connected and the build resolves them.
+func resolveBaseImages(images []string, r *SpecialResourceReconciler) (map[string]string, []string, error) {
+
+	if len(runInfo.Mirrors) == 0 && len(runInfo.ClusterMirrors) == 0 {
+		return nil, nil, nil
+	}
+
+	auths, err := getRegistryAuths(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := newRegistryClient(auths)
+
+	resolved, unresolved := resolveImages(images, client.Exists)
+	return resolved, unresolved, nil
+}
+
+func resolveImages(images []string, exists func(string) (bool, error)) (map[string]string, []string) {
+
+	resolved := map[string]string{}
+	unresolved := []string{}
+
+	for _, image := range images {
+		for _, candidate := range buildMirrorImages(image) {
+			found, err := exists(candidate)
+			if err != nil {
+				log.Info("Cannot look up base image", "Image", candidate, "error", err.Error())
+				continue
+			}
+			if found {
+				log.Info("Base image resolved", "Image", image, "Mirror", candidate)
+				resolved[image] = candidate
+				break
+			}
+		}
+		if _, found := resolved[image]; !found {
+			unresolved = append(unresolved, image)
+		}
+	}
+
+	return resolved, unresolved
+}
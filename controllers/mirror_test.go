@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMirrors(t *testing.T) {
+
+	runInfo.Mirrors = []srov1beta1.SpecialResourceMirror{
+		{Source: "quay.io/vendor", Mirrors: []string{"mirror.local:5000/vendor", "backup.local/vendor"}},
+		{Source: "https://github.com/vendor", Mirrors: []string{"https://git.local/vendor"}},
+	}
+	defer func() { runInfo.Mirrors = nil }()
+
+	tests := []struct {
+		image string
+		want  []string
+	}{
+		{"quay.io/vendor/driver:1.0", []string{"mirror.local:5000/vendor/driver:1.0", "backup.local/vendor/driver:1.0", "quay.io/vendor/driver:1.0"}},
+		{"quay.io/vendor-other/driver", []string{"quay.io/vendor-other/driver"}},
+		{"registry.access.redhat.com/ubi8", []string{"registry.access.redhat.com/ubi8"}},
+	}
+
+	for _, test := range tests {
+		if got := mirrorImages(test.image); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("mirrorImages(%s) = %v, want %v", test.image, got, test.want)
+		}
+	}
+
+	if got := mirrorGitURI("https://github.com/vendor/kmod.git"); got != "https://git.local/vendor/kmod.git" {
+		t.Errorf("mirrorGitURI = %s", got)
+	}
+	if got := mirrorGitURI("https://github.com/vendor-other/kmod.git"); got != "https://github.com/vendor-other/kmod.git" {
+		t.Errorf("mirrorGitURI of another repository = %s", got)
+	}
+
+	bc := &unstructured.Unstructured{}
+	bc.SetKind("BuildConfig")
+	bc.Object["spec"] = map[string]interface{}{
+		"source": map[string]interface{}{
+			"git":        map[string]interface{}{"uri": "https://github.com/vendor/kmod.git"},
+			"dockerfile": "FROM quay.io/vendor/base:8 AS builder\nRUN make\nFROM builder\nFROM quay.io/vendor/runtime:8",
+		},
+		"strategy": map[string]interface{}{
+			"dockerStrategy": map[string]interface{}{
+				"from": map[string]interface{}{"kind": "DockerImage", "name": "quay.io/vendor/base:8.2"},
+			},
+		},
+	}
+
+	// The first FROM is replaced by dockerStrategy.from, stages are skipped
+	want := []string{"quay.io/vendor/base:8.2", "quay.io/vendor/runtime:8"}
+	if got := buildBaseImages(bc); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildBaseImages = %v, want %v", got, want)
+	}
+
+	runInfo.ClusterMirrors = []srov1beta1.SpecialResourceMirror{
+		{Source: "quay.io/vendor", Mirrors: []string{"icsp.local/vendor"}},
+	}
+	defer func() { runInfo.ClusterMirrors = nil }()
+
+	// Pods are left to CRI-O for the mirrors of ImageContentSourcePolicies
+	if got := mirrorImage("quay.io/vendor/driver:1.0"); got != "mirror.local:5000/vendor/driver:1.0" {
+		t.Errorf("mirrorImage = %s", got)
+	}
+
+	available := map[string]bool{
+		"backup.local/vendor/base:8.2": true,
+		"icsp.local/vendor/base:8":     true,
+		"quay.io/vendor/runtime:8":     true,
+	}
+	exists := func(image string) (bool, error) { return available[image], nil }
+
+	resolved, unresolved := resolveImages(append(buildBaseImages(bc), "quay.io/vendor/missing:1"), exists)
+	wantResolved := map[string]string{
+		"quay.io/vendor/base:8.2":  "backup.local/vendor/base:8.2",
+		"quay.io/vendor/runtime:8": "quay.io/vendor/runtime:8",
+	}
+	if !reflect.DeepEqual(resolved, wantResolved) || !reflect.DeepEqual(unresolved, []string{"quay.io/vendor/missing:1"}) {
+		t.Errorf("resolveImages = %v %v", resolved, unresolved)
+	}
+
+	// Builds use the mirrors of ImageContentSourcePolicies as well
+	if resolved, _ = resolveImages([]string{"quay.io/vendor/base:8"}, exists); resolved["quay.io/vendor/base:8"] != "icsp.local/vendor/base:8" {
+		t.Errorf("resolveImages did not use the ImageContentSourcePolicy mirror: %v", resolved)
+	}
+	resolved["quay.io/vendor/base:8.2"] = "backup.local/vendor/base:8.2"
+
+	if err := rewriteMirrors(bc, resolved); err != nil {
+		t.Fatalf("rewriteMirrors failed: %v", err)
+	}
+
+	uri, _, _ := unstructured.NestedString(bc.Object, "spec", "source", "git", "uri")
+	from, _, _ := unstructured.NestedString(bc.Object, "spec", "strategy", "dockerStrategy", "from", "name")
+	dockerfile, _, _ := unstructured.NestedString(bc.Object, "spec", "source", "dockerfile")
+
+	if uri != "https://git.local/vendor/kmod.git" || from != "backup.local/vendor/base:8.2" {
+		t.Errorf("BuildConfig not rewritten: uri %s from %s", uri, from)
+	}
+	if dockerfile != "FROM icsp.local/vendor/base:8 AS builder\nRUN make\nFROM builder\nFROM quay.io/vendor/runtime:8" {
+		t.Errorf("dockerfile not rewritten:\n%s", dockerfile)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"os"
 
 	configv1 "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	errs "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -31,6 +33,19 @@ func AddConfigClient(cfg *rest.Config) error {
 	return nil
 }
 
+// apiGroupVersionAvailable asks the discovery API if groupVersion is served
+func apiGroupVersionAvailable(groupVersion string) (bool, error) {
+
+	_, err := kubeclient.Discovery().ServerResourcesForGroupVersion(groupVersion)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(err, "Cannot discover "+groupVersion)
+	}
+	return true, nil
+}
+
 func warnOnErrorOrNotFound(found bool, err error) {
 	if !found || err != nil {
 		log.Error(err, "Not found")
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const monitoringNamespace = "openshift-monitoring"
+
+// monitoringInformation is exposed to templates as .Monitoring, recipes
+// authenticate with a ServiceAccount token bound to cluster-monitoring-view
+// instead of copying credentials of cluster monitoring, e.g.
+//
+//	url: {{.Monitoring.PrometheusURL}}
+type monitoringInformation struct {
+	// PrometheusURL is the in-cluster URL of the Thanos querier or of
+	// Prometheus if there is no querier
+	PrometheusURL string
+}
+
+// monitoringServices are queried in order, the querier sees all Prometheus
+// replicas and user workload monitoring
+var monitoringServices = []struct {
+	name string
+	port string
+}{
+	{name: "thanos-querier", port: "web"},
+	{name: "prometheus-k8s", port: "web"},
+}
+
+// getMonitoring discovers cluster monitoring, an error means there is no
+// cluster monitoring to query
+func getMonitoring() (monitoringInformation, error) {
+
+	for _, s := range monitoringServices {
+
+		svc, err := kubeclient.CoreV1().Services(monitoringNamespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return monitoringInformation{}, errs.Wrap(err, "Cannot get Service "+monitoringNamespace+"/"+s.name)
+		}
+
+		port, found := servicePort(svc, s.port)
+		if !found {
+			log.Info("Monitoring Service has no port", "Name", s.name, "Port", s.port)
+			continue
+		}
+
+		return monitoringInformation{
+			PrometheusURL: fmt.Sprintf("https://%s.%s.svc:%d", svc.Name, svc.Namespace, port),
+		}, nil
+	}
+
+	return monitoringInformation{}, errs.New("No Thanos querier or Prometheus found in " + monitoringNamespace)
+}
+
+// servicePort returns the port with name, or the only port of the Service
+func servicePort(svc *corev1.Service, name string) (int32, bool) {
+
+	for _, p := range svc.Spec.Ports {
+		if p.Name == name {
+			return p.Port, true
+		}
+	}
+	if len(svc.Spec.Ports) == 1 {
+		return svc.Spec.Ports[0].Port, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/openshift-psap/special-resource-operator/yamlutil"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestServicePort(t *testing.T) {
+
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+		{Name: "tenancy", Port: 9092},
+		{Name: "web", Port: 9091},
+	}}}
+
+	if port, found := servicePort(svc, "web"); !found || port != 9091 {
+		t.Errorf("servicePort(web) = %d, %v, want 9091", port, found)
+	}
+	if _, found := servicePort(svc, "metrics"); found {
+		t.Errorf("servicePort(metrics) should not be found with several ports")
+	}
+
+	svc.Spec.Ports = svc.Spec.Ports[1:]
+	if port, found := servicePort(svc, "metrics"); !found || port != 9091 {
+		t.Errorf("servicePort of a single port Service = %d, %v, want 9091", port, found)
+	}
+}
+
+// renderGrafanaRecipe returns the kinds of all objects the Grafana state
+// of the nvidia-gpu recipe renders to
+func renderGrafanaRecipe(t *testing.T, ri runtimeInformation) []string {
+
+	manifest, err := ioutil.ReadFile("../config/recipes/nvidia-gpu/manifests/7000-state-device-grafana.yaml")
+	if err != nil {
+		t.Fatalf("Cannot read recipe: %v", err)
+	}
+
+	kinds := []string{}
+	scanner := yamlutil.NewYAMLScanner(manifest)
+	for scanner.Scan() {
+		spec := scanner.Bytes()
+		if err := templateRuntimeInformation(&spec, ri); err != nil {
+			t.Fatalf("Cannot render recipe: %v", err)
+		}
+		if len(bytes.TrimSpace(spec)) == 0 {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(spec, &obj); err != nil {
+			t.Fatalf("Rendered recipe is not valid YAML: %v", err)
+		}
+		kinds = append(kinds, obj["kind"].(string))
+	}
+
+	return kinds
+}
+
+func TestGrafanaRecipeMonitoring(t *testing.T) {
+
+	ri := runInfo
+	ri.Available = map[string]bool{"Monitoring": false}
+	if kinds := renderGrafanaRecipe(t, ri); len(kinds) != 0 {
+		t.Errorf("Grafana rendered without cluster monitoring: %v", kinds)
+	}
+
+	ri.Available = map[string]bool{"Monitoring": true}
+	ri.Monitoring = monitoringInformation{PrometheusURL: "https://thanos-querier.openshift-monitoring.svc:9091"}
+	kinds := renderGrafanaRecipe(t, ri)
+	if len(kinds) == 0 || kinds[len(kinds)-1] != "Deployment" {
+		t.Errorf("Grafana not rendered with cluster monitoring: %v", kinds)
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const nfdLabelPrefix = "feature.node.kubernetes.io/"
+
+// nodeInformation is exposed to templates as .Node
+type nodeInformation struct {
+	Features nodeFeatures
+}
+
+// nodeFeatures are the feature.node.kubernetes.io/* labels of the selected
+// nodes parsed by source, e.g.
+//
+//	{{if (index .Node.Features.PCI "10de").Present}}
+//	{{index .Node.Features.Kernel.Config "NO_HZ"}}
+type nodeFeatures struct {
+	// PCI and USB devices are indexed by their full id e.g. 0300_10de and
+	// for PCI also by vendor e.g. 10de
+	PCI     map[string]deviceFeatures
+	USB     map[string]deviceFeatures
+	CPU     cpuFeatures
+	Kernel  kernelFeatures
+	System  map[string]string
+	Memory  map[string]string
+	Network map[string]string
+	Storage map[string]string
+	// Raw holds every label without the prefix, including sources that are
+	// not parsed e.g. custom or local features
+	Raw map[string]string
+}
+
+type deviceFeatures struct {
+	Present bool
+	SRIOV   bool
+}
+
+type cpuFeatures struct {
+	CPUID map[string]bool
+	// Attributes are all other cpu features e.g. hardware_multithreading
+	// or pstate.turbo
+	Attributes map[string]string
+}
+
+type kernelFeatures struct {
+	Config  map[string]string
+	Version map[string]string
+	// Attributes are all other kernel features e.g. selinux.enabled
+	Attributes map[string]string
+}
+
+func newNodeFeatures() nodeFeatures {
+	return nodeFeatures{
+		PCI:     map[string]deviceFeatures{},
+		USB:     map[string]deviceFeatures{},
+		CPU:     cpuFeatures{CPUID: map[string]bool{}, Attributes: map[string]string{}},
+		Kernel:  kernelFeatures{Config: map[string]string{}, Version: map[string]string{}, Attributes: map[string]string{}},
+		System:  map[string]string{},
+		Memory:  map[string]string{},
+		Network: map[string]string{},
+		Storage: map[string]string{},
+		Raw:     map[string]string{},
+	}
+}
+
+// getNodeFeatures parses the NFD labels of all selected nodes. Devices and
+// flags are the union of all nodes, for differing values the node first by
+// name wins.
+func getNodeFeatures(nodes []unstructured.Unstructured) nodeFeatures {
+
+	sorted := append([]unstructured.Unstructured{}, nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+
+	features := newNodeFeatures()
+	for _, node := range sorted {
+		for label, value := range node.GetLabels() {
+			if strings.HasPrefix(label, nfdLabelPrefix) {
+				features.add(strings.TrimPrefix(label, nfdLabelPrefix), value)
+			}
+		}
+	}
+
+	return features
+}
+
+// add parses one label without prefix e.g. pci-0300_10de.present=true
+func (f *nodeFeatures) add(feature string, value string) {
+
+	setOnce := func(m map[string]string, key string) {
+		if _, found := m[key]; !found {
+			m[key] = value
+		}
+	}
+
+	setOnce(f.Raw, feature)
+
+	s := strings.SplitN(feature, "-", 2)
+	if len(s) != 2 {
+		return
+	}
+	source, name := s[0], s[1]
+
+	switch source {
+	case "pci":
+		id, attribute := splitAttribute(name)
+		f.addDevice(f.PCI, id, attribute, value)
+		// pci-<class>_<vendor>, older NFD versions label pci-<vendor>
+		if i := strings.LastIndex(id, "_"); i >= 0 {
+			f.addDevice(f.PCI, id[i+1:], attribute, value)
+		}
+	case "usb":
+		id, attribute := splitAttribute(name)
+		f.addDevice(f.USB, id, attribute, value)
+	case "cpu":
+		if strings.HasPrefix(name, "cpuid.") {
+			if value == "true" {
+				f.CPU.CPUID[strings.TrimPrefix(name, "cpuid.")] = true
+			}
+			return
+		}
+		setOnce(f.CPU.Attributes, name)
+	case "kernel":
+		switch {
+		case strings.HasPrefix(name, "config."):
+			setOnce(f.Kernel.Config, strings.TrimPrefix(name, "config."))
+		case strings.HasPrefix(name, "version."):
+			setOnce(f.Kernel.Version, strings.TrimPrefix(name, "version."))
+		default:
+			setOnce(f.Kernel.Attributes, name)
+		}
+	case "system":
+		setOnce(f.System, name)
+	case "memory":
+		setOnce(f.Memory, name)
+	case "network":
+		setOnce(f.Network, name)
+	case "storage":
+		setOnce(f.Storage, name)
+	}
+}
+
+func (f *nodeFeatures) addDevice(devices map[string]deviceFeatures, id string, attribute string, value string) {
+
+	device := devices[id]
+	if value == "true" {
+		switch attribute {
+		case "present":
+			device.Present = true
+		case "sriov.capable":
+			device.SRIOV = true
+		}
+	}
+	devices[id] = device
+}
+
+// splitAttribute splits 0300_10de.sriov.capable into 0300_10de and
+// sriov.capable
+func splitAttribute(name string) (string, string) {
+	s := strings.SplitN(name, ".", 2)
+	if len(s) != 2 {
+		return s[0], ""
+	}
+	return s[0], s[1]
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGetNodeFeatures(t *testing.T) {
+
+	nodes := []unstructured.Unstructured{
+		newNode("worker-1", map[string]string{
+			"feature.node.kubernetes.io/pci-0300_10de.present":       "true",
+			"feature.node.kubernetes.io/pci-0200_15b3.sriov.capable": "true",
+			"feature.node.kubernetes.io/cpu-cpuid.AVX512F":           "true",
+			"feature.node.kubernetes.io/kernel-config.NO_HZ":         "true",
+			"feature.node.kubernetes.io/kernel-version.full":         "4.18.0-193.el8.x86_64",
+			"feature.node.kubernetes.io/system-os_release.ID":        "rhcos",
+			"node-role.kubernetes.io/worker":                         "",
+		}),
+		newNode("worker-0", map[string]string{
+			"feature.node.kubernetes.io/cpu-cpuid.AVX2":      "true",
+			"feature.node.kubernetes.io/kernel-version.full": "4.18.0-240.el8.x86_64",
+			"feature.node.kubernetes.io/custom-rdma.capable": "true",
+		}),
+	}
+
+	f := getNodeFeatures(nodes)
+
+	if !f.PCI["10de"].Present || !f.PCI["0300_10de"].Present {
+		t.Errorf("PCI 10de not present: %+v", f.PCI)
+	}
+	if f.PCI["15b3"].Present || !f.PCI["15b3"].SRIOV {
+		t.Errorf("PCI 15b3 = %+v, want SRIOV only", f.PCI["15b3"])
+	}
+	if !f.CPU.CPUID["AVX512F"] || !f.CPU.CPUID["AVX2"] {
+		t.Errorf("CPUID is not the union of all nodes: %v", f.CPU.CPUID)
+	}
+	if f.Kernel.Config["NO_HZ"] != "true" {
+		t.Errorf("Kernel.Config = %v", f.Kernel.Config)
+	}
+	// worker-0 sorts first
+	if f.Kernel.Version["full"] != "4.18.0-240.el8.x86_64" {
+		t.Errorf("Kernel.Version[full] = %s", f.Kernel.Version["full"])
+	}
+	if f.System["os_release.ID"] != "rhcos" {
+		t.Errorf("System = %v", f.System)
+	}
+	if f.Raw["custom-rdma.capable"] != "true" {
+		t.Errorf("Raw = %v", f.Raw)
+	}
+	if _, found := f.Raw["node-role.kubernetes.io/worker"]; found {
+		t.Errorf("Raw contains labels of other prefixes: %v", f.Raw)
+	}
+
+	tmpl := `{{if (index .Node.Features.PCI "10de").Present}}nvidia{{end}} {{if (index .Node.Features.PCI "1002").Present}}amd{{end}}`
+	var buff bytes.Buffer
+	ctx := map[string]interface{}{"Node": nodeInformation{Features: f}}
+	if err := template.Must(template.New("test").Parse(tmpl)).Execute(&buff, ctx); err != nil {
+		t.Fatalf("Cannot render template: %v", err)
+	}
+	if buff.String() != "nvidia " {
+		t.Errorf("Rendered %q, want %q", buff.String(), "nvidia ")
+	}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	errs "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// rhcosRHELVersion maps all OpenShift releases starting with OpenShift up to
+// the next entry to the RHEL stream RHCOS is built from
+type rhcosRHELVersion struct {
+	OpenShift string
+	RHEL      string
+}
+
+// rhcosRHELVersions is sorted by OpenShift, releases newer than the last entry
+// are unknown and need an override
+var rhcosRHELVersions = []rhcosRHELVersion{
+	{OpenShift: "4.1", RHEL: "8.0"},
+	{OpenShift: "4.4", RHEL: "8.1"},
+	{OpenShift: "4.5", RHEL: "8.2"},
+	{OpenShift: "4.7", RHEL: "8.3"},
+}
+
+// rhcosNewestKnown is the newest OpenShift release the table is valid for
+var rhcosNewestKnown = "4.7"
+
+// rhcosVersionsConfigMap in the operator namespace overrides or extends the
+// table, keys are OpenShift releases and values RHEL versions e.g. "4.8": "8.4"
+const rhcosVersionsConfigMap = "special-resource-os-versions"
+
+// compareVersions compares dotted numeric versions component by component,
+// missing components count as 0 so 4.6 == 4.6.0
+func compareVersions(a string, b string) (int, error) {
+
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		var err error
+		if i < len(as) {
+			if x, err = strconv.Atoi(as[i]); err != nil {
+				return 0, errs.New("Invalid version " + a)
+			}
+		}
+		if i < len(bs) {
+			if y, err = strconv.Atoi(bs[i]); err != nil {
+				return 0, errs.New("Invalid version " + b)
+			}
+		}
+		if x < y {
+			return -1, nil
+		}
+		if x > y {
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// rhelVersionFor looks up the RHEL version of an OpenShift release, overrides
+// take precedence and may extend the table beyond rhcosNewestKnown
+func rhelVersionFor(openshift string, overrides map[string]string) (string, error) {
+
+	table := append([]rhcosRHELVersion{}, rhcosRHELVersions...)
+	newest := rhcosNewestKnown
+
+	for ocp, rhel := range overrides {
+		if _, err := compareVersions(ocp, rhel); err != nil {
+			return "", errs.Wrap(err, "Invalid override "+ocp+": "+rhel)
+		}
+		table = append(table, rhcosRHELVersion{OpenShift: ocp, RHEL: rhel})
+		if c, _ := compareVersions(ocp, newest); c > 0 {
+			newest = ocp
+		}
+	}
+
+	// Overrides win over table entries of the same release
+	sort.SliceStable(table, func(i, j int) bool {
+		c, _ := compareVersions(table[i].OpenShift, table[j].OpenShift)
+		return c < 0
+	})
+
+	c, err := compareVersions(openshift, newest)
+	if err != nil {
+		return "", err
+	}
+	if c > 0 {
+		return "", errs.New("Unknown RHEL version for OpenShift " + openshift + ", add it to ConfigMap " + rhcosVersionsConfigMap)
+	}
+
+	rhel := ""
+	for _, entry := range table {
+		if c, _ := compareVersions(openshift, entry.OpenShift); c < 0 {
+			break
+		}
+		rhel = entry.RHEL
+	}
+
+	if rhel == "" {
+		return "", errs.New("Unknown RHEL version for OpenShift " + openshift)
+	}
+
+	return rhel, nil
+}
+
+// getRHCOSOverrides reads the override ConfigMap from the operator namespace,
+// a missing ConfigMap means no overrides
+func getRHCOSOverrides() (map[string]string, error) {
+
+	namespace := os.Getenv("OPERATOR_NAMESPACE")
+	if namespace == "" {
+		return nil, nil
+	}
+
+	cm, err := kubeclient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), rhcosVersionsConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot get ConfigMap "+rhcosVersionsConfigMap)
+	}
+
+	return cm.Data, nil
+}
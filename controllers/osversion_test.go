@@ -0,0 +1,94 @@
+package controllers
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"4.6", "4.6", 0},
+		{"4.6", "4.6.0", 0},
+		{"4.6", "4.7", -1},
+		{"4.10", "4.9", 1},
+		{"8.2", "8.10", -1},
+		{"v4.7.1", "4.7", 1},
+	}
+
+	for _, test := range tests {
+		got, err := compareVersions(test.a, test.b)
+		if err != nil {
+			t.Fatalf("compareVersions(%s, %s) failed: %v", test.a, test.b, err)
+		}
+		if got != test.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+
+	if _, err := compareVersions("4.x", "4.6"); err == nil {
+		t.Errorf("compareVersions(4.x, 4.6) should fail")
+	}
+}
+
+func TestRHELVersionFor(t *testing.T) {
+
+	tests := []struct {
+		openshift string
+		overrides map[string]string
+		want      string
+	}{
+		{"4.1", nil, "8.0"},
+		{"4.3", nil, "8.0"},
+		{"4.4", nil, "8.1"},
+		{"4.5", nil, "8.2"},
+		{"4.6", nil, "8.2"},
+		{"4.7", nil, "8.3"},
+		{"4.8", map[string]string{"4.8": "8.4"}, "8.4"},
+		{"4.6", map[string]string{"4.6": "8.3"}, "8.3"},
+		{"4.5", map[string]string{"4.6": "8.3"}, "8.2"},
+	}
+
+	for _, test := range tests {
+		got, err := rhelVersionFor(test.openshift, test.overrides)
+		if err != nil {
+			t.Fatalf("rhelVersionFor(%s, %v) failed: %v", test.openshift, test.overrides, err)
+		}
+		if got != test.want {
+			t.Errorf("rhelVersionFor(%s, %v) = %s, want %s", test.openshift, test.overrides, got, test.want)
+		}
+	}
+
+	for _, openshift := range []string{"4.8", "4.10", "3.11"} {
+		if got, err := rhelVersionFor(openshift, nil); err == nil {
+			t.Errorf("rhelVersionFor(%s) = %s, want error", openshift, got)
+		}
+	}
+}
+
+func TestRenderOperatingSystem(t *testing.T) {
+
+	tests := []struct {
+		rel, maj, min, rhel string
+		want                [3]string
+	}{
+		{"rhcos", "4", "6", "", [3]string{"rhel8", "rhel8.2", "8.2"}},
+		{"rhcos", "4", "8", "8.4", [3]string{"rhel8", "rhel8.4", "8.4"}},
+		{"rhel", "8", "3", "", [3]string{"rhel8", "rhel8.3", "8.3"}},
+		{"fedora", "31", "", "", [3]string{"fedora31", "fedora31", "31"}},
+	}
+
+	for _, test := range tests {
+		major, majorMinor, decimal, err := renderOperatingSystem(test.rel, test.maj, test.min, test.rhel, nil)
+		if err != nil {
+			t.Fatalf("renderOperatingSystem(%s %s.%s) failed: %v", test.rel, test.maj, test.min, err)
+		}
+		if got := [3]string{major, majorMinor, decimal}; got != test.want {
+			t.Errorf("renderOperatingSystem(%s %s.%s) = %v, want %v", test.rel, test.maj, test.min, got, test.want)
+		}
+	}
+
+	if _, _, _, err := renderOperatingSystem("rhcos", "4", "9", "", nil); err == nil {
+		t.Errorf("renderOperatingSystem(rhcos 4.9) should fail")
+	}
+}
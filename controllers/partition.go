@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	archLabel   = "kubernetes.io/arch"
+	kernelLabel = "feature.node.kubernetes.io/kernel-version.full"
+)
+
+// partition is a group of nodes with the same architecture and kernel, each
+// partition gets its own build and driver-container. Objects of a recipe
+// that have {{.Partition}} in their name are rendered per partition and
+// pinned to the nodes of the partition, all others are applied once.
+type partition struct {
+	ID     string
+	Arch   string
+	Kernel string
+	nodes  []unstructured.Unstructured
+}
+
+// partitionNodes groups nodes by architecture and kernel, sorted by ID so
+// partitions are always reconciled in the same order
+func partitionNodes(nodes []unstructured.Unstructured) []partition {
+
+	partitions := map[string]*partition{}
+
+	for _, n := range nodes {
+		arch := nodeArch(n)
+		kernel := n.GetLabels()[kernelLabel]
+		id := partitionID(arch, kernel)
+
+		p, found := partitions[id]
+		if !found {
+			p = &partition{ID: id, Arch: arch, Kernel: kernel}
+			partitions[id] = p
+		}
+		p.nodes = append(p.nodes, n)
+	}
+
+	sorted := []partition{}
+	for _, p := range partitions {
+		sorted = append(sorted, *p)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	return sorted
+}
+
+// partitionID is DNS-safe and short enough to be appended to object names
+// e.g. amd64-1c9e2f4b, kernel versions contain dots and underscores
+func partitionID(arch string, kernel string) string {
+	h := fnv.New32a()
+	h.Write([]byte(kernel))
+	return fmt.Sprintf("%s-%08x", strings.ToLower(arch), h.Sum32())
+}
+
+// nodeArch prefers the well-known label over the node info
+func nodeArch(n unstructured.Unstructured) string {
+
+	if arch, found := n.GetLabels()[archLabel]; found {
+		return arch
+	}
+	arch, _, _ := unstructured.NestedString(n.Object, "status", "nodeInfo", "architecture")
+	return arch
+}
+
+// selectedNodes returns the nodes of the partition we are reconciling, all
+// selected nodes if we are not reconciling a partition
+func selectedNodes() []unstructured.Unstructured {
+	if node.partition != nil {
+		return node.partition.nodes
+	}
+	return node.list.Items
+}
+
+// inPartition is true if n belongs to the partition we are reconciling
+func inPartition(n unstructured.Unstructured) bool {
+	if node.partition == nil {
+		return true
+	}
+	return nodeArch(n) == node.partition.Arch && n.GetLabels()[kernelLabel] == node.partition.Kernel
+}
+
+// pinToPartition adds the arch and kernel of the partition to the
+// nodeSelector of DaemonSets and BuildConfigs rendered for the partition
+func pinToPartition(obj *unstructured.Unstructured) error {
+
+	p := node.partition
+	if p == nil || !strings.Contains(obj.GetName(), p.ID) {
+		return nil
+	}
+
+	var fields []string
+	switch obj.GetKind() {
+	case "DaemonSet":
+		fields = []string{"spec", "template", "spec", "nodeSelector"}
+	case "BuildConfig":
+		fields = []string{"spec", "nodeSelector"}
+	default:
+		return nil
+	}
+
+	selector, _, err := unstructured.NestedStringMap(obj.Object, fields...)
+	if err != nil {
+		return errs.Wrap(err, "Cannot extract nodeSelector of "+obj.GetName())
+	}
+	if selector == nil {
+		selector = map[string]string{}
+	}
+
+	selector[archLabel] = p.Arch
+	// A build can run on any node of the same arch
+	if obj.GetKind() == "DaemonSet" {
+		selector[kernelLabel] = p.Kernel
+	}
+
+	log.Info("Pinning to partition", "Kind", obj.GetKind(), "Name", obj.GetName(), "Partition", p.ID)
+
+	return unstructured.SetNestedStringMap(obj.Object, selector, fields...)
+}
+
+// sharedObjects are the objects without {{.Partition}} in their name that a
+// partition already applied during this reconcile, with the hash of their
+// rendering
+var sharedObjects = map[srov1beta1.SpecialResourceInventoryObject]uint64{}
+
+// renderingHash is stable, maps are marshalled with sorted keys
+func renderingHash(obj *unstructured.Unstructured) (uint64, error) {
+
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return 0, errs.Wrap(err, "Cannot marshal "+obj.GetName())
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64(), nil
+}
+
+// sharedObjectApplied is true if obj is shared by all partitions and was
+// already applied by a previous partition. A shared object that renders
+// differently per partition would be overwritten by each partition, the
+// recipe has to render it per partition instead. The hash of the rendering
+// is returned to record obj once applied.
+func sharedObjectApplied(obj *unstructured.Unstructured) (bool, uint64, error) {
+
+	p := node.partition
+	if p == nil || strings.Contains(obj.GetName(), p.ID) {
+		return false, 0, nil
+	}
+
+	hash, err := renderingHash(obj)
+	if err != nil {
+		return false, 0, err
+	}
+
+	applied, found := sharedObjects[inventoryObjectFrom(obj)]
+	if !found {
+		return false, hash, nil
+	}
+	if hash != applied {
+		return false, 0, errs.New(obj.GetKind() + " " + obj.GetName() + " renders differently for partition " + p.ID + ", add {{.Partition}} to its name")
+	}
+
+	return true, hash, nil
+}
+
+// recordSharedObject records obj as applied if it is shared by all
+// partitions, following partitions skip it
+func recordSharedObject(obj *unstructured.Unstructured, hash uint64) {
+
+	p := node.partition
+	if p == nil || strings.Contains(obj.GetName(), p.ID) {
+		return
+	}
+	sharedObjects[inventoryObjectFrom(obj)] = hash
+}
+
+// pruneUnpartitioned deletes the object obj replaced when the recipes added
+// {{.Partition}} to the names of their builds and driver-containers. Upgraded
+// clusters would otherwise run both driver-containers on the same nodes.
+func pruneUnpartitioned(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	p := node.partition
+	if p == nil || !strings.HasSuffix(obj.GetName(), "-"+p.ID) {
+		return nil
+	}
+
+	legacy := &unstructured.Unstructured{}
+	switch obj.GetKind() {
+	case "DaemonSet":
+		legacy.SetAPIVersion(obj.GetAPIVersion())
+		legacy.SetKind(obj.GetKind())
+	// Builds of the kaniko backend are Jobs of the same name
+	case "BuildConfig":
+		if runInfo.BuildBackend == buildBackendKaniko {
+			legacy.SetAPIVersion("batch/v1")
+			legacy.SetKind("Job")
+		} else {
+			legacy.SetAPIVersion(obj.GetAPIVersion())
+			legacy.SetKind(obj.GetKind())
+		}
+	default:
+		return nil
+	}
+	legacy.SetNamespace(obj.GetNamespace())
+	legacy.SetName(strings.TrimSuffix(obj.GetName(), "-"+p.ID))
+
+	return pruneObject(legacy, r)
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPartitionNodes(t *testing.T) {
+
+	x86 := "4.18.0-193.el8.x86_64"
+	arm := "4.18.0-193.el8.aarch64"
+
+	nodes := []unstructured.Unstructured{
+		newNode("worker-0", map[string]string{archLabel: "amd64", kernelLabel: x86}),
+		newNode("worker-1", map[string]string{archLabel: "arm64", kernelLabel: arm}),
+		newNode("worker-2", map[string]string{archLabel: "amd64", kernelLabel: x86}),
+	}
+
+	partitions := partitionNodes(nodes)
+	if len(partitions) != 2 {
+		t.Fatalf("Got %d partitions, want 2", len(partitions))
+	}
+
+	amd64, arm64 := partitions[0], partitions[1]
+	if amd64.Arch != "amd64" || amd64.Kernel != x86 || len(amd64.nodes) != 2 {
+		t.Errorf("amd64 partition = %+v", amd64)
+	}
+	if arm64.Arch != "arm64" || arm64.Kernel != arm || len(arm64.nodes) != 1 {
+		t.Errorf("arm64 partition = %+v", arm64)
+	}
+	if amd64.ID != partitionID("amd64", x86) {
+		t.Errorf("Partition ID %s is not stable", amd64.ID)
+	}
+
+	node.partition = &arm64
+	defer func() { node.partition = nil }()
+
+	ds := &unstructured.Unstructured{}
+	ds.SetKind("DaemonSet")
+	ds.SetName("simple-kmod-driver-container-rhel8-" + arm64.ID)
+	if err := unstructured.SetNestedStringMap(ds.Object, map[string]string{"node-role.kubernetes.io/worker": ""}, "spec", "template", "spec", "nodeSelector"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pinToPartition(ds); err != nil {
+		t.Fatalf("pinToPartition failed: %v", err)
+	}
+
+	selector, _, _ := unstructured.NestedStringMap(ds.Object, "spec", "template", "spec", "nodeSelector")
+	if selector[archLabel] != "arm64" || selector[kernelLabel] != arm || len(selector) != 3 {
+		t.Errorf("nodeSelector = %v", selector)
+	}
+
+	if inPartition(nodes[0]) || !inPartition(nodes[1]) {
+		t.Errorf("inPartition does not match the arm64 partition")
+	}
+}
+
+func TestSharedObjectApplied(t *testing.T) {
+
+	x86 := "4.18.0-193.el8.x86_64"
+	partitions := partitionNodes([]unstructured.Unstructured{
+		newNode("worker-0", map[string]string{archLabel: "amd64", kernelLabel: x86}),
+		newNode("worker-1", map[string]string{archLabel: "arm64", kernelLabel: "4.18.0-193.el8.aarch64"}),
+	})
+
+	sharedObjects = map[srov1beta1.SpecialResourceInventoryObject]uint64{}
+	defer func() {
+		sharedObjects = map[srov1beta1.SpecialResourceInventoryObject]uint64{}
+		node.partition = nil
+	}()
+
+	configMap := func(data string) *unstructured.Unstructured {
+		cm := &unstructured.Unstructured{}
+		cm.SetKind("ConfigMap")
+		cm.SetName("simple-kmod-driver-container-entrypoint")
+		cm.Object["data"] = map[string]interface{}{"kernel": data}
+		return cm
+	}
+
+	node.partition = &partitions[0]
+	shared, hash, err := sharedObjectApplied(configMap("4.18"))
+	if err != nil || shared {
+		t.Fatalf("sharedObjectApplied of the first partition = %v, %v", shared, err)
+	}
+	recordSharedObject(configMap("4.18"), hash)
+
+	// Objects rendered per partition are always applied
+	ds := &unstructured.Unstructured{}
+	ds.SetKind("DaemonSet")
+	ds.SetName("simple-kmod-driver-container-rhel8-" + partitions[0].ID)
+	recordSharedObject(ds, 1)
+	if len(sharedObjects) != 1 {
+		t.Errorf("sharedObjects = %v, want the ConfigMap only", sharedObjects)
+	}
+
+	node.partition = &partitions[1]
+	if shared, _, err := sharedObjectApplied(configMap("4.18")); err != nil || !shared {
+		t.Errorf("sharedObjectApplied of the second partition = %v, %v, want applied", shared, err)
+	}
+	if _, _, err := sharedObjectApplied(configMap(x86)); err == nil {
+		t.Errorf("sharedObjectApplied of a shared object rendered differently succeeded")
+	}
+}
+
+func TestPruneUnpartitioned(t *testing.T) {
+
+	partitions := partitionNodes([]unstructured.Unstructured{
+		newNode("worker-0", map[string]string{archLabel: "amd64", kernelLabel: "4.18.0-193.el8.x86_64"}),
+	})
+	node.partition = &partitions[0]
+	defer func() { node.partition = nil }()
+
+	r := &SpecialResourceReconciler{}
+	r.specialresource.Name = "simple-kmod"
+	r.specialresource.UID = "1234"
+	controller := true
+	owner := []metav1.OwnerReference{{Name: "simple-kmod", UID: r.specialresource.UID, Controller: &controller}}
+
+	object := func(kind string, name string, owners []metav1.OwnerReference) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetKind(kind)
+		obj.SetNamespace("simple-kmod")
+		obj.SetName(name)
+		obj.SetOwnerReferences(owners)
+		return obj
+	}
+
+	fake := &fakeClient{objects: []*unstructured.Unstructured{
+		object("DaemonSet", "simple-kmod-driver-container-rhel8", owner),
+		object("BuildConfig", "simple-kmod-driver-build", owner),
+		// Not created by the SpecialResource, left alone
+		object("DaemonSet", "simple-kmod-device-plugin", nil),
+	}}
+	r.Client = fake
+
+	suffix := "-" + partitions[0].ID
+	for _, obj := range []*unstructured.Unstructured{
+		object("DaemonSet", "simple-kmod-driver-container-rhel8"+suffix, nil),
+		object("BuildConfig", "simple-kmod-driver-build"+suffix, nil),
+		object("DaemonSet", "simple-kmod-device-plugin"+suffix, nil),
+		// Not rendered per partition
+		object("ConfigMap", "simple-kmod-entrypoint", nil),
+	} {
+		if err := pruneUnpartitioned(obj, r); err != nil {
+			t.Fatalf("pruneUnpartitioned(%s) failed: %v", obj.GetName(), err)
+		}
+	}
+
+	want := []string{"DaemonSet/simple-kmod-driver-container-rhel8", "BuildConfig/simple-kmod-driver-build"}
+	if !reflect.DeepEqual(fake.deleted, want) {
+		t.Errorf("deleted = %v, want %v", fake.deleted, want)
+	}
+}
@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	errs "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// runtimeProvider contributes named keys to the template context, a key
+// named like a field of runtimeInformation also sets the field
+type runtimeProvider interface {
+	// Keys returns the names of all keys Provide returns
+	Keys() []string
+	Provide(r *SpecialResourceReconciler) (map[string]interface{}, error)
+}
+
+// runtimeProviderFunc adapts a function to a runtimeProvider
+type runtimeProviderFunc struct {
+	keys    []string
+	provide func(r *SpecialResourceReconciler) (map[string]interface{}, error)
+}
+
+func (p runtimeProviderFunc) Keys() []string { return p.keys }
+
+func (p runtimeProviderFunc) Provide(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+	return p.provide(r)
+}
+
+// registeredRuntimeProvider wraps a provider with its name exposed in
+// .Available. Optional providers that fail or whose requirements are not
+// met are marked unavailable instead of failing the reconciliation.
+type registeredRuntimeProvider struct {
+	name     string
+	optional bool
+	requires func() bool
+	provider runtimeProvider
+}
+
+// runtimeProviders run in the order they are registered
+var runtimeProviders = []registeredRuntimeProvider{}
+
+func registerRuntimeProvider(name string, optional bool, requires func() bool, provider runtimeProvider) {
+	for _, p := range runtimeProviders {
+		if p.name == name {
+			panic("runtime provider registered twice: " + name)
+		}
+	}
+	runtimeProviders = append(runtimeProviders, registeredRuntimeProvider{
+		name:     name,
+		optional: optional,
+		requires: requires,
+		provider: provider,
+	})
+}
+
+func init() {
+
+	registerRuntimeProvider("OperatingSystem", false, nil, runtimeProviderFunc{
+		keys: []string{"OperatingSystemMajor", "OperatingSystemMajorMinor", "OperatingSystemDecimal"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			major, majorMinor, decimal, err := getOperatingSystem()
+			return map[string]interface{}{
+				"OperatingSystemMajor":      major,
+				"OperatingSystemMajorMinor": majorMinor,
+				"OperatingSystemDecimal":    decimal,
+			}, err
+		}})
+
+	registerRuntimeProvider("KernelVersion", false, nil, runtimeProviderFunc{
+		keys: []string{"KernelVersion"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			kernelVersion, err := getKernelVersion()
+			return map[string]interface{}{"KernelVersion": kernelVersion}, err
+		}})
+
+	openshift := func() bool { return runInfo.Capabilities.OpenShift }
+
+	registerRuntimeProvider("ClusterVersion", true, openshift, runtimeProviderFunc{
+		keys: []string{"ClusterVersion", "ClusterVersionMajorMinor"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			version, majorMinor, err := getClusterVersion()
+			return map[string]interface{}{
+				"ClusterVersion":           version,
+				"ClusterVersionMajorMinor": majorMinor,
+			}, err
+		}})
+
+	// The builder-dockercfg secret is created by OpenShift builds
+	registerRuntimeProvider("PushSecretName", true, func() bool { return runInfo.Capabilities.Builds }, runtimeProviderFunc{
+		keys: []string{"PushSecretName"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			name, err := getPushSecretName(r)
+			return map[string]interface{}{"PushSecretName": name}, err
+		}})
+
+	registerRuntimeProvider("OSImageURL", true, openshift, runtimeProviderFunc{
+		keys: []string{"OSImageURL"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			url, err := getOSImageURL(r)
+			return map[string]interface{}{"OSImageURL": url}, err
+		}})
+
+	registerRuntimeProvider("Proxy", true, openshift, runtimeProviderFunc{
+		keys: []string{"Proxy"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			proxy, err := getProxyConfiguration(r)
+			return map[string]interface{}{"Proxy": proxy}, err
+		}})
+
+	registerRuntimeProvider("Monitoring", true, func() bool { return runInfo.Capabilities.Monitoring }, runtimeProviderFunc{
+		keys: []string{"Monitoring"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			monitoring, err := getMonitoring()
+			return map[string]interface{}{"Monitoring": monitoring}, err
+		}})
+
+	registerRuntimeProvider("PCIDevices", true, nil, runtimeProviderFunc{
+		keys: []string{"PCIDevices"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			return map[string]interface{}{"PCIDevices": getPCIDevices()}, nil
+		}})
+
+	registerRuntimeProvider("Node", true, nil, runtimeProviderFunc{
+		keys: []string{"Node"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"Node": nodeInformation{Features: getNodeFeatures(selectedNodes())},
+			}, nil
+		}})
+
+	registerRuntimeProvider("RealTimeKernel", true, nil, runtimeProviderFunc{
+		keys: []string{"RealTimeKernel"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			rt, err := getRealTimeKernel()
+			return map[string]interface{}{"RealTimeKernel": rt}, err
+		}})
+
+	registerRuntimeProvider("Arch", true, nil, runtimeProviderFunc{
+		keys: []string{"Arch"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			arch, err := getArch()
+			return map[string]interface{}{"Arch": arch}, err
+		}})
+
+	registerRuntimeProvider("ContainerRuntime", true, nil, runtimeProviderFunc{
+		keys: []string{"ContainerRuntime", "ContainerRuntimeVersion"},
+		provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+			runtime, version, err := getContainerRuntime()
+			return map[string]interface{}{
+				"ContainerRuntime":        runtime,
+				"ContainerRuntimeVersion": version,
+			}, err
+		}})
+}
+
+// runRuntimeProviders fills runInfo from all registered providers, pinned
+// keys of the specialresource take precedence over provided values and a
+// provider whose keys are all pinned is not run at all.
+func runRuntimeProviders(r *SpecialResourceReconciler, pins map[string]string) error {
+
+	runInfo.Available = map[string]bool{}
+	runInfo.Values = map[string]interface{}{}
+
+	// Only keys of providers can be pinned, not internal fields of runInfo
+	// like Partition, a misspelled key would be ignored otherwise
+	provided := runtimeProviderKeys()
+	names := []string{}
+	for key := range pins {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	for _, key := range names {
+		if !provided[key] {
+			return errs.New("Cannot pin runtime information " + key + ", no runtime provider has this key")
+		}
+		log.Info("Pinned runtime information", "Key", key, "Value", pins[key])
+		if err := setRuntimeInformation(key, pins[key]); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range runtimeProviders {
+
+		keys := []string{}
+		for _, key := range p.provider.Keys() {
+			if _, pinned := pins[key]; !pinned {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(keys) == 0 {
+			runInfo.Available[p.name] = true
+			continue
+		}
+
+		if p.requires != nil && !p.requires() {
+			log.Info("Skipping runtime information, API not available", "Name", p.name)
+			runInfo.Available[p.name] = false
+			resetRuntimeInformation(keys)
+			continue
+		}
+
+		log.Info("Get " + p.name)
+		values, err := p.provider.Provide(r)
+		if err != nil && !p.optional {
+			return errs.Wrap(err, "Failed to get "+p.name)
+		}
+		if err != nil {
+			log.Info("Runtime information not available", "Name", p.name, "error", fmt.Sprintf("%v", err))
+			runInfo.Available[p.name] = false
+			resetRuntimeInformation(keys)
+			continue
+		}
+
+		runInfo.Available[p.name] = true
+		for _, key := range keys {
+			if err := setRuntimeInformation(key, values[key]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runtimeProviderKeys returns the keys of all registered providers
+func runtimeProviderKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, p := range runtimeProviders {
+		for _, key := range p.provider.Keys() {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// setRuntimeInformation stores a value in Values and in the field of
+// runInfo with the same name, if there is one
+func setRuntimeInformation(key string, value interface{}) error {
+
+	field := reflect.ValueOf(&runInfo).Elem().FieldByName(key)
+	if field.IsValid() && field.CanSet() {
+		v := reflect.ValueOf(value)
+		if !v.IsValid() || !v.Type().AssignableTo(field.Type()) {
+			return errs.New(fmt.Sprintf("Cannot set runtime information %s of type %s to %v", key, field.Type(), value))
+		}
+		field.Set(v)
+	}
+
+	runInfo.Values[key] = value
+	return nil
+}
+
+// resetRuntimeInformation clears keys of an unavailable provider so no stale
+// value of a previous specialresource is rendered
+func resetRuntimeInformation(keys []string) {
+	for _, key := range keys {
+		field := reflect.ValueOf(&runInfo).Elem().FieldByName(key)
+		if field.IsValid() && field.CanSet() {
+			field.Set(reflect.Zero(field.Type()))
+		}
+		delete(runInfo.Values, key)
+	}
+}
+
+// templateContext returns all fields of runInfo merged with the provided
+// Values, this is what recipes are rendered with
+func (ri runtimeInformation) templateContext() map[string]interface{} {
+
+	ctx := map[string]interface{}{}
+
+	v := reflect.ValueOf(ri)
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; name != "Values" {
+			ctx[name] = v.Field(i).Interface()
+		}
+	}
+	for key, value := range ri.Values {
+		ctx[key] = value
+	}
+
+	return ctx
+}
+
+// getPCIDevices returns the union of all PCI devices NFD found on the
+// selected nodes e.g. 0300_10de for pci-0300_10de.present
+func getPCIDevices() []string {
+
+	prefix := "feature.node.kubernetes.io/pci-"
+	devices := map[string]bool{}
+
+	for _, node := range selectedNodes() {
+		for label, value := range node.GetLabels() {
+			if strings.HasPrefix(label, prefix) && strings.HasSuffix(label, ".present") && value == "true" {
+				devices[strings.TrimSuffix(strings.TrimPrefix(label, prefix), ".present")] = true
+			}
+		}
+	}
+
+	pci := []string{}
+	for device := range devices {
+		pci = append(pci, device)
+	}
+	sort.Strings(pci)
+
+	return pci
+}
+
+// getRealTimeKernel returns true if the kernel is a PREEMPT_RT kernel e.g.
+// 4.18.0-193.rt13.51.el8.x86_64
+func getRealTimeKernel() (bool, error) {
+
+	kernelVersion, err := getKernelVersion()
+	if err != nil {
+		return false, err
+	}
+
+	for _, node := range selectedNodes() {
+		if node.GetLabels()["feature.node.kubernetes.io/kernel-config.PREEMPT_RT"] == "true" {
+			return true, nil
+		}
+	}
+
+	return strings.Contains(kernelVersion, ".rt"), nil
+}
+
+// getNodeInfo returns a field of status.nodeInfo of the first node,
+// assuming all nodes are the same
+func getNodeInfo(field string) (string, error) {
+
+	for _, node := range selectedNodes() {
+		value, found, err := unstructured.NestedString(node.Object, "status", "nodeInfo", field)
+		if err != nil || !found {
+			return "", errs.New("Cannot extract status.nodeInfo." + field + " of Node " + node.GetName())
+		}
+		return value, nil
+	}
+
+	return "", errs.New("No Node to extract status.nodeInfo." + field + " from")
+}
+
+// getContainerRuntime splits e.g. cri-o://1.19.0 into cri-o and 1.19.0
+func getContainerRuntime() (string, string, error) {
+
+	runtime, err := getNodeInfo("containerRuntimeVersion")
+	if err != nil {
+		return "", "", err
+	}
+
+	s := strings.SplitN(runtime, "://", 2)
+	if len(s) != 2 {
+		return runtime, "", nil
+	}
+
+	return s[0], s[1], nil
+}
+
+// getArch returns the architecture of the first node e.g. amd64, arm64,
+// ppc64le or s390x, all nodes of a partition have the same
+func getArch() (string, error) {
+
+	for _, n := range selectedNodes() {
+		if arch := nodeArch(n); arch != "" {
+			return arch, nil
+		}
+		return "", errs.New("Cannot get architecture of Node " + n.GetName())
+	}
+
+	return "", errs.New("No Node to get the architecture from")
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunRuntimeProviders(t *testing.T) {
+
+	calls := map[string]int{}
+	provider := func(name string, optional bool, requires func() bool, values map[string]interface{}, err error) registeredRuntimeProvider {
+		keys := []string{}
+		for key := range values {
+			keys = append(keys, key)
+		}
+		return registeredRuntimeProvider{
+			name:     name,
+			optional: optional,
+			requires: requires,
+			provider: runtimeProviderFunc{
+				keys: keys,
+				provide: func(r *SpecialResourceReconciler) (map[string]interface{}, error) {
+					calls[name]++
+					return values, err
+				},
+			},
+		}
+	}
+	unavailable := func() bool { return false }
+
+	tests := []struct {
+		name      string
+		providers []registeredRuntimeProvider
+		pins      map[string]string
+		want      map[string]interface{}
+		available map[string]bool
+		calls     map[string]int
+		err       bool
+	}{
+		{
+			name: "pins take precedence",
+			providers: []registeredRuntimeProvider{
+				provider("OperatingSystem", false, nil, map[string]interface{}{"OperatingSystemMajor": "rhel8", "OperatingSystemDecimal": "8.2"}, nil),
+			},
+			pins:      map[string]string{"OperatingSystemMajor": "rhel9"},
+			want:      map[string]interface{}{"OperatingSystemMajor": "rhel9", "OperatingSystemDecimal": "8.2"},
+			available: map[string]bool{"OperatingSystem": true},
+			calls:     map[string]int{"OperatingSystem": 1},
+		},
+		{
+			name: "provider with all keys pinned is skipped",
+			providers: []registeredRuntimeProvider{
+				provider("KernelVersion", false, nil, map[string]interface{}{"KernelVersion": "4.18.0"}, errors.New("no nodes")),
+			},
+			pins:      map[string]string{"KernelVersion": "5.14.0"},
+			want:      map[string]interface{}{"KernelVersion": "5.14.0"},
+			available: map[string]bool{"KernelVersion": true},
+			calls:     map[string]int{},
+		},
+		{
+			name: "unavailable providers clear their keys",
+			providers: []registeredRuntimeProvider{
+				provider("ClusterVersion", true, unavailable, map[string]interface{}{"ClusterVersion": "4.6.1"}, nil),
+				provider("OSImageURL", true, nil, map[string]interface{}{"OSImageURL": "quay.io/os"}, errors.New("not found")),
+			},
+			want:      map[string]interface{}{"ClusterVersion": "", "OSImageURL": ""},
+			available: map[string]bool{"ClusterVersion": false, "OSImageURL": false},
+			calls:     map[string]int{"OSImageURL": 1},
+		},
+		{
+			name: "required provider fails",
+			providers: []registeredRuntimeProvider{
+				provider("KernelVersion", false, nil, map[string]interface{}{"KernelVersion": ""}, errors.New("no nodes")),
+			},
+			err: true,
+		},
+		{
+			name: "pin of the wrong type",
+			providers: []registeredRuntimeProvider{
+				provider("Proxy", true, nil, map[string]interface{}{"Proxy": proxyConfiguration{}}, nil),
+			},
+			pins: map[string]string{"Proxy": "http://proxy:3128"},
+			err:  true,
+		},
+		{
+			name: "pin of an internal field",
+			providers: []registeredRuntimeProvider{
+				provider("KernelVersion", false, nil, map[string]interface{}{"KernelVersion": "4.18.0"}, nil),
+			},
+			pins: map[string]string{"Partition": "gpu"},
+			err:  true,
+		},
+		{
+			name: "misspelled pin",
+			providers: []registeredRuntimeProvider{
+				provider("KernelVersion", false, nil, map[string]interface{}{"KernelVersion": "4.18.0"}, nil),
+			},
+			pins: map[string]string{"KernelVerison": "5.14.0"},
+			err:  true,
+		},
+	}
+
+	registered, saved := runtimeProviders, runInfo
+	defer func() { runtimeProviders, runInfo = registered, saved }()
+
+	for _, test := range tests {
+
+		runtimeProviders = test.providers
+		calls = map[string]int{}
+		// Stale values of a previous specialresource
+		runInfo.ClusterVersion = "4.5.0"
+		runInfo.OSImageURL = "quay.io/stale"
+
+		err := runRuntimeProviders(nil, test.pins)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: runRuntimeProviders should fail", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: runRuntimeProviders failed: %v", test.name, err)
+			continue
+		}
+
+		ctx := runInfo.templateContext()
+		for key, value := range test.want {
+			if ctx[key] != value {
+				t.Errorf("%s: %s = %v, want %v", test.name, key, ctx[key], value)
+			}
+		}
+		if !reflect.DeepEqual(runInfo.Available, test.available) {
+			t.Errorf("%s: Available = %v, want %v", test.name, runInfo.Available, test.available)
+		}
+		if !reflect.DeepEqual(calls, test.calls) {
+			t.Errorf("%s: providers called %v, want %v", test.name, calls, test.calls)
+		}
+	}
+}
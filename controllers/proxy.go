@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+
+	errs "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	trustedCAVolume    = "trusted-ca"
+	trustedCAMountPath = "/etc/pki/ca-trust/extracted/pem"
+	// The cluster network operator injects the trusted CA bundle into
+	// ConfigMaps with this label
+	trustedCAInjectLabel = "config.openshift.io/inject-trusted-cabundle"
+)
+
+// podSpecPaths are the paths to the pod spec of all kinds we inject the
+// proxy into
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+func setupProxy(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	if obj.GetKind() == "BuildConfig" {
+		if err := setupBuildConfigProxy(obj); err != nil {
+			return errs.Wrap(err, "Cannot setup BuildConfig Proxy")
+		}
+		return nil
+	}
+
+	path, found := podSpecPaths[obj.GetKind()]
+	if !found {
+		log.Info("Proxy not supported, skipping", "Kind", obj.GetKind(), "Name", obj.GetName())
+		return nil
+	}
+
+	if err := setupPodSpecProxy(obj, path, r); err != nil {
+		return errs.Wrap(err, "Cannot setup "+obj.GetKind()+" Proxy")
+	}
+
+	return nil
+}
+
+func proxyEnv() []interface{} {
+	return []interface{}{
+		map[string]interface{}{"name": "HTTP_PROXY", "value": runInfo.Proxy.HttpProxy},
+		map[string]interface{}{"name": "HTTPS_PROXY", "value": runInfo.Proxy.HttpsProxy},
+		map[string]interface{}{"name": "NO_PROXY", "value": runInfo.Proxy.NoProxy},
+	}
+}
+
+// mergeEnv replaces entries with the same name so repeated reconciles do
+// not accumulate env entries
+func mergeEnv(env []interface{}, add []interface{}) []interface{} {
+
+	for _, a := range add {
+		name := a.(map[string]interface{})["name"]
+		replaced := false
+		for i, e := range env {
+			if e, ok := e.(map[string]interface{}); ok && e["name"] == name {
+				env[i] = a
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			env = append(env, a)
+		}
+	}
+
+	return env
+}
+
+// mergeByName appends item to a list of volumes or volumeMounts if there is
+// no entry with the same name yet
+func mergeByName(list []interface{}, item map[string]interface{}) []interface{} {
+
+	for _, l := range list {
+		if l, ok := l.(map[string]interface{}); ok && l["name"] == item["name"] {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+func setupPodSpecProxy(obj *unstructured.Unstructured, path []string, r *SpecialResourceReconciler) error {
+
+	podSpec, found, err := unstructured.NestedMap(obj.Object, path...)
+	if err != nil || !found {
+		return errs.New("Cannot extract pod spec of " + obj.GetName())
+	}
+
+	trustedCA := runInfo.Proxy.TrustedCA != ""
+	if trustedCA {
+		configMap, err := createTrustedCAConfigMap(obj.GetNamespace(), r)
+		if err != nil {
+			return err
+		}
+		volumes, _, _ := unstructured.NestedSlice(podSpec, "volumes")
+		volumes = mergeByName(volumes, map[string]interface{}{
+			"name": trustedCAVolume,
+			"configMap": map[string]interface{}{
+				"name": configMap,
+				"items": []interface{}{
+					map[string]interface{}{"key": "ca-bundle.crt", "path": "tls-ca-bundle.pem"},
+				},
+			},
+		})
+		podSpec["volumes"] = volumes
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+
+		containers, found, err := unstructured.NestedSlice(podSpec, field)
+		if err != nil {
+			return errs.Wrap(err, "Cannot extract "+field)
+		}
+		if !found {
+			continue
+		}
+
+		for i, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok {
+				log.Info("container", "DEFAULT NOT THE CORRECT TYPE", container)
+				continue
+			}
+
+			env, _, _ := unstructured.NestedSlice(container, "env")
+			container["env"] = mergeEnv(env, proxyEnv())
+
+			if trustedCA {
+				mounts, _, _ := unstructured.NestedSlice(container, "volumeMounts")
+				container["volumeMounts"] = mergeByName(mounts, map[string]interface{}{
+					"name":      trustedCAVolume,
+					"mountPath": trustedCAMountPath,
+					"readOnly":  true,
+				})
+			}
+
+			containers[i] = container
+		}
+
+		podSpec[field] = containers
+	}
+
+	return unstructured.SetNestedMap(obj.Object, podSpec, path...)
+}
+
+// setupBuildConfigProxy sets the env of the build strategy and the proxy
+// used to clone the git source. OpenShift builds trust the cluster CA
+// bundle of the proxy configuration on their own.
+func setupBuildConfigProxy(obj *unstructured.Unstructured) error {
+
+	for _, strategy := range []string{"dockerStrategy", "sourceStrategy", "customStrategy"} {
+
+		if _, found, _ := unstructured.NestedMap(obj.Object, "spec", "strategy", strategy); !found {
+			continue
+		}
+
+		env, _, err := unstructured.NestedSlice(obj.Object, "spec", "strategy", strategy, "env")
+		if err != nil {
+			return errs.Wrap(err, "Cannot extract env of "+strategy)
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, mergeEnv(env, proxyEnv()), "spec", "strategy", strategy, "env"); err != nil {
+			return errs.Wrap(err, "Cannot set env of "+strategy)
+		}
+	}
+
+	if _, found, _ := unstructured.NestedMap(obj.Object, "spec", "source", "git"); !found {
+		return nil
+	}
+
+	for field, value := range map[string]string{
+		"httpProxy":  runInfo.Proxy.HttpProxy,
+		"httpsProxy": runInfo.Proxy.HttpsProxy,
+		"noProxy":    runInfo.Proxy.NoProxy,
+	} {
+		if value == "" {
+			continue
+		}
+		if err := unstructured.SetNestedField(obj.Object, value, "spec", "source", "git", field); err != nil {
+			return errs.Wrap(err, "Cannot set git "+field)
+		}
+	}
+
+	return nil
+}
+
+// createTrustedCAConfigMap creates an empty ConfigMap the trusted CA bundle
+// is injected into. It is never updated, an update would drop the injected
+// bundle until it is injected again.
+func createTrustedCAConfigMap(namespace string, r *SpecialResourceReconciler) (string, error) {
+
+	name := r.specialresource.Name + "-" + trustedCAVolume
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, cm)
+	if err == nil {
+		return name, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", errs.Wrap(err, "Cannot get trusted CA ConfigMap")
+	}
+
+	cm.SetName(name)
+	cm.SetNamespace(namespace)
+	cm.SetLabels(map[string]string{trustedCAInjectLabel: "true"})
+
+	if err := controllerutil.SetControllerReference(&r.specialresource, cm, r.Scheme); err != nil {
+		return "", errs.Wrap(err, "Failed to set controller reference")
+	}
+
+	log.Info("Creating trusted CA ConfigMap", "Name", name)
+	if err := r.Create(context.TODO(), cm); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", errs.Wrap(err, "Cannot create trusted CA ConfigMap")
+	}
+
+	return name, nil
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSetupPodSpecProxy(t *testing.T) {
+
+	runInfo.Proxy = proxyConfiguration{HttpProxy: "http://proxy:3128", HttpsProxy: "http://proxy:3128", NoProxy: ".cluster.local"}
+	defer func() { runInfo.Proxy = proxyConfiguration{} }()
+
+	container := func(name string) interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"env":  []interface{}{map[string]interface{}{"name": "HTTP_PROXY", "value": "stale"}},
+		}
+	}
+
+	ds := &unstructured.Unstructured{}
+	ds.SetKind("DaemonSet")
+	podSpec := map[string]interface{}{
+		"initContainers": []interface{}{container("init")},
+		"containers":     []interface{}{container("driver"), container("sidecar")},
+	}
+	if err := unstructured.SetNestedMap(ds.Object, podSpec, "spec", "template", "spec"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repeated reconciles must not accumulate env entries
+	for i := 0; i < 2; i++ {
+		if err := setupProxy(ds, nil); err != nil {
+			t.Fatalf("setupProxy failed: %v", err)
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(ds.Object, "spec", "template", "spec", field)
+		for _, c := range containers {
+			env, _, _ := unstructured.NestedSlice(c.(map[string]interface{}), "env")
+			if len(env) != 3 {
+				t.Errorf("%s has %d env entries, want 3: %v", c.(map[string]interface{})["name"], len(env), env)
+			}
+			for _, e := range env {
+				e := e.(map[string]interface{})
+				if e["name"] == "HTTP_PROXY" && e["value"] != "http://proxy:3128" {
+					t.Errorf("HTTP_PROXY = %v", e["value"])
+				}
+			}
+		}
+	}
+}
+
+func TestSetupPodSpecProxyTrustedCA(t *testing.T) {
+
+	runInfo.Proxy = proxyConfiguration{HttpsProxy: "http://proxy:3128", TrustedCA: "user-ca-bundle"}
+	defer func() { runInfo.Proxy = proxyConfiguration{} }()
+
+	// The ConfigMap exists already, it is never updated
+	cm := &unstructured.Unstructured{}
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace("simple-kmod")
+	cm.SetName("simple-kmod-" + trustedCAVolume)
+
+	r := &SpecialResourceReconciler{Client: &fakeClient{objects: []*unstructured.Unstructured{cm}}}
+	r.specialresource.Name = "simple-kmod"
+
+	ds := &unstructured.Unstructured{}
+	ds.SetKind("DaemonSet")
+	ds.SetNamespace("simple-kmod")
+	podSpec := map[string]interface{}{
+		"initContainers": []interface{}{map[string]interface{}{"name": "init"}},
+		"containers":     []interface{}{map[string]interface{}{"name": "driver"}},
+	}
+	if err := unstructured.SetNestedMap(ds.Object, podSpec, "spec", "template", "spec"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := setupProxy(ds, r); err != nil {
+			t.Fatalf("setupProxy failed: %v", err)
+		}
+	}
+
+	volumes, _, _ := unstructured.NestedSlice(ds.Object, "spec", "template", "spec", "volumes")
+	if len(volumes) != 1 {
+		t.Fatalf("got %d volumes, want the trusted CA once: %v", len(volumes), volumes)
+	}
+	volume := volumes[0].(map[string]interface{})
+	if name, _, _ := unstructured.NestedString(volume, "configMap", "name"); volume["name"] != trustedCAVolume || name != cm.GetName() {
+		t.Errorf("trusted CA volume = %v", volume)
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(ds.Object, "spec", "template", "spec", field)
+		for _, c := range containers {
+			c := c.(map[string]interface{})
+			mounts, _, _ := unstructured.NestedSlice(c, "volumeMounts")
+			if len(mounts) != 1 {
+				t.Fatalf("%s has %d mounts, want the trusted CA once", c["name"], len(mounts))
+			}
+			mount := mounts[0].(map[string]interface{})
+			if mount["name"] != trustedCAVolume || mount["mountPath"] != "/etc/pki/ca-trust/extracted/pem" || mount["readOnly"] != true {
+				t.Errorf("%s trusted CA mount = %v", c["name"], mount)
+			}
+
+			env, _, _ := unstructured.NestedSlice(c, "env")
+			if len(env) != 3 {
+				t.Errorf("%s has %d env entries, want 3: %v", c["name"], len(env), env)
+			}
+		}
+	}
+}
+
+func TestSetupBuildConfigProxy(t *testing.T) {
+
+	runInfo.Proxy = proxyConfiguration{HttpProxy: "http://proxy:3128", HttpsProxy: "https://proxy:3129", NoProxy: ".cluster.local"}
+	defer func() { runInfo.Proxy = proxyConfiguration{} }()
+
+	bc := &unstructured.Unstructured{}
+	bc.SetKind("BuildConfig")
+	bc.Object["spec"] = map[string]interface{}{
+		"source": map[string]interface{}{
+			"git": map[string]interface{}{"uri": "https://github.com/vendor/kmod.git"},
+		},
+		"strategy": map[string]interface{}{
+			"dockerStrategy": map[string]interface{}{
+				"env": []interface{}{map[string]interface{}{"name": "KVER", "value": "4.18.0"}},
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := setupProxy(bc, nil); err != nil {
+			t.Fatalf("setupProxy failed: %v", err)
+		}
+	}
+
+	env, _, _ := unstructured.NestedSlice(bc.Object, "spec", "strategy", "dockerStrategy", "env")
+	values := map[string]interface{}{}
+	for _, e := range env {
+		e := e.(map[string]interface{})
+		values[e["name"].(string)] = e["value"]
+	}
+	want := map[string]interface{}{
+		"KVER":        "4.18.0",
+		"HTTP_PROXY":  "http://proxy:3128",
+		"HTTPS_PROXY": "https://proxy:3129",
+		"NO_PROXY":    ".cluster.local",
+	}
+	if len(env) != len(want) || !reflect.DeepEqual(values, want) {
+		t.Errorf("dockerStrategy env = %v, want %v", env, want)
+	}
+
+	// Strategies that are not used are not created
+	if _, found, _ := unstructured.NestedMap(bc.Object, "spec", "strategy", "sourceStrategy"); found {
+		t.Errorf("setupProxy created a sourceStrategy")
+	}
+
+	git, _, _ := unstructured.NestedStringMap(bc.Object, "spec", "source", "git")
+	wantGit := map[string]string{
+		"uri":        "https://github.com/vendor/kmod.git",
+		"httpProxy":  "http://proxy:3128",
+		"httpsProxy": "https://proxy:3129",
+		"noProxy":    ".cluster.local",
+	}
+	if !reflect.DeepEqual(git, wantGit) {
+		t.Errorf("source.git = %v, want %v", git, wantGit)
+	}
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	errs "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// waitForAnnotation overrides when an object is ready with an expression
+// of JSONPath comparisons joined by && e.g.
+//
+//	specialresource.openshift.io/wait-for: "status.readyReplicas == spec.replicas"
+//	specialresource.openshift.io/wait-for: "status.conditions[?(@.type=='Available')].status == 'True'"
+//
+// or the for parameter of the wait hook, see legacyHookAnnotations
+//
+//	hook.specialresource.openshift.io/wait: "for=status.readyReplicas == 2"
+//
+// Operands are paths, 'quoted' or "quoted" strings, numbers or booleans, a
+// path that is not set yet is never ready. An empty expression is ready as
+// soon as the object exists.
+const waitForAnnotation = "specialresource.openshift.io/wait-for"
+
+// readinessDefaults are used for kinds waitFor has no callback for
+var readinessDefaults = map[string]string{
+	"Deployment":               "status.observedGeneration >= metadata.generation && status.updatedReplicas == spec.replicas && status.availableReplicas == spec.replicas",
+	"StatefulSet":              "status.observedGeneration >= metadata.generation && status.updatedReplicas == spec.replicas && status.readyReplicas == spec.replicas",
+	"CustomResourceDefinition": "status.conditions[?(@.type=='Established')].status == 'True'",
+	// CSIDrivers have no status, they are ready once registered
+	"CSIDriver": "",
+}
+
+var readinessOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+type readinessOperand struct {
+	path    *jsonpath.JSONPath
+	literal interface{}
+}
+
+// readinessClause is a comparison, or a single operand that has to be true
+type readinessClause struct {
+	left     readinessOperand
+	operator string
+	right    readinessOperand
+}
+
+type readinessExpression []readinessClause
+
+func parseReadinessExpression(expression string) (readinessExpression, error) {
+
+	e := readinessExpression{}
+	if strings.TrimSpace(expression) == "" {
+		return e, nil
+	}
+
+	for _, clause := range splitOutside(expression, "&&") {
+
+		left, operator, right := splitComparison(clause)
+
+		l, err := parseReadinessOperand(left)
+		if err != nil {
+			return nil, errs.Wrap(err, "Cannot parse "+left)
+		}
+		c := readinessClause{left: l, operator: operator}
+
+		if operator != "" {
+			if c.right, err = parseReadinessOperand(right); err != nil {
+				return nil, errs.Wrap(err, "Cannot parse "+right)
+			}
+		}
+
+		e = append(e, c)
+	}
+
+	return e, nil
+}
+
+// splitOutside splits s at sep if sep is not quoted or within brackets
+func splitOutside(s string, sep string) []string {
+
+	parts := []string{}
+	depth := 0
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(' || c == '{':
+			depth++
+		case c == ']' || c == ')' || c == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// splitComparison returns the operands and operator of a clause, the
+// operator is empty if the clause is a single operand
+func splitComparison(clause string) (string, string, string) {
+
+	for _, operator := range readinessOperators {
+		if parts := splitOutside(clause, operator); len(parts) == 2 {
+			return parts[0], operator, parts[1]
+		}
+	}
+
+	return strings.TrimSpace(clause), "", ""
+}
+
+func parseReadinessOperand(operand string) (readinessOperand, error) {
+
+	if operand == "" {
+		return readinessOperand{}, errs.New("Missing operand")
+	}
+
+	if len(operand) >= 2 && (operand[0] == '\'' || operand[0] == '"') && operand[len(operand)-1] == operand[0] {
+		return readinessOperand{literal: operand[1 : len(operand)-1]}, nil
+	}
+	if operand == "true" || operand == "false" {
+		return readinessOperand{literal: operand == "true"}, nil
+	}
+	if f, err := strconv.ParseFloat(operand, 64); err == nil {
+		return readinessOperand{literal: f}, nil
+	}
+
+	// status.x, .status.x and {.status.x} are all the same path
+	path := strings.TrimSuffix(strings.TrimPrefix(operand, "{"), "}")
+	if !strings.HasPrefix(path, ".") {
+		path = "." + path
+	}
+
+	j := jsonpath.New(operand).AllowMissingKeys(true)
+	if err := j.Parse("{" + path + "}"); err != nil {
+		return readinessOperand{}, err
+	}
+
+	return readinessOperand{path: j}, nil
+}
+
+// value returns the literal or the first value at the path, nil if the path
+// is not set
+func (o readinessOperand) value(obj *unstructured.Unstructured) (interface{}, error) {
+
+	if o.path == nil {
+		return o.literal, nil
+	}
+
+	results, err := o.path.FindResults(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		for _, v := range result {
+			if v.IsValid() && v.CanInterface() {
+				return v.Interface(), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (e readinessExpression) ready(obj *unstructured.Unstructured) (bool, error) {
+
+	for _, c := range e {
+
+		left, err := c.left.value(obj)
+		if err != nil {
+			return false, err
+		}
+
+		if c.operator == "" {
+			if b, ok := left.(bool); !ok || !b {
+				return false, nil
+			}
+			continue
+		}
+
+		right, err := c.right.value(obj)
+		if err != nil {
+			return false, err
+		}
+		if left == nil || right == nil {
+			return false, nil
+		}
+
+		ok, err := compare(left, c.operator, right)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// compare compares numbers as numbers and everything else as strings
+func compare(left interface{}, operator string, right interface{}) (bool, error) {
+
+	l, lnum := toFloat(left)
+	r, rnum := toFloat(right)
+
+	if lnum && rnum {
+		switch operator {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	}
+
+	ls, rs := fmt.Sprintf("%v", left), fmt.Sprintf("%v", right)
+	switch operator {
+	case "==":
+		return ls == rs, nil
+	case "!=":
+		return ls != rs, nil
+	}
+
+	return false, errs.New(fmt.Sprintf("Cannot compare %v %s %v, not numbers", left, operator, right))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// readinessCallback returns the expression of obj as statusCallback,
+// false if obj has no expression. The for parameter of the wait hook wins
+// over the wait-for annotation.
+func readinessCallback(obj *unstructured.Unstructured, params map[string]string) (statusCallback, bool, error) {
+
+	expression, found := params["for"]
+	if !found {
+		expression, found = obj.GetAnnotations()[waitForAnnotation]
+	}
+	if !found {
+		if _, builtin := waitFor[obj.GetKind()]; builtin {
+			return nil, false, nil
+		}
+		if expression, found = readinessDefaults[obj.GetKind()]; !found {
+			return nil, false, nil
+		}
+	}
+
+	e, err := parseReadinessExpression(expression)
+	if err != nil {
+		return nil, false, errs.Wrap(err, "Cannot parse wait-for of "+obj.GetKind()+" "+obj.GetName())
+	}
+
+	return func(obj *unstructured.Unstructured) bool {
+		ready, err := e.ready(obj)
+		if err != nil {
+			log.Info("Cannot evaluate wait-for", "Kind", obj.GetKind(), "Name", obj.GetName(), "error", err.Error())
+		}
+		return ready
+	}, true, nil
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newDeployment(replicas int64, available int64, conditionStatus string) *unstructured.Unstructured {
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"spec":     map[string]interface{}{"replicas": replicas},
+		"status": map[string]interface{}{
+			"observedGeneration": int64(2),
+			"updatedReplicas":    replicas,
+			"availableReplicas":  available,
+			"readyReplicas":      available,
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Progressing", "status": "True"},
+				map[string]interface{}{"type": "Available", "status": conditionStatus},
+			},
+		},
+	}}
+	obj.SetKind("Deployment")
+	obj.SetName("plugin")
+
+	return obj
+}
+
+func TestReadinessExpression(t *testing.T) {
+
+	tests := []struct {
+		expression string
+		obj        *unstructured.Unstructured
+		ready      bool
+	}{
+		{"status.readyReplicas == spec.replicas", newDeployment(3, 3, "True"), true},
+		{"status.readyReplicas == spec.replicas", newDeployment(3, 2, "True"), false},
+		{"status.conditions[?(@.type=='Available')].status == 'True'", newDeployment(3, 3, "True"), true},
+		{"status.conditions[?(@.type=='Available')].status == 'True'", newDeployment(3, 3, "False"), false},
+		{`{.status.conditions[?(@.type=="Available")].status} != "False" && .status.availableReplicas >= 2`, newDeployment(3, 2, "True"), true},
+		{"status.availableReplicas > 2 && status.readyReplicas < 4", newDeployment(3, 3, "True"), true},
+		{"status.availableReplicas <= 1", newDeployment(3, 3, "True"), false},
+		{"status.numberReady == 3", newDeployment(3, 3, "True"), false},
+		{"", newDeployment(3, 0, "False"), true},
+		{readinessDefaults["Deployment"], newDeployment(3, 3, "True"), true},
+		{readinessDefaults["Deployment"], newDeployment(3, 1, "True"), false},
+	}
+
+	for _, test := range tests {
+		e, err := parseReadinessExpression(test.expression)
+		if err != nil {
+			t.Errorf("parseReadinessExpression(%s) failed: %v", test.expression, err)
+			continue
+		}
+		ready, err := e.ready(test.obj)
+		if err != nil {
+			t.Errorf("%s failed: %v", test.expression, err)
+			continue
+		}
+		if ready != test.ready {
+			t.Errorf("%s = %v, want %v", test.expression, ready, test.ready)
+		}
+	}
+}
+
+func TestReadinessExpressionErrors(t *testing.T) {
+
+	if _, err := parseReadinessExpression("status.readyReplicas == "); err == nil {
+		t.Errorf("parseReadinessExpression should fail for a missing operand")
+	}
+	if _, err := parseReadinessExpression("status.conditions[?(@.type=='Available'"); err == nil {
+		t.Errorf("parseReadinessExpression should fail for an invalid path")
+	}
+
+	e, err := parseReadinessExpression("status.conditions[?(@.type=='Available')].status > 'True'")
+	if err != nil {
+		t.Fatalf("parseReadinessExpression failed: %v", err)
+	}
+	if _, err := e.ready(newDeployment(1, 1, "True")); err == nil {
+		t.Errorf("ready should fail ordering strings")
+	}
+}
+
+func TestReadinessCallback(t *testing.T) {
+
+	obj := newDeployment(1, 1, "True")
+	if _, found, err := readinessCallback(obj, nil); err != nil || !found {
+		t.Errorf("Deployment should have a default readiness expression: %v", err)
+	}
+
+	obj.SetKind("DaemonSet")
+	if _, found, _ := readinessCallback(obj, nil); found {
+		t.Errorf("DaemonSet should use its builtin callback")
+	}
+
+	obj.SetAnnotations(map[string]string{waitForAnnotation: "status.readyReplicas == 2"})
+	callback, found, err := readinessCallback(obj, nil)
+	if err != nil || !found {
+		t.Fatalf("wait-for should override the builtin callback: %v", err)
+	}
+	if callback(obj) {
+		t.Errorf("wait-for expression should not be ready")
+	}
+
+	obj.SetKind("ConfigMap")
+	obj.SetAnnotations(nil)
+	if _, found, _ := readinessCallback(obj, nil); found {
+		t.Errorf("ConfigMap should not have a readiness expression")
+	}
+}
+
+func TestReadinessCallbackHookParams(t *testing.T) {
+
+	obj := newDeployment(1, 1, "True")
+	obj.SetAnnotations(map[string]string{"hook.specialresource.openshift.io/wait": "for=status.readyReplicas == 2"})
+
+	configured, err := objectHooks(obj)
+	if err != nil {
+		t.Fatalf("objectHooks failed: %v", err)
+	}
+	params, found := configured["wait"]
+	if !found || params["for"] != "status.readyReplicas == 2" {
+		t.Fatalf("wait hook params = %v", configured)
+	}
+
+	callback, found, err := readinessCallback(obj, params)
+	if err != nil || !found {
+		t.Fatalf("for should override the default expression: %v", err)
+	}
+	if callback(obj) {
+		t.Errorf("for expression should not be ready with 1 ready replica")
+	}
+
+	// The legacy annotation is passed as for parameter as well
+	obj.SetAnnotations(map[string]string{waitForAnnotation: "status.readyReplicas == 1"})
+	if configured, err = objectHooks(obj); err != nil {
+		t.Fatalf("objectHooks failed: %v", err)
+	}
+	if callback, found, err = readinessCallback(obj, configured["wait"]); err != nil || !found || !callback(obj) {
+		t.Errorf("wait-for expression should be ready: %v", err)
+	}
+}
@@ -0,0 +1,501 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// recipeFile declares the version and states of a recipe next to its
+// manifests e.g.
+//
+//	version: 1.0.0
+//	states:
+//	- name: driver
+//	  manifest: 1000-state-driver.yaml
+//	- name: monitoring
+//	  manifest: 6000-state-device-monitoring.yaml
+//	  dependsOn: [driver]
+//	  parallel: true
+//	  ready:
+//	  - apiVersion: apps/v1
+//	    kind: DaemonSet
+//	    name: "{{.SpecialResource.Name}}-monitoring"
+//	    for: "{.status.numberReady} == {.status.desiredNumberScheduled}"
+//
+// It is rendered like the manifests. Without it every manifest is a state
+// that depends on all manifests sorted before it.
+const recipeFile = "recipe.yaml"
+
+const (
+	statePhaseBlocked = "Blocked"
+	statePhaseApplied = "Applied"
+	statePhaseReady   = "Ready"
+	statePhaseSkipped = "Skipped"
+	statePhaseFailed  = "Failed"
+)
+
+type recipe struct {
+	// Version selects the recipe with spec.recipeVersion, see recipesRoot
+	Version string `json:"version,omitempty"`
+	// Configuration is the schema of spec.configuration, see configSchema
+	Configuration *configSchema `json:"configuration,omitempty"`
+	States        []recipeState `json:"states,omitempty"`
+}
+
+// recipeState is applied once the states it depends on are Ready. A state
+// that is not parallel waits for all states before it and all states after
+// it wait for it. Parallel states run concurrently with the states they do
+// not depend on.
+type recipeState struct {
+	Name string `json:"name"`
+	// Manifest is the key in the recipe ConfigMap, the name by default
+	Manifest  string   `json:"manifest,omitempty"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Parallel  bool     `json:"parallel,omitempty"`
+	// IncludeIf is a template condition, the state is skipped and its
+	// objects pruned if it is false, see includeIfAnnotation
+	IncludeIf string            `json:"includeIf,omitempty"`
+	Ready     []recipeReadiness `json:"ready,omitempty"`
+}
+
+// recipeReadiness is an object that has to be ready for the state to be
+// Ready, For is a wait-for expression, the default of the kind otherwise
+type recipeReadiness struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	For        string `json:"for,omitempty"`
+}
+
+// stateReadyCallbacks are used if a kind has no readiness expression
+var stateReadyCallbacks = map[string]statusCallback{
+	"DaemonSet": waitForDaemonSetCallback,
+	"Job":       waitForJobCallback,
+}
+
+// getRecipe parses the recipe file of manifests, without a recipe file or
+// states in it the sorted manifests are sequential states
+func getRecipe(manifests map[string]interface{}) (recipe, error) {
+
+	rcp := recipe{}
+
+	if spec, found := manifests[recipeFile]; found {
+		yamlSpec := []byte(spec.(string))
+		if err := templateRuntimeInformation(&yamlSpec, runInfo); err != nil {
+			return rcp, errs.Wrap(err, "Cannot render "+recipeFile)
+		}
+		if err := yaml.Unmarshal(yamlSpec, &rcp); err != nil {
+			return rcp, errs.Wrap(err, "Cannot parse "+recipeFile)
+		}
+	}
+
+	if len(rcp.States) == 0 {
+		keys := make([]string, 0, len(manifests))
+		for key := range manifests {
+			if key != recipeFile {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			rcp.States = append(rcp.States, recipeState{Name: key, Manifest: key})
+		}
+		return rcp, nil
+	}
+
+	for i := range rcp.States {
+		if rcp.States[i].Manifest == "" {
+			rcp.States[i].Manifest = rcp.States[i].Name
+		}
+		if _, found := manifests[rcp.States[i].Manifest]; !found {
+			return rcp, errs.New("Manifest " + rcp.States[i].Manifest + " of state " + rcp.States[i].Name + " not found")
+		}
+	}
+
+	return rcp, nil
+}
+
+// order returns the states sorted topologically, states keep the order of
+// the recipe if they do not depend on each other
+func (rcp recipe) order() ([]recipeState, error) {
+
+	index := map[string]int{}
+	for i, state := range rcp.States {
+		if state.Name == "" {
+			return nil, errs.New("State without name in " + recipeFile)
+		}
+		if _, found := index[state.Name]; found {
+			return nil, errs.New("Duplicate state " + state.Name + " in " + recipeFile)
+		}
+		index[state.Name] = i
+	}
+
+	for _, state := range rcp.States {
+		for _, dep := range state.DependsOn {
+			if _, found := index[dep]; !found {
+				return nil, errs.New("State " + state.Name + " depends on unknown state " + dep)
+			}
+		}
+	}
+
+	ordered := []recipeState{}
+	done := map[string]bool{}
+
+	for len(ordered) < len(rcp.States) {
+		progress := false
+		for _, state := range rcp.States {
+			if done[state.Name] || !dependenciesIn(state, done) {
+				continue
+			}
+			ordered = append(ordered, state)
+			done[state.Name] = true
+			progress = true
+			// Restart to keep the order of the recipe
+			break
+		}
+		if !progress {
+			cyclic := []string{}
+			for _, state := range rcp.States {
+				if !done[state.Name] {
+					cyclic = append(cyclic, state.Name)
+				}
+			}
+			return nil, errs.New("Cyclic dependencies between states " + strings.Join(cyclic, ","))
+		}
+	}
+
+	return ordered, nil
+}
+
+func dependenciesIn(state recipeState, states map[string]bool) bool {
+	for _, dep := range state.DependsOn {
+		if !states[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// stateReady checks the readiness criteria of the state once
+func stateReady(state recipeState, r *SpecialResourceReconciler) (bool, string, error) {
+
+	for _, criterion := range state.Ready {
+
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(criterion.APIVersion)
+		obj.SetKind(criterion.Kind)
+
+		namespacedName := types.NamespacedName{Name: criterion.Name}
+		if resourceNamespaced(criterion.Kind) {
+			namespacedName.Namespace = r.specialresource.Spec.Namespace
+		}
+
+		object := criterion.Kind + "/" + criterion.Name
+
+		err := r.Get(context.TODO(), namespacedName, obj)
+		if apierrors.IsNotFound(err) {
+			return false, object + " not found", nil
+		}
+		if err != nil {
+			return false, "", errs.Wrap(err, "Cannot get "+object)
+		}
+
+		params := map[string]string{}
+		if criterion.For != "" {
+			params["for"] = criterion.For
+		}
+
+		callback, found, err := readinessCallback(obj, params)
+		if err != nil {
+			return false, "", err
+		}
+		if !found {
+			if callback, found = stateReadyCallbacks[criterion.Kind]; !found {
+				// Exists is all we know about the kind
+				continue
+			}
+		}
+		if !callback(obj) {
+			return false, object + " not ready", nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// reconcileRecipeStates applies the states whose dependencies are Ready,
+// states that are blocked or fail do not keep parallel states from
+// progressing. The states that can run are run concurrently, once they are
+// done the states that depend on them are run.
+func reconcileRecipeStates(rcp recipe, manifests map[string]interface{}, r *SpecialResourceReconciler) error {
+
+	ordered, err := rcp.order()
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]srov1beta1.SpecialResourceStateStatus, len(ordered))
+	failures := make([]error, len(ordered))
+	executed := map[string]bool{}
+	ready := map[string]bool{}
+
+	for i, state := range ordered {
+		statuses[i] = srov1beta1.SpecialResourceStateStatus{
+			Name:      state.Name,
+			Partition: runInfo.Partition,
+			DependsOn: state.DependsOn,
+			Parallel:  state.Parallel,
+		}
+	}
+
+	for {
+		wave := []int{}
+		for i, state := range ordered {
+			if !executed[state.Name] && stateBlocked(ordered, i, ready) == "" {
+				wave = append(wave, i)
+			}
+		}
+		if len(wave) == 0 {
+			break
+		}
+
+		results := make([]bool, len(wave))
+		runStates(len(wave), func(w int) {
+			i := wave[w]
+			log.Info("Executing", "State", ordered[i].Name)
+			results[w], failures[i] = reconcileRecipeState(ordered[i], manifests, &statuses[i], r)
+		})
+
+		for w, i := range wave {
+			executed[ordered[i].Name] = true
+			ready[ordered[i].Name] = results[w]
+		}
+	}
+
+	var failure error
+	pending := []string{}
+
+	for i, state := range ordered {
+		if !executed[state.Name] {
+			statuses[i].Phase = statePhaseBlocked
+			statuses[i].Message = stateBlocked(ordered, i, ready)
+		}
+		if failures[i] != nil && failure == nil {
+			failure = errs.Wrap(failures[i], "State "+state.Name)
+		}
+		if !ready[state.Name] {
+			pending = append(pending, state.Name)
+		}
+		statuses[i].LastTransitionTime = stateTransitionTime(previousStateStatus(state.Name, r), statuses[i].Phase)
+	}
+
+	updateStateStatus(statuses, r)
+
+	if failure != nil {
+		return failure
+	}
+	if len(pending) > 0 {
+		return errs.New("Not yet done, waiting for states " + strings.Join(pending, ","))
+	}
+
+	return nil
+}
+
+// stateBlocked returns why the state at i of ordered cannot run (yet),
+// empty if it can. States wait for the first state before them that is not
+// parallel and not Ready.
+func stateBlocked(ordered []recipeState, i int, ready map[string]bool) string {
+
+	state := ordered[i]
+
+	for _, previous := range ordered[:i] {
+		if !previous.Parallel && !ready[previous.Name] {
+			return "Waiting for state " + previous.Name
+		}
+	}
+	if !dependenciesIn(state, ready) {
+		return "Waiting for states " + strings.Join(state.DependsOn, ",")
+	}
+	if !state.Parallel && !previousReady(ordered[:i], ready) {
+		return "Waiting for all previous states"
+	}
+
+	return ""
+}
+
+func previousReady(states []recipeState, ready map[string]bool) bool {
+	for _, state := range states {
+		if !ready[state.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileRecipeState applies a state, snapshots it once it is Ready and
+// rolls it back to the snapshot once it failed. It is true if the states
+// depending on state can go on i.e. it is Ready, Skipped or its snapshot
+// is Ready.
+func reconcileRecipeState(state recipeState, manifests map[string]interface{}, status *srov1beta1.SpecialResourceStateStatus, r *SpecialResourceReconciler) (bool, error) {
+
+	namespacedYAML := []byte(manifests[state.Manifest].(string))
+
+	include, err := evaluateIncludeIf(state.IncludeIf)
+	if err != nil {
+		status.Phase, status.Message = statePhaseFailed, err.Error()
+		return false, err
+	}
+	if !include {
+		log.Info("Skipping", "State", state.Name, "IncludeIf", state.IncludeIf)
+		if err := pruneFromYAML(namespacedYAML, r, r.specialresource.Spec.Namespace); err != nil {
+			status.Phase, status.Message = statePhaseFailed, err.Error()
+			return false, errs.Wrap(err, "Failed to prune resources")
+		}
+		updateSkippedStatus("State/"+state.Name, state.IncludeIf, r)
+		// States depending on a skipped state do not wait for it
+		status.Phase, status.Message = statePhaseSkipped, state.IncludeIf
+		return true, nil
+	}
+	updateSkippedStatus("State/"+state.Name, "", r)
+
+	hash, err := renderedHash(namespacedYAML, r.specialresource.Spec.Namespace)
+	if err != nil {
+		status.Phase, status.Message = statePhaseFailed, err.Error()
+		return false, err
+	}
+
+	// The snapshot stays until the manifests render differently e.g. with
+	// another recipe version or driver image
+	previous := previousStateStatus(state.Name, r)
+	if previous != nil && previous.RolledBack == hash {
+		return rollbackRecipeState(state, hash, previous.Message, status, r)
+	}
+
+	renderedObjects = []*unstructured.Unstructured{}
+
+	var failure error
+	if err := createFromYAML(namespacedYAML, r, r.specialresource.Spec.Namespace); err != nil {
+		status.Phase, status.Message = statePhaseFailed, err.Error()
+		failure = errs.Wrap(err, "Failed to create resources")
+	} else {
+		isReady, message, err := stateReady(state, r)
+		switch {
+		case err != nil:
+			status.Phase, status.Message = statePhaseFailed, err.Error()
+			failure = err
+		case !isReady:
+			status.Phase, status.Message = statePhaseApplied, message
+		default:
+			status.Phase, status.Message = statePhaseReady, ""
+			if err := writeSnapshot(state.Name, hash, renderedObjects, r); err != nil {
+				log.Error(err, "Cannot snapshot", "State", state.Name)
+			}
+			return true, nil
+		}
+	}
+
+	reason := rollbackReason(previous, renderedObjects, r)
+	if reason == "" {
+		return false, failure
+	}
+
+	return rollbackRecipeState(state, hash, reason, status, r)
+}
+
+// rollbackRecipeState applies the snapshot of a failed state, it is true
+// once the restored objects are Ready
+func rollbackRecipeState(state recipeState, hash string, reason string, status *srov1beta1.SpecialResourceStateStatus, r *SpecialResourceReconciler) (bool, error) {
+
+	objects, found, err := readSnapshot(state.Name, r)
+	if err != nil {
+		status.Phase, status.Message = statePhaseFailed, err.Error()
+		return false, err
+	}
+	if !found {
+		status.Phase, status.Message = statePhaseFailed, reason+", no snapshot to roll back to"
+		return false, errs.New(status.Message)
+	}
+
+	log.Info("Rolling back", "State", state.Name, "Reason", reason)
+	status.Phase, status.Message, status.RolledBack = statePhaseRolledBack, reason, hash
+	if err := applySnapshot(objects, r); err != nil {
+		return false, errs.Wrap(err, "Cannot roll back")
+	}
+
+	// Snapshots are applied without waiting, dependents wait for them
+	isReady, message, err := snapshotReady(objects, r)
+	if err == nil && isReady {
+		isReady, message, err = stateReady(state, r)
+	}
+	if err != nil {
+		return false, errs.Wrap(err, "Cannot check rolled back state")
+	}
+	if !isReady {
+		log.Info("Rolled back state not ready", "State", state.Name, "Reason", message)
+	}
+
+	return isReady, nil
+}
+
+// snapshotReady checks the restored objects of a snapshot once, kinds
+// without a readiness expression or callback are ready once they exist
+func snapshotReady(objects []*unstructured.Unstructured, r *SpecialResourceReconciler) (bool, string, error) {
+
+	for _, obj := range objects {
+
+		found := &unstructured.Unstructured{}
+		found.SetAPIVersion(obj.GetAPIVersion())
+		found.SetKind(obj.GetKind())
+
+		object := obj.GetKind() + "/" + obj.GetName()
+
+		err := r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found)
+		if apierrors.IsNotFound(err) {
+			return false, object + " not found", nil
+		}
+		if err != nil {
+			return false, "", errs.Wrap(err, "Cannot get "+object)
+		}
+
+		callback, ok, err := readinessCallback(found, nil)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			if callback, ok = stateReadyCallbacks[obj.GetKind()]; !ok {
+				continue
+			}
+		}
+		if !callback(found) {
+			return false, object + " not ready", nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// updateStateStatus replaces the states of the partition we are reconciling
+func updateStateStatus(states []srov1beta1.SpecialResourceStateStatus, r *SpecialResourceReconciler) {
+
+	statuses := []srov1beta1.SpecialResourceStateStatus{}
+	for _, s := range r.specialresource.Status.States {
+		if s.Partition != runInfo.Partition {
+			statuses = append(statuses, s)
+		}
+	}
+	r.specialresource.Status.States = append(statuses, states...)
+	setDegradedCondition(r)
+
+	if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+		log.Error(err, "Failed to update SpecialResource state status")
+	}
+}
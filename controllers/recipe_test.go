@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func stateNames(states []recipeState) []string {
+	names := []string{}
+	for _, state := range states {
+		names = append(names, state.Name)
+	}
+	return names
+}
+
+func TestGetRecipeWithoutRecipeFile(t *testing.T) {
+
+	manifests := map[string]interface{}{
+		"1000-driver.yaml": "",
+		"0000-build.yaml":  "",
+	}
+
+	rcp, err := getRecipe(manifests)
+	if err != nil {
+		t.Fatalf("getRecipe: %v", err)
+	}
+
+	ordered, err := rcp.order()
+	if err != nil {
+		t.Fatalf("order: %v", err)
+	}
+	want := []string{"0000-build.yaml", "1000-driver.yaml"}
+	if got := stateNames(ordered); !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+	for _, state := range ordered {
+		if state.Parallel {
+			t.Errorf("state %s of a recipe without %s should be sequential", state.Name, recipeFile)
+		}
+	}
+}
+
+func TestGetRecipe(t *testing.T) {
+
+	manifests := map[string]interface{}{
+		"0000-build.yaml": "",
+		"driver":          "",
+		recipeFile: `states:
+- name: build
+  manifest: 0000-build.yaml
+- name: driver
+  dependsOn: [build]
+  ready:
+  - apiVersion: apps/v1
+    kind: DaemonSet
+    name: "{{.SpecialResource.Name}}-driver"
+`,
+	}
+
+	ri := runInfo
+	defer func() { runInfo = ri }()
+	runInfo.SpecialResource.Name = "simple-kmod"
+
+	rcp, err := getRecipe(manifests)
+	if err != nil {
+		t.Fatalf("getRecipe: %v", err)
+	}
+	if len(rcp.States) != 2 || rcp.States[1].Manifest != "driver" {
+		t.Fatalf("states = %+v", rcp.States)
+	}
+	if name := rcp.States[1].Ready[0].Name; name != "simple-kmod-driver" {
+		t.Errorf("ready object %s, want the rendered name simple-kmod-driver", name)
+	}
+
+	manifests[recipeFile] = "states:\n- name: missing\n"
+	if _, err := getRecipe(manifests); err == nil {
+		t.Errorf("state without manifest should fail")
+	}
+}
+
+func TestRecipeOrder(t *testing.T) {
+
+	rcp := recipe{States: []recipeState{
+		{Name: "grafana", DependsOn: []string{"monitoring"}},
+		{Name: "driver"},
+		{Name: "monitoring", DependsOn: []string{"driver"}},
+		{Name: "discovery", DependsOn: []string{"driver"}},
+	}}
+
+	ordered, err := rcp.order()
+	if err != nil {
+		t.Fatalf("order: %v", err)
+	}
+	want := []string{"driver", "monitoring", "grafana", "discovery"}
+	if got := stateNames(ordered); !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+
+	rcp.States[1].DependsOn = []string{"grafana"}
+	if _, err := rcp.order(); err == nil {
+		t.Errorf("cyclic dependencies should fail")
+	}
+
+	rcp.States[1].DependsOn = []string{"unknown"}
+	if _, err := rcp.order(); err == nil {
+		t.Errorf("unknown dependency should fail")
+	}
+
+	rcp.States[1] = recipeState{Name: "grafana"}
+	if _, err := rcp.order(); err == nil {
+		t.Errorf("duplicate state should fail")
+	}
+}
+
+func TestStateBlocked(t *testing.T) {
+
+	ordered := []recipeState{
+		{Name: "driver"},
+		{Name: "device-plugin", DependsOn: []string{"driver"}},
+		{Name: "monitoring", DependsOn: []string{"device-plugin"}, Parallel: true},
+		{Name: "grafana", DependsOn: []string{"monitoring"}, Parallel: true},
+		{Name: "discovery", DependsOn: []string{"monitoring"}, Parallel: true},
+		{Name: "validation"},
+	}
+
+	runnable := func(ready map[string]bool) []string {
+		names := []string{}
+		for i, state := range ordered {
+			if !ready[state.Name] && stateBlocked(ordered, i, ready) == "" {
+				names = append(names, state.Name)
+			}
+		}
+		return names
+	}
+
+	tests := []struct {
+		ready map[string]bool
+		want  []string
+	}{
+		{map[string]bool{}, []string{"driver"}},
+		{map[string]bool{"driver": true}, []string{"device-plugin"}},
+		{map[string]bool{"driver": true, "device-plugin": true}, []string{"monitoring"}},
+		// Parallel states that only depend on Ready states run together
+		{map[string]bool{"driver": true, "device-plugin": true, "monitoring": true}, []string{"grafana", "discovery"}},
+		{map[string]bool{"driver": true, "device-plugin": true, "monitoring": true, "grafana": true}, []string{"discovery"}},
+		{map[string]bool{"driver": true, "device-plugin": true, "monitoring": true, "grafana": true, "discovery": true}, []string{"validation"}},
+	}
+
+	for _, tt := range tests {
+		if got := runnable(tt.ready); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("runnable states with %v = %v, want %v", tt.ready, got, tt.want)
+		}
+	}
+
+	if message := stateBlocked(ordered, 4, map[string]bool{"driver": true}); message != "Waiting for state device-plugin" {
+		t.Errorf("stateBlocked = %s, want the first state that is not parallel", message)
+	}
+}
+
+func TestNvidiaGPURecipe(t *testing.T) {
+
+	spec, err := ioutil.ReadFile("../config/recipes/nvidia-gpu/manifests/" + recipeFile)
+	if err != nil {
+		t.Fatalf("Cannot read recipe: %v", err)
+	}
+
+	manifests := map[string]interface{}{recipeFile: string(spec)}
+	for _, asset := range getAssetsFrom("../config/recipes/nvidia-gpu/manifests") {
+		manifests[asset.name] = string(asset.content)
+	}
+
+	rcp, err := getRecipe(manifests)
+	if err != nil {
+		t.Fatalf("getRecipe: %v", err)
+	}
+	if _, err := rcp.order(); err != nil {
+		t.Errorf("order: %v", err)
+	}
+	if len(rcp.States) != len(manifests)-1 {
+		t.Errorf("%d states for %d manifests", len(rcp.States), len(manifests)-1)
+	}
+}
+
+func TestGetRecipeWithoutStates(t *testing.T) {
+
+	manifests := map[string]interface{}{
+		"1000-driver.yaml": "",
+		"0000-build.yaml":  "",
+		recipeFile:         "version: 1.0.0\nconfiguration:\n  required: [KMOD_NAMES]\n",
+	}
+
+	rcp, err := getRecipe(manifests)
+	if err != nil {
+		t.Fatalf("getRecipe: %v", err)
+	}
+	if got := stateNames(rcp.States); !reflect.DeepEqual(got, []string{"0000-build.yaml", "1000-driver.yaml"}) {
+		t.Errorf("states = %v", got)
+	}
+	if rcp.Configuration == nil || rcp.Configuration.Required[0] != "KMOD_NAMES" {
+		t.Errorf("configuration = %+v", rcp.Configuration)
+	}
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	errs "github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// recipesRoot is where recipes are shipped, a recipe has its latest version
+// in <name>/manifests and may ship older versions side by side in
+// <name>/versions/<version>/manifests. The version of each is the version
+// of its recipe file.
+var recipesRoot = "/opt/sro/recipes"
+
+// readRecipeVersion returns the version of the recipe file in manifests,
+// empty if there is no recipe file or it has no version
+func readRecipeVersion(manifests string) (string, error) {
+
+	spec, err := ioutil.ReadFile(filepath.Join(manifests, recipeFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot read "+recipeFile)
+	}
+
+	versioned := struct {
+		Version string `json:"version"`
+	}{}
+	if err := yaml.Unmarshal(spec, &versioned); err != nil {
+		return "", errs.Wrap(err, "Cannot parse version of "+filepath.Join(manifests, recipeFile))
+	}
+
+	return versioned.Version, nil
+}
+
+// recipeVersions returns the manifests directory of every version of the
+// recipe in root, the latest manifests win if a version is shipped twice.
+// Versions are dotted numbers e.g. 1.2.0.
+func recipeVersions(root string) (map[string]string, error) {
+
+	versions := map[string]string{}
+
+	dirs, err := ioutil.ReadDir(filepath.Join(root, "versions"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errs.Wrap(err, "Cannot read recipe versions")
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		manifests := filepath.Join(root, "versions", dir.Name(), "manifests")
+		version, err := readRecipeVersion(manifests)
+		if err != nil {
+			return nil, err
+		}
+		if version == "" {
+			version = dir.Name()
+		}
+		if _, err := compareVersions(version, "0"); err != nil {
+			return nil, errs.Wrap(err, "Invalid recipe version in "+manifests)
+		}
+		versions[version] = manifests
+	}
+
+	manifests := filepath.Join(root, "manifests")
+	version, err := readRecipeVersion(manifests)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" {
+		if _, err := compareVersions(version, "0"); err != nil {
+			return nil, errs.Wrap(err, "Invalid recipe version in "+manifests)
+		}
+		versions[version] = manifests
+	}
+
+	return versions, nil
+}
+
+// sortVersions sorts valid versions ascending
+func sortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		c, _ := compareVersions(versions[i], versions[j])
+		return c < 0
+	})
+}
+
+// versionConstraintOperators are ordered so that no operator is a prefix
+// of a following one
+var versionConstraintOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// matchesVersionConstraint checks version against a comma separated list
+// of comparisons e.g. ">=1.1.0,<2.0.0", a version without operator is =
+func matchesVersionConstraint(version string, constraint string) (bool, error) {
+
+	for _, clause := range strings.Split(constraint, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			return false, errs.New("Empty clause in recipe version constraint " + constraint)
+		}
+
+		operator := "="
+		for _, op := range versionConstraintOperators {
+			if strings.HasPrefix(clause, op) {
+				operator = op
+				clause = strings.TrimSpace(strings.TrimPrefix(clause, op))
+				break
+			}
+		}
+
+		c, err := compareVersions(version, clause)
+		if err != nil {
+			return false, errs.Wrap(err, "Invalid recipe version constraint "+constraint)
+		}
+		matched := map[string]bool{
+			"=":  c == 0,
+			"!=": c != 0,
+			">":  c > 0,
+			">=": c >= 0,
+			"<":  c < 0,
+			"<=": c <= 0,
+		}[operator]
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// selectRecipeVersion returns the version to render, the current version
+// as long as it is available and satisfies the constraint, the latest
+// version that does otherwise. Upgrades have to be asked for by changing
+// the constraint.
+func selectRecipeVersion(available []string, constraint string, current string) (string, error) {
+
+	candidates := []string{}
+	for _, version := range available {
+		matched := true
+		if constraint != "" {
+			var err error
+			if matched, err = matchesVersionConstraint(version, constraint); err != nil {
+				return "", err
+			}
+		}
+		if matched {
+			candidates = append(candidates, version)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", errs.New("No recipe version satisfies " + constraint + ", available " + strings.Join(available, ","))
+	}
+
+	for _, version := range candidates {
+		if version == current {
+			return current, nil
+		}
+	}
+
+	sortVersions(candidates)
+	return candidates[len(candidates)-1], nil
+}
+
+// getRecipeManifests returns the manifests of the recipe version of the
+// SpecialResource and records the version in status
+func getRecipeManifests(r *SpecialResourceReconciler) (string, error) {
+
+	root := filepath.Join(recipesRoot, r.specialresource.Name)
+
+	versions, err := recipeVersions(root)
+	if err != nil {
+		return "", err
+	}
+
+	// Recipes without versions are always rendered from the latest manifests
+	if len(versions) == 0 {
+		if r.specialresource.Spec.RecipeVersion != "" {
+			return "", errs.New("Recipe " + r.specialresource.Name + " has no versions to select " + r.specialresource.Spec.RecipeVersion)
+		}
+		return filepath.Join(root, "manifests"), nil
+	}
+
+	available := []string{}
+	for version := range versions {
+		available = append(available, version)
+	}
+	sortVersions(available)
+
+	version, err := selectRecipeVersion(available, r.specialresource.Spec.RecipeVersion, r.specialresource.Status.RecipeVersion)
+	if err != nil {
+		return "", err
+	}
+
+	if version != r.specialresource.Status.RecipeVersion {
+		log.Info("Switching recipe version", "From", r.specialresource.Status.RecipeVersion, "To", version)
+	}
+
+	if version != r.specialresource.Status.RecipeVersion || !reflect.DeepEqual(available, r.specialresource.Status.AvailableRecipeVersions) {
+		r.specialresource.Status.RecipeVersion = version
+		r.specialresource.Status.AvailableRecipeVersions = available
+		if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+			log.Error(err, "Failed to update SpecialResource recipe version")
+		}
+	}
+
+	return versions[version], nil
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeRecipe(t *testing.T, dir string, version string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, recipeFile), []byte("version: "+version+"\nstates: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecipeVersions(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if versions, err := recipeVersions(root); err != nil || len(versions) != 0 {
+		t.Errorf("unversioned recipe = %v, %v", versions, err)
+	}
+
+	writeRecipe(t, filepath.Join(root, "manifests"), "1.10.0")
+	writeRecipe(t, filepath.Join(root, "versions", "1.9.0", "manifests"), "1.9.0")
+	if err := os.MkdirAll(filepath.Join(root, "versions", "1.2.0", "manifests"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := recipeVersions(root)
+	if err != nil {
+		t.Fatalf("recipeVersions: %v", err)
+	}
+	want := map[string]string{
+		"1.10.0": filepath.Join(root, "manifests"),
+		"1.9.0":  filepath.Join(root, "versions", "1.9.0", "manifests"),
+		"1.2.0":  filepath.Join(root, "versions", "1.2.0", "manifests"),
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("recipeVersions = %v, want %v", versions, want)
+	}
+
+	writeRecipe(t, filepath.Join(root, "manifests"), "latest")
+	if _, err := recipeVersions(root); err == nil {
+		t.Errorf("non numeric version should fail")
+	}
+}
+
+func TestSelectRecipeVersion(t *testing.T) {
+
+	available := []string{"1.2.0", "1.9.0", "1.10.0", "2.0.0"}
+
+	tests := []struct {
+		constraint string
+		current    string
+		want       string
+	}{
+		// New SpecialResources get the latest version
+		{"", "", "2.0.0"},
+		// Operator upgrades do not upgrade the recipe
+		{"", "1.9.0", "1.9.0"},
+		// Unless the current version is not shipped anymore
+		{"", "1.0.0", "2.0.0"},
+		{"<2.0.0", "", "1.10.0"},
+		{">=1.9.0, <2", "1.9.0", "1.9.0"},
+		{">1.9.0,<2", "1.9.0", "1.10.0"},
+		// Rollback by pinning
+		{"1.2.0", "2.0.0", "1.2.0"},
+		{"=1.2", "", "1.2.0"},
+		{"!=2.0.0", "2.0.0", "1.10.0"},
+	}
+
+	for _, tt := range tests {
+		got, err := selectRecipeVersion(available, tt.constraint, tt.current)
+		if err != nil {
+			t.Errorf("selectRecipeVersion(%q, %q): %v", tt.constraint, tt.current, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("selectRecipeVersion(%q, %q) = %s, want %s", tt.constraint, tt.current, got, tt.want)
+		}
+	}
+
+	for _, constraint := range []string{"3.0.0", ">=x", "1.2.0,"} {
+		if _, err := selectRecipeVersion(available, constraint, ""); err == nil {
+			t.Errorf("selectRecipeVersion(%q) should fail", constraint)
+		}
+	}
+}
+
+// The recipes in config/recipes are shipped in recipesRoot
+func TestShippedRecipeVersions(t *testing.T) {
+
+	root := filepath.Join("..", "config", "recipes", "simple-kmod")
+
+	versions, err := recipeVersions(root)
+	if err != nil {
+		t.Fatalf("recipeVersions: %v", err)
+	}
+	want := map[string]string{
+		"1.0.0": filepath.Join(root, "manifests"),
+		"0.9.0": filepath.Join(root, "versions", "0.9.0", "manifests"),
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("simple-kmod versions = %v, want %v", versions, want)
+	}
+
+	if got, err := selectRecipeVersion([]string{"0.9.0", "1.0.0"}, "<1.0.0", "1.0.0"); err != nil || got != "0.9.0" {
+		t.Errorf("selectRecipeVersion(<1.0.0) = %s, %v", got, err)
+	}
+}
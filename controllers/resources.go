@@ -3,10 +3,15 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"html/template"
-	"sort"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/go-logr/logr"
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
 	"github.com/openshift-psap/special-resource-operator/yamlutil"
 	buildV1 "github.com/openshift/api/build/v1"
 	imageV1 "github.com/openshift/api/image/v1"
@@ -24,17 +29,18 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
-	"sigs.k8s.io/yaml"
 )
 
 type nodes struct {
 	list  *unstructured.UnstructuredList
 	count int64
+	// partition is the partition we are reconciling, nil otherwise
+	partition *partition
 }
 
 var (
 	manifests    = "/etc/kubernetes/special-resource/nvidia-gpu"
-	kubeclient   *kubernetes.Clientset
+	kubeclient   kubernetes.Interface
 	configclient *configv1.ConfigV1Client
 
 	node = nodes{
@@ -96,8 +102,11 @@ func getHardwareConfiguration(r *SpecialResourceReconciler) (*unstructured.Unstr
 	err := r.Get(context.TODO(), namespacedName, cm)
 
 	if apierrors.IsNotFound(err) {
-		log.Info("Hardware Configuration ConfigMap not found, creating from local repository (/opt/sro/recipes) for")
-		manifests := "/opt/sro/recipes/" + r.specialresource.Name + "/manifests"
+		log.Info("Hardware Configuration ConfigMap not found, creating from local repository (" + recipesRoot + ") for")
+		manifests, err := getRecipeManifests(r)
+		if err != nil {
+			return nil, errs.Wrap(err, "Cannot select recipe version")
+		}
 		return getLocalHardwareConfiguration(manifests, r.specialresource.Name)
 	}
 
@@ -119,6 +128,14 @@ func getLocalHardwareConfiguration(path string, specialresource string) (*unstru
 		data[string(manifest.name)] = string(manifest.content)
 	}
 
+	// The recipe file is not a state, it declares the states
+	recipe, err := ioutil.ReadFile(filepath.Join(path, recipeFile))
+	if err == nil {
+		data[recipeFile] = string(recipe)
+	} else if !os.IsNotExist(err) {
+		return cm, errs.Wrap(err, "Cannot read "+recipeFile)
+	}
+
 	if err := unstructured.SetNestedStringMap(cm.Object, data, "data"); err != nil {
 		return cm, errs.Wrap(err, "Couldn't update ConfigMap data field")
 	}
@@ -135,13 +152,23 @@ func createImagePullerRoleBinding(r *SpecialResourceReconciler) error {
 		return nil
 	}
 
+	// The builder ServiceAccount only exists with OpenShift builds
+	caps, err := getCapabilities()
+	if err != nil {
+		return err
+	}
+	if !caps.ImageStreams {
+		log.Info("ImageStreams not available, skipping ImageReference RoleBinding")
+		return nil
+	}
+
 	log.Info("Looking for ImageReference RoleBinding")
 	rb := &unstructured.Unstructured{}
 	rb.SetAPIVersion("rbac.authorization.k8s.io/v1")
 	rb.SetKind("RoleBinding")
 
 	namespacedName := types.NamespacedName{Namespace: r.specialresource.Spec.Namespace, Name: "system:image-puller"}
-	err := r.Get(context.TODO(), namespacedName, rb)
+	err = r.Get(context.TODO(), namespacedName, rb)
 
 	newSubject := make(map[string]interface{})
 	newSubjects := make([]interface{}, 0)
@@ -242,23 +269,22 @@ func ReconcileHardwareStates(r *SpecialResourceReconciler, config unstructured.U
 	manifests, found, err = unstructured.NestedMap(config.Object, "data")
 	exitOnErrorOrNotFound(found, err)
 
-	states := make([]string, 0, len(manifests))
-	for key := range manifests {
-		states = append(states, key)
+	rcp, err := getRecipe(manifests)
+	if err != nil {
+		return errs.Wrap(err, "Cannot get recipe")
 	}
 
-	sort.Strings(states)
-
-	for _, state := range states {
-
-		log.Info("Executing", "State", state)
-		namespacedYAML := []byte(manifests[state].(string))
-		if err := createFromYAML(namespacedYAML, r, r.specialresource.Spec.Namespace); err != nil {
-			return errs.Wrap(err, "Failed to create resources")
-		}
+	// Typos in the configuration would render silently empty
+	runInfo.Config, err = validateConfiguration(rcp.Configuration, r.specialresource.Spec.Configuration)
+	setConfigurationCondition(err, r)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// Hook Jobs apply to all following states of this recipe only
+	jobHooks = []*unstructured.Unstructured{}
+
+	return reconcileRecipeStates(rcp, manifests, r)
 }
 
 func createSpecialResourceNamespace(r *SpecialResourceReconciler) {
@@ -289,6 +315,14 @@ func ReconcileHardwareConfigurations(r *SpecialResourceReconciler) error {
 
 	var err error
 	var config *unstructured.Unstructured
+
+	// Objects of all partitions are rendered before anything is pruned
+	inventory = map[srov1beta1.SpecialResourceInventoryObject]bool{}
+	// Objects shared by all partitions are applied once
+	sharedObjects = map[srov1beta1.SpecialResourceInventoryObject]uint64{}
+	// A requested rollback applies to all partitions
+	defer clearRollbackRequest(r)
+
 	// Leave this here, this is crucial for all following work
 	// Creating and setting the working namespace for the specialresource
 	// specialresource name == namespace if not metadata.namespace is set
@@ -312,7 +346,45 @@ func ReconcileHardwareConfigurations(r *SpecialResourceReconciler) error {
 	node.list, err = cacheNodes(r, false)
 	exitOnError(errs.Wrap(err, "Failed to cache Nodes"))
 
-	getRuntimeInformation(r)
+	// Mixed clusters get a build and driver-container per arch and kernel
+	partitions := partitionNodes(node.list.Items)
+	defer func() { node.partition = nil }()
+
+	// A partition that is not ready yet does not hold back the others
+	pending := []string{}
+	for i := range partitions {
+		node.partition = &partitions[i]
+		log.Info("Reconciling partition", "Partition", node.partition.ID, "Arch", node.partition.Arch, "Kernel", node.partition.Kernel)
+
+		if err := reconcilePartition(r, config); err != nil {
+			log.Info("Partition not reconciled", "Partition", node.partition.ID, "error", fmt.Sprintf("%v", err))
+			pending = append(pending, node.partition.ID+": "+err.Error())
+		}
+	}
+
+	if len(pending) > 0 {
+		reconcileInventory(r, false)
+		return errs.New("Cannot reconcile partitions " + strings.Join(pending, "; "))
+	}
+
+	// Without nodes there is nothing to partition, render once
+	if len(partitions) == 0 {
+		if err := reconcilePartition(r, config); err != nil {
+			reconcileInventory(r, false)
+			return err
+		}
+	}
+
+	reconcileInventory(r, true)
+
+	return nil
+}
+
+func reconcilePartition(r *SpecialResourceReconciler, config *unstructured.Unstructured) error {
+
+	if err := getRuntimeInformation(r); err != nil {
+		return errs.Wrap(err, "Cannot get runtime information")
+	}
 	logRuntimeInformation()
 
 	if err := ReconcileHardwareStates(r, *config); err != nil {
@@ -326,9 +398,12 @@ func templateRuntimeInformation(yamlSpec *[]byte, r runtimeInformation) error {
 
 	spec := string(*yamlSpec)
 
-	t := template.Must(template.New("runtime").Parse(spec))
+	t, err := template.New("runtime").Funcs(r.templateFuncs()).Parse(spec)
+	if err != nil {
+		return errs.Wrap(err, "Cannot parse template for resource info injection, check manifest")
+	}
 	var buff bytes.Buffer
-	if err := t.Execute(&buff, runInfo); err != nil {
+	if err := t.Execute(&buff, r.templateContext()); err != nil {
 		return errs.Wrap(err, "Cannot templatize spec for resource info injection, check manifest")
 	}
 	*yamlSpec = buff.Bytes()
@@ -342,52 +417,122 @@ func createFromYAML(yamlFile []byte, r *SpecialResourceReconciler, namespace str
 
 	for scanner.Scan() {
 
-		yamlSpec := scanner.Bytes()
+		obj, err := renderObject(scanner.Bytes(), namespace)
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			continue
+		}
 
-		// We can pass template information from the CR to the yamls
-		// thats why we are running 2 passes.
-		if err := templateRuntimeInformation(&yamlSpec, runInfo); err != nil {
-			return errs.Wrap(err, "Cannot inject runtime information 1st pass")
+		include, err := includeObject(obj, r)
+		if err != nil {
+			return errs.Wrap(err, "Cannot evaluate include-if")
+		}
+		if !include {
+			continue
 		}
 
-		if err := templateRuntimeInformation(&yamlSpec, runInfo); err != nil {
-			return errs.Wrap(err, "Cannot inject runtime information 2nd pass")
+		// Hook Jobs run when their target reaches the phase, they are
+		// recorded under the name of the Job that runs, see runJobHook
+		if isJobHook(obj) {
+			if err := registerJobHook(obj); err != nil {
+				return errs.Wrap(err, "Cannot register hook Job")
+			}
+			continue
+		}
+
+		recordInventory(obj)
+
+		if err := runHooks(hookPreRender, obj, r); err != nil {
+			return errs.Wrap(err, "PreRender hooks failed")
 		}
 
-		obj := &unstructured.Unstructured{}
-		jsonSpec, err := yaml.YAMLToJSON(yamlSpec)
+		// Objects shared by all partitions are applied by the first one
+		shared, hash, err := sharedObjectApplied(obj)
 		if err != nil {
-			return errs.Wrap(err, "Could not convert yaml file to json"+string(yamlSpec))
+			return err
+		}
+		if shared {
+			continue
+		}
+
+		if err := pinToPartition(obj); err != nil {
+			return errs.Wrap(err, "Cannot pin to partition")
+		}
+
+		if err := pruneUnpartitioned(obj, r); err != nil {
+			return errs.Wrap(err, "Cannot prune object replaced by partition")
+		}
+
+		if err := injectArtifacts(obj, r); err != nil {
+			return errs.Wrap(err, "Cannot inject artifacts")
+		}
+
+		// Base images are looked up in all their mirrors, a build pulls
+		// them from the first mirror that has them
+		var baseImages map[string]string
+		var unresolved []string
+		if obj.GetKind() == "BuildConfig" {
+			if baseImages, unresolved, err = resolveBaseImages(buildBaseImages(obj), r); err != nil {
+				return errs.Wrap(err, "Cannot resolve base images of "+obj.GetName())
+			}
 		}
 
-		err = obj.UnmarshalJSON(jsonSpec)
-		exitOnError(errs.Wrap(err, "Cannot unmarshall json spec, check your manifests"))
+		// Disconnected clusters pull images and sources from mirrors
+		if err := rewriteMirrors(obj, baseImages); err != nil {
+			return errs.Wrap(err, "Cannot rewrite mirrors")
+		}
 
-		if resourceNamespaced(obj.GetKind()) {
-			obj.SetNamespace(namespace)
+		// Without OpenShift builds there are no ImageStreams, images are
+		// pushed to the build registry instead.
+		if runInfo.BuildBackend == buildBackendKaniko {
+			if obj.GetKind() == "ImageStream" {
+				log.Info("Skipping ImageStream, no OpenShift builds", "Name", obj.GetName())
+				continue
+			}
+			if obj.GetKind() == "BuildConfig" {
+				if obj, err = kanikoJobFrom(obj, r); err != nil {
+					return errs.Wrap(err, "Cannot translate BuildConfig to kaniko Job")
+				}
+				recordInventory(obj)
+			}
 		}
 
-		// We are only building a driver-container if we cannot pull the image
-		// We are asuming that vendors provide pre compiled DriverContainers
-		// If err == nil, build a new container, if err != nil skip it
-		if err := rebuildDriverContainer(obj, r); err != nil {
-			log.Info("Skipping building driver-container", "Name", obj.GetName())
-			return nil
+		// We are only building a driver-container if there is no image
+		// for the kernel we are rendering for.
+		if obj.GetKind() == "BuildConfig" || isKanikoJob(obj) {
+			build, err := driverBuildNeeded(obj, r)
+			if err != nil {
+				return errs.Wrap(err, "Cannot check for driver-container image")
+			}
+			if !build {
+				log.Info("Skipping building driver-container", "Name", obj.GetName())
+				continue
+			}
+			if len(unresolved) > 0 {
+				return errs.New("Base images " + strings.Join(unresolved, ",") + " of " + obj.GetName() + " cannot be found in any mirror")
+			}
 		}
 
-		// Callbacks before CRUD will update the manifests
-		if err := beforeCRUDhooks(obj, r); err != nil {
-			return errs.Wrap(err, "Before CRUD hooks failed")
+		// Hooks before CRUD will update the manifests
+		if err := runHooks(hookPreApply, obj, r); err != nil {
+			return errs.Wrap(err, "PreApply hooks failed")
 		}
+		// Snapshots keep objects as they were applied
+		recordRendered(obj)
+
 		// Create Update Delete Patch resources
 		err = CRUD(obj, r)
 		exitOnError(errs.Wrap(err, "CRUD exited non-zero"))
 
-		// Callbacks after CRUD will wait for ressource and check status
+		// Hooks after CRUD will wait for ressource and check status
 		if err := afterCRUDhooks(obj, r); err != nil {
 			return errs.Wrap(err, "After CRUD hooks failed")
 		}
 
+		recordSharedObject(obj, hash)
+
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -485,10 +630,10 @@ func CRUD(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
 		return errs.Wrap(err, "Unexpected error")
 	}
 	// Not updating Pod because we can only update image and some other
-	// specific minor fields.
+	// specific minor fields, same for the template of a Job.
 	//
 	// ServiceAccounts cannot be updated, maybe delete and create?
-	if obj.GetKind() == "ServiceAccount" || obj.GetKind() == "Pod" || obj.GetKind() == "BuildConfig" {
+	if obj.GetKind() == "ServiceAccount" || obj.GetKind() == "Pod" || obj.GetKind() == "BuildConfig" || obj.GetKind() == "Job" {
 		// Not updating BuildConfig since it triggers a new build in 4.6 was not doing that in <4.6
 		//logger.Info("TODO: Found, not updating, does not work, why? Secret accumulation?")
 		return nil
@@ -510,25 +655,3 @@ func CRUD(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
 
 	return nil
 }
-
-func rebuildDriverContainer(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
-
-	logger := log.WithValues("Kind", obj.GetKind(), "Namespace", obj.GetNamespace(), "Name", obj.GetName())
-	// BuildConfig are currently not triggered by an update need to delete first
-	if obj.GetKind() == "BuildConfig" {
-		annotations := obj.GetAnnotations()
-		if vendor, ok := annotations["specialresource.openshift.io/driver-container-vendor"]; ok {
-			logger.Info("driver-container-vendor", "vendor", vendor)
-			if vendor == runInfo.UpdateVendor {
-				logger.Info("vendor == updateVendor", "vendor", vendor, "updateVendor", runInfo.UpdateVendor)
-				return nil
-			}
-			logger.Info("vendor != updateVendor", "vendor", vendor, "updateVendor", runInfo.UpdateVendor)
-			return errs.New("vendor != updateVendor")
-		}
-		logger.Info("No annotation driver-container-vendor found")
-		return errs.New("No driver-container-vendor found, nor vendor == updateVendor")
-	}
-
-	return nil
-}
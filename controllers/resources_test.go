@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestTemplateRuntimeInformationInvalid(t *testing.T) {
+
+	// A broken template from a SpecialResource must not panic the operator
+	spec := []byte("quay.io/vendor/driver:{{.KernelVersion")
+	if err := templateRuntimeInformation(&spec, runInfo); err == nil {
+		t.Errorf("templateRuntimeInformation of an invalid template succeeded")
+	}
+
+	spec = []byte("quay.io/vendor/driver:{{.KernelVersion}}")
+	ri := runtimeInformation{KernelVersion: "4.18.0-240.el8.x86_64"}
+	if err := templateRuntimeInformation(&spec, ri); err != nil {
+		t.Fatalf("templateRuntimeInformation failed: %v", err)
+	}
+	if string(spec) != "quay.io/vendor/driver:4.18.0-240.el8.x86_64" {
+		t.Errorf("rendered %s", spec)
+	}
+}
@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"time"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"github.com/openshift-psap/special-resource-operator/yamlutil"
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+)
+
+// rollbackAnnotation "true" on the SpecialResource rolls back every state
+// that is not Ready and has a snapshot, it is removed once handled
+const rollbackAnnotation = "specialresource.openshift.io/rollback"
+
+// snapshotHashAnnotation is the hash of the rendered manifests of a snapshot
+const snapshotHashAnnotation = "specialresource.openshift.io/snapshot-hash"
+
+// snapshotKey holds the objects of a snapshot as YAML documents
+const snapshotKey = "manifest"
+
+const statePhaseRolledBack = "RolledBack"
+
+const conditionDegraded = "Degraded"
+
+// renderedObjects are the objects of the state we are applying as they
+// were sent to the API server
+var renderedObjects = []*unstructured.Unstructured{}
+
+func recordRendered(obj *unstructured.Unstructured) {
+	renderedObjects = append(renderedObjects, obj.DeepCopy())
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// snapshotName is the Secret of the last Ready snapshot of a state, states
+// may render Secrets e.g. credentials of a CSI driver
+func snapshotName(specialresource string, state string, partition string) string {
+
+	name := specialresource + "-snapshot-" + state
+	if partition != "" {
+		name += "-" + partition
+	}
+	name = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return name
+}
+
+// renderedHash renders all objects of a manifest without applying them
+func renderedHash(yamlFile []byte, namespace string) (string, error) {
+
+	h := fnv.New64a()
+
+	scanner := yamlutil.NewYAMLScanner(yamlFile)
+	for scanner.Scan() {
+		obj, err := renderObject(scanner.Bytes(), namespace)
+		if err != nil {
+			return "", err
+		}
+		if obj == nil {
+			continue
+		}
+		// Maps are marshalled with sorted keys
+		spec, err := json.Marshal(obj.Object)
+		if err != nil {
+			return "", errs.Wrap(err, "Cannot marshal "+obj.GetName())
+		}
+		h.Write(spec)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errs.Wrap(err, "Failed to scan manifest")
+	}
+
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
+
+// snapshotManifest joins objects to YAML documents
+func snapshotManifest(objects []*unstructured.Unstructured) (string, error) {
+
+	docs := []string{}
+	for _, obj := range objects {
+		spec, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return "", errs.Wrap(err, "Cannot marshal "+obj.GetName())
+		}
+		docs = append(docs, string(spec))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// writeSnapshot keeps the objects of a Ready state
+func writeSnapshot(state string, hash string, objects []*unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	manifest, err := snapshotManifest(objects)
+	if err != nil {
+		return err
+	}
+
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace(r.specialresource.Spec.Namespace)
+	secret.SetName(snapshotName(r.specialresource.Name, state, runInfo.Partition))
+
+	// Snapshots used to be ConfigMaps, they hold Secrets in clear text
+	if err := pruneLegacySnapshot(secret, r); err != nil {
+		return err
+	}
+
+	found := secret.DeepCopy()
+	err = r.Get(context.TODO(), types.NamespacedName{Namespace: secret.GetNamespace(), Name: secret.GetName()}, found)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errs.Wrap(err, "Cannot get snapshot "+secret.GetName())
+	}
+	exists := err == nil
+	if exists && found.GetAnnotations()[snapshotHashAnnotation] == hash {
+		return nil
+	}
+
+	secret.SetAnnotations(map[string]string{snapshotHashAnnotation: hash})
+	secret.Object["type"] = "Opaque"
+	data := map[string]string{snapshotKey: base64.StdEncoding.EncodeToString([]byte(manifest))}
+	if err := unstructured.SetNestedStringMap(secret.Object, data, "data"); err != nil {
+		return errs.Wrap(err, "Cannot set snapshot data")
+	}
+	if err := controllerutil.SetControllerReference(&r.specialresource, secret, r.Scheme); err != nil {
+		return errs.Wrap(err, "Failed to set controller reference")
+	}
+
+	log.Info("Writing snapshot", "State", state, "Secret", secret.GetName())
+	if !exists {
+		return errs.Wrap(r.Create(context.TODO(), secret), "Cannot create snapshot")
+	}
+	secret.SetResourceVersion(found.GetResourceVersion())
+	return errs.Wrap(r.Update(context.TODO(), secret), "Cannot update snapshot")
+}
+
+// pruneLegacySnapshot deletes the ConfigMap of the same name as secret
+func pruneLegacySnapshot(secret *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace(secret.GetNamespace())
+	cm.SetName(secret.GetName())
+
+	return errs.Wrap(pruneObject(cm, r), "Cannot prune snapshot ConfigMap")
+}
+
+// readSnapshot returns the objects of the snapshot of state, false if there
+// is none
+func readSnapshot(state string, r *SpecialResourceReconciler) ([]*unstructured.Unstructured, bool, error) {
+
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+
+	name := snapshotName(r.specialresource.Name, state, runInfo.Partition)
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: r.specialresource.Spec.Namespace, Name: name}, secret)
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errs.Wrap(err, "Cannot get snapshot "+name)
+	}
+
+	encoded, _, err := unstructured.NestedString(secret.Object, "data", snapshotKey)
+	if err != nil {
+		return nil, false, errs.Wrap(err, "Cannot read snapshot "+name)
+	}
+	manifest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false, errs.Wrap(err, "Cannot decode snapshot "+name)
+	}
+
+	objects := []*unstructured.Unstructured{}
+	scanner := yamlutil.NewYAMLScanner(manifest)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(scanner.Bytes(), &obj.Object); err != nil {
+			return nil, false, errs.Wrap(err, "Cannot parse snapshot "+name)
+		}
+		objects = append(objects, obj)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, errs.Wrap(err, "Failed to scan snapshot "+name)
+	}
+
+	return objects, true, nil
+}
+
+// applySnapshot applies the objects of a snapshot as they were, only
+// PostApply hooks run e.g. to roll the Pods of a DaemonSet
+func applySnapshot(objects []*unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	for _, obj := range objects {
+		recordInventory(obj)
+		if err := CRUD(obj, r); err != nil {
+			return errs.Wrap(err, "Cannot apply "+obj.GetKind()+"/"+obj.GetName())
+		}
+		if err := runHooks(hookPostApply, obj, r); err != nil {
+			return errs.Wrap(err, "PostApply hooks failed for "+obj.GetKind()+"/"+obj.GetName())
+		}
+	}
+	return nil
+}
+
+// crashLooping returns a Pod of pods with a container that restarted at
+// least threshold times and is in CrashLoopBackOff
+func crashLooping(pods []corev1.Pod, threshold int32) (string, bool) {
+
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" && status.RestartCount >= threshold {
+				return fmt.Sprintf("Pod %s on %s restarted %d times", pod.Name, pod.Spec.NodeName, status.RestartCount), true
+			}
+		}
+	}
+	return "", false
+}
+
+// rollbackReason returns why a state that is not Ready failed, empty if it
+// did not fail (yet)
+func rollbackReason(previous *srov1beta1.SpecialResourceStateStatus, objects []*unstructured.Unstructured, r *SpecialResourceReconciler) string {
+
+	if r.specialresource.GetAnnotations()[rollbackAnnotation] == "true" {
+		return "Rollback requested with " + rollbackAnnotation
+	}
+
+	rollback := r.specialresource.Spec.Rollback
+	if rollback == nil || !rollback.Automatic {
+		return ""
+	}
+
+	if rollback.Timeout != nil && previous != nil && previous.LastTransitionTime != nil &&
+		time.Since(previous.LastTransitionTime.Time) > rollback.Timeout.Duration {
+		return "Not Ready for " + rollback.Timeout.Duration.String()
+	}
+
+	if rollback.CrashLoopThreshold > 0 {
+		for _, obj := range objects {
+			if obj.GetKind() != "DaemonSet" {
+				continue
+			}
+			pods, err := daemonSetPods(obj)
+			if err != nil {
+				log.Error(err, "Cannot check for crash loops", "DaemonSet", obj.GetName())
+				continue
+			}
+			if reason, found := crashLooping(pods, rollback.CrashLoopThreshold); found {
+				return reason
+			}
+		}
+	}
+
+	return ""
+}
+
+// previousStateStatus returns the status of state of the last reconcile
+func previousStateStatus(state string, r *SpecialResourceReconciler) *srov1beta1.SpecialResourceStateStatus {
+	for i, s := range r.specialresource.Status.States {
+		if s.Name == state && s.Partition == runInfo.Partition {
+			return &r.specialresource.Status.States[i]
+		}
+	}
+	return nil
+}
+
+// stateTransitionTime keeps the time of the last reconcile if the state is
+// still in the same phase, Applied and Failed are both not Ready
+func stateTransitionTime(previous *srov1beta1.SpecialResourceStateStatus, phase string) *metav1.Time {
+
+	notReady := func(phase string) bool { return phase == statePhaseApplied || phase == statePhaseFailed }
+
+	if previous != nil && previous.LastTransitionTime != nil &&
+		(previous.Phase == phase || notReady(previous.Phase) && notReady(phase)) {
+		return previous.LastTransitionTime
+	}
+	now := metav1.Now()
+	return &now
+}
+
+// setDegradedCondition is True while any state is rolled back
+func setDegradedCondition(r *SpecialResourceReconciler) {
+
+	condition := metav1.Condition{
+		Type:               conditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "AsExpected",
+		ObservedGeneration: r.specialresource.GetGeneration(),
+	}
+
+	rolledBack := []string{}
+	for _, s := range r.specialresource.Status.States {
+		if s.Phase == statePhaseRolledBack {
+			rolledBack = append(rolledBack, s.Name+": "+s.Message)
+		}
+	}
+	if len(rolledBack) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "RolledBack"
+		condition.Message = strings.Join(rolledBack, "; ")
+	}
+
+	setCondition(condition, r)
+}
+
+// clearRollbackRequest removes the rollback annotation once all partitions
+// were reconciled
+func clearRollbackRequest(r *SpecialResourceReconciler) {
+
+	if _, found := r.specialresource.GetAnnotations()[rollbackAnnotation]; !found {
+		return
+	}
+
+	patch := []byte(`{"metadata":{"annotations":{"` + rollbackAnnotation + `":null}}}`)
+	if err := r.Patch(context.TODO(), &r.specialresource, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		log.Error(err, "Cannot remove rollback annotation")
+	}
+}
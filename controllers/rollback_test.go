@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSnapshotName(t *testing.T) {
+
+	tests := []struct {
+		state, partition, want string
+	}{
+		{"driver", "", "nvidia-gpu-snapshot-driver"},
+		{"1000-state-driver.yaml", "x86_64-4.18.0", "nvidia-gpu-snapshot-1000-state-driver.yaml-x86-64-4.18.0"},
+	}
+	for _, tt := range tests {
+		if got := snapshotName("nvidia-gpu", tt.state, tt.partition); got != tt.want {
+			t.Errorf("snapshotName(%s, %s) = %s, want %s", tt.state, tt.partition, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotManifest(t *testing.T) {
+
+	ds := &unstructured.Unstructured{}
+	ds.SetAPIVersion("apps/v1")
+	ds.SetKind("DaemonSet")
+	ds.SetName("driver")
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetName("entrypoint")
+
+	manifest, err := snapshotManifest([]*unstructured.Unstructured{ds, cm})
+	if err != nil {
+		t.Fatalf("snapshotManifest: %v", err)
+	}
+	if strings.Count(manifest, "---\n") != 1 || !strings.Contains(manifest, "kind: DaemonSet") {
+		t.Errorf("snapshotManifest = %s", manifest)
+	}
+
+	// A snapshot renders to the same hash as the manifest it was taken from
+	a, err := renderedHash([]byte(manifest), "ns")
+	if err != nil {
+		t.Fatalf("renderedHash: %v", err)
+	}
+	b, _ := renderedHash([]byte(manifest+"\n"), "ns")
+	c, _ := renderedHash([]byte(manifest), "other")
+	if a != b || a == c {
+		t.Errorf("renderedHash %s %s %s", a, b, c)
+	}
+}
+
+func TestSnapshotSecret(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := srov1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &SpecialResourceReconciler{Scheme: scheme}
+	r.specialresource.Name = "lustre-client"
+	r.specialresource.UID = "1234"
+	r.specialresource.Spec.Namespace = "lustre-client"
+
+	// A snapshot of an earlier version
+	controller := true
+	legacy := &unstructured.Unstructured{}
+	legacy.SetKind("ConfigMap")
+	legacy.SetNamespace("lustre-client")
+	legacy.SetName(snapshotName("lustre-client", "csi", ""))
+	legacy.SetOwnerReferences([]metav1.OwnerReference{{UID: r.specialresource.UID, Controller: &controller}})
+	fake := &fakeClient{objects: []*unstructured.Unstructured{legacy}}
+	r.Client = fake
+
+	credentials := &unstructured.Unstructured{}
+	credentials.SetAPIVersion("v1")
+	credentials.SetKind("Secret")
+	credentials.SetName("aws-secret")
+	credentials.Object["stringData"] = map[string]interface{}{"key_secret": "hunter2"}
+
+	if err := writeSnapshot("csi", "1", []*unstructured.Unstructured{credentials}, r); err != nil {
+		t.Fatalf("writeSnapshot: %v", err)
+	}
+
+	if len(fake.deleted) != 1 || fake.deleted[0] != "ConfigMap/"+legacy.GetName() {
+		t.Errorf("deleted = %v, want the ConfigMap snapshot", fake.deleted)
+	}
+	snapshot := fake.objects[len(fake.objects)-1]
+	if snapshot.GetKind() != "Secret" {
+		t.Fatalf("snapshot is a %s, want a Secret", snapshot.GetKind())
+	}
+	if data, _, _ := unstructured.NestedString(snapshot.Object, "data", snapshotKey); strings.Contains(data, "hunter2") {
+		t.Errorf("snapshot data is not encoded: %s", data)
+	}
+
+	objects, found, err := readSnapshot("csi", r)
+	if err != nil || !found || len(objects) != 1 {
+		t.Fatalf("readSnapshot = %v, %v, %v", objects, found, err)
+	}
+	if value, _, _ := unstructured.NestedString(objects[0].Object, "stringData", "key_secret"); value != "hunter2" {
+		t.Errorf("snapshot object = %v", objects[0].Object)
+	}
+}
+
+func TestCrashLooping(t *testing.T) {
+
+	pod := func(reason string, restarts int32) corev1.Pod {
+		p := corev1.Pod{}
+		p.Name = "driver-x"
+		p.Spec.NodeName = "worker-0"
+		p.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			RestartCount: restarts,
+			State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+		}}
+		return p
+	}
+
+	if _, found := crashLooping([]corev1.Pod{pod("CrashLoopBackOff", 2)}, 3); found {
+		t.Errorf("2 restarts should not hit a threshold of 3")
+	}
+	if _, found := crashLooping([]corev1.Pod{pod("ContainerCreating", 5)}, 3); found {
+		t.Errorf("only CrashLoopBackOff counts")
+	}
+	if reason, found := crashLooping([]corev1.Pod{pod("ContainerCreating", 0), pod("CrashLoopBackOff", 3)}, 3); !found || !strings.Contains(reason, "worker-0") {
+		t.Errorf("crashLooping = %s, %v", reason, found)
+	}
+}
+
+func TestStateTransitionTime(t *testing.T) {
+
+	then := metav1.NewTime(time.Now().Add(-time.Hour))
+	previous := &srov1beta1.SpecialResourceStateStatus{Phase: statePhaseApplied, LastTransitionTime: &then}
+
+	if got := stateTransitionTime(previous, statePhaseFailed); !got.Equal(&then) {
+		t.Errorf("Applied -> Failed should keep the transition time")
+	}
+	if got := stateTransitionTime(previous, statePhaseReady); got.Equal(&then) {
+		t.Errorf("Applied -> Ready is a transition")
+	}
+	if got := stateTransitionTime(nil, statePhaseApplied); got == nil {
+		t.Errorf("a new state has a transition time")
+	}
+}
+
+func TestRollbackReason(t *testing.T) {
+
+	r := &SpecialResourceReconciler{}
+	then := metav1.NewTime(time.Now().Add(-time.Hour))
+	previous := &srov1beta1.SpecialResourceStateStatus{Phase: statePhaseApplied, LastTransitionTime: &then}
+
+	if reason := rollbackReason(previous, nil, r); reason != "" {
+		t.Errorf("without rollback configured = %s", reason)
+	}
+
+	r.specialresource.Spec.Rollback = &srov1beta1.SpecialResourceRollback{Timeout: &metav1.Duration{Duration: time.Minute}}
+	if reason := rollbackReason(previous, nil, r); reason != "" {
+		t.Errorf("without automatic rollback = %s", reason)
+	}
+
+	r.specialresource.Spec.Rollback.Automatic = true
+	if reason := rollbackReason(previous, nil, r); reason == "" {
+		t.Errorf("timeout should roll back")
+	}
+
+	r.specialresource.Spec.Rollback = nil
+	r.specialresource.SetAnnotations(map[string]string{rollbackAnnotation: "true"})
+	if reason := rollbackReason(nil, nil, r); reason == "" {
+		t.Errorf("annotation should roll back")
+	}
+}
+
+func TestSetDegradedCondition(t *testing.T) {
+
+	r := &SpecialResourceReconciler{}
+	r.specialresource.Status.States = []srov1beta1.SpecialResourceStateStatus{
+		{Name: "driver", Phase: statePhaseRolledBack, Message: "Not Ready for 10m0s"},
+		{Name: "runtime", Phase: statePhaseReady},
+	}
+
+	setDegradedCondition(r)
+	if !meta.IsStatusConditionTrue(r.specialresource.Status.Conditions, conditionDegraded) {
+		t.Fatalf("rolled back state should be Degraded")
+	}
+	if c := meta.FindStatusCondition(r.specialresource.Status.Conditions, conditionDegraded); !strings.Contains(c.Message, "driver") {
+		t.Errorf("Degraded message %s", c.Message)
+	}
+
+	r.specialresource.Status.States[0].Phase = statePhaseReady
+	setDegradedCondition(r)
+	if !meta.IsStatusConditionFalse(r.specialresource.Status.Conditions, conditionDegraded) {
+		t.Errorf("Degraded should be False once all states are Ready")
+	}
+}
+
+func TestSnapshotReady(t *testing.T) {
+
+	object := func(kind string, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind(kind)
+		obj.SetNamespace("nvidia-gpu")
+		obj.SetName(name)
+		return obj
+	}
+
+	ds := object("DaemonSet", "nvidia-gpu-driver-container-rhel8")
+	ds.Object["status"] = map[string]interface{}{"desiredNumberScheduled": int64(2), "numberUnavailable": int64(1)}
+	cm := object("ConfigMap", "nvidia-gpu-driver-container-rhel8-entrypoint")
+
+	r := &SpecialResourceReconciler{Client: &fakeClient{objects: []*unstructured.Unstructured{cm, ds}}}
+	snapshot := []*unstructured.Unstructured{object("ConfigMap", cm.GetName()), object("DaemonSet", ds.GetName())}
+
+	if ready, message, err := snapshotReady(snapshot, r); err != nil || ready || !strings.Contains(message, ds.GetName()) {
+		t.Errorf("snapshotReady with an unavailable DaemonSet = %v, %s, %v", ready, message, err)
+	}
+
+	ds.Object["status"] = map[string]interface{}{"desiredNumberScheduled": int64(2), "numberUnavailable": int64(0)}
+	if ready, message, err := snapshotReady(snapshot, r); err != nil || !ready {
+		t.Errorf("snapshotReady = %v, %s, %v, want Ready", ready, message, err)
+	}
+
+	missing := append(snapshot, object("Service", "nvidia-gpu-device-monitoring"))
+	if ready, _, err := snapshotReady(missing, r); err != nil || ready {
+		t.Errorf("snapshotReady of a missing object = %v, %v", ready, err)
+	}
+}
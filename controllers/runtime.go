@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"context"
-	"strconv"
 	"strings"
 
 	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
@@ -28,17 +27,13 @@ type resourceGroupName struct {
 	CSIDriver              string
 }
 
+// resourceStateName are the label prefixes of the states of the recipes
+// shipped with the operator, see stateLabel for any other state
 type resourceStateName struct {
 	DriverContainer   string
 	RuntimeEnablement string
 	DevicePlugin      string
 	DeviceMonitoring  string
-	/*
-		"driver-container":   {"specialresource.openshift.io/driver-container-" + hw: "ready"},
-		"runtime-enablement": {"specialresource.openshift.io/runtime-enablement-" + hw: "ready"},
-		"device-plugin":      {"specialresource.openshift.io/device-plugin-" + hw: "ready"},
-		"device-monitoring":  {"specialresource.openshift.io/device-monitoring-" + hw: "ready"},
-	*/
 }
 
 type proxyConfiguration struct {
@@ -55,14 +50,37 @@ type runtimeInformation struct {
 	KernelVersion             string
 	ClusterVersion            string
 	ClusterVersionMajorMinor  string
-	UpdateVendor              string
 	PushSecretName            string
 	OSImageURL                string
-
-	Proxy           proxyConfiguration
-	GroupName       resourceGroupName
-	StateName       resourceStateName
-	SpecialResource srov1beta1.SpecialResource
+	DriverImage               string
+	DriverImagePrebuilt       bool
+	BuildBackend              string
+	Partition                 string
+	Mirrors                   []srov1beta1.SpecialResourceMirror
+	// ClusterMirrors are the mirrors of ImageContentSourcePolicies, only
+	// builds are pointed to them
+	ClusterMirrors []srov1beta1.SpecialResourceMirror
+	// InsecureRegistries of the operator and the cluster image config
+	InsecureRegistries []string
+
+	// Available records which runtime information providers succeeded,
+	// e.g. {{if .Available.ClusterVersion}}
+	Available    map[string]bool
+	Capabilities capabilities
+	// Values holds all keys contributed by providers, keys without a
+	// field in runtimeInformation are only available in templates
+	Values map[string]interface{}
+
+	// Config is spec.configuration validated against the schema of the
+	// recipe, see configSchema
+	Config     map[string]interface{}
+	Proxy      proxyConfiguration
+	Monitoring monitoringInformation
+	GroupName  resourceGroupName
+	StateName  resourceStateName
+	// StateLabelPrefix is the prefix of the state labels of nodes
+	StateLabelPrefix string
+	SpecialResource  srov1beta1.SpecialResource
 }
 
 var runInfo = runtimeInformation{
@@ -76,12 +94,7 @@ var runInfo = runtimeInformation{
 		DeviceFeatureDiscovery: "device-feature-discovery",
 		CSIDriver:              "csi-driver",
 	},
-	StateName: resourceStateName{
-		DriverContainer:   "specialresource.openshift.io/driver-container",
-		RuntimeEnablement: "specialresource.openshift.io/runtime-enablement",
-		DevicePlugin:      "specialresource.openshift.io/device-plugin",
-		DeviceMonitoring:  "specialresource.openshift.io/device-monitoring",
-	},
+	StateName: newResourceStateName(defaultStateLabelPrefix),
 }
 
 func logRuntimeInformation() {
@@ -91,40 +104,74 @@ func logRuntimeInformation() {
 	log.Info("Runtime Information", "KernelVersion", runInfo.KernelVersion)
 	log.Info("Runtime Information", "ClusterVersion", runInfo.ClusterVersion)
 	log.Info("Runtime Information", "ClusterVersionMajorMinor", runInfo.ClusterVersionMajorMinor)
-	log.Info("Runtime Information", "UpdateVendor", runInfo.UpdateVendor)
 	log.Info("Runtime Information", "PushSecretName", runInfo.PushSecretName)
 	log.Info("Runtime Information", "OSImageURL", runInfo.OSImageURL)
 	log.Info("Runtime Information", "Proxy", runInfo.Proxy)
+	log.Info("Runtime Information", "Monitoring", runInfo.Monitoring)
+	log.Info("Runtime Information", "BuildBackend", runInfo.BuildBackend)
+	log.Info("Runtime Information", "Partition", runInfo.Partition)
+	log.Info("Runtime Information", "Mirrors", runInfo.Mirrors)
+	log.Info("Runtime Information", "InsecureRegistries", runInfo.InsecureRegistries)
+	log.Info("Runtime Information", "Capabilities", runInfo.Capabilities)
+	log.Info("Runtime Information", "Available", runInfo.Available)
+	log.Info("Runtime Information", "DriverImage", runInfo.DriverImage)
+	log.Info("Runtime Information", "DriverImagePrebuilt", runInfo.DriverImagePrebuilt)
+	log.Info("Runtime Information", "Values", runInfo.Values)
+	log.Info("Runtime Information", "Config", runInfo.Config)
 }
 
-func getRuntimeInformation(r *SpecialResourceReconciler) {
+func getRuntimeInformation(r *SpecialResourceReconciler) error {
 
 	var err error
-	log.Info("Get Operating System")
-	runInfo.OperatingSystemMajor, runInfo.OperatingSystemMajorMinor, runInfo.OperatingSystemDecimal, err = getOperatingSystem()
-	exitOnError(errs.Wrap(err, "Failed to get operating system"))
 
-	log.Info("Get Kernel Version")
-	runInfo.KernelVersion, err = getKernelVersion()
-	exitOnError(errs.Wrap(err, "Failed to get kernel version"))
+	log.Info("Get Capabilities")
+	if runInfo.Capabilities, err = getCapabilities(); err != nil {
+		return errs.Wrap(err, "Failed to get capabilities")
+	}
+
+	r.specialresource.DeepCopyInto(&runInfo.SpecialResource)
+	// Set once the recipe is parsed
+	runInfo.Config = nil
 
-	log.Info("Get Cluster Version")
-	runInfo.ClusterVersion, runInfo.ClusterVersionMajorMinor, err = getClusterVersion()
-	exitOnError(errs.Wrap(err, "Failed to get cluster version"))
+	runInfo.StateLabelPrefix = r.StateLabelPrefix
+	if runInfo.StateLabelPrefix == "" {
+		runInfo.StateLabelPrefix = defaultStateLabelPrefix
+	}
+	runInfo.StateName = newResourceStateName(runInfo.StateLabelPrefix)
 
-	log.Info("Get Push Secret Name")
-	runInfo.PushSecretName, err = getPushSecretName(r)
-	exitOnError(errs.Wrap(err, "Failed to get push secret name"))
+	runInfo.Partition = ""
+	if node.partition != nil {
+		runInfo.Partition = node.partition.ID
+	}
 
-	log.Info("Get OS Image URL")
-	runInfo.OSImageURL, err = getOSImageURL(r)
-	exitOnError(errs.Wrap(err, "Failed to get OSImageURL"))
+	pins := map[string]string{}
+	for _, pin := range r.specialresource.Spec.RuntimeInformation {
+		pins[pin.Name] = pin.Value
+	}
 
-	log.Info("Get Proxy Configuration")
-	runInfo.Proxy, err = getProxyConfiguration(r)
-	exitOnError(errs.Wrap(err, "Failed to get Proxy Configuration"))
+	if err := runRuntimeProviders(r, pins); err != nil {
+		return err
+	}
 
-	r.specialresource.DeepCopyInto(&runInfo.SpecialResource)
+	runInfo.BuildBackend = getBuildBackend()
+
+	log.Info("Get Mirrors")
+	if runInfo.Mirrors, runInfo.ClusterMirrors, err = getMirrors(r); err != nil {
+		return errs.Wrap(err, "Failed to get mirrors")
+	}
+
+	log.Info("Get Insecure Registries")
+	if runInfo.InsecureRegistries, err = getInsecureRegistries(r); err != nil {
+		return errs.Wrap(err, "Failed to get insecure registries")
+	}
+
+	log.Info("Get Driver Image")
+	runInfo.DriverImage, runInfo.DriverImagePrebuilt, err = getDriverImage(r)
+	if err != nil {
+		return errs.Wrap(err, "Failed to get driver image")
+	}
+
+	return nil
 }
 
 func getOperatingSystem() (string, string, string, error) {
@@ -132,16 +179,19 @@ func getOperatingSystem() (string, string, string, error) {
 	var nodeOSrel string
 	var nodeOSmaj string
 	var nodeOSmin string
+	var nodeRHEL string
 
 	// Assuming all nodes are running the same os
 
 	os := "feature.node.kubernetes.io/system-os_release"
 
-	for _, node := range node.list.Items {
+	for _, node := range selectedNodes() {
 		labels := node.GetLabels()
 		nodeOSrel = labels[os+".ID"]
 		nodeOSmaj = labels[os+".VERSION_ID.major"]
 		nodeOSmin = labels[os+".VERSION_ID.minor"]
+		// Only set if NFD is configured to expose RHEL_VERSION of os-release
+		nodeRHEL = labels[os+".RHEL_VERSION"]
 
 		if len(nodeOSrel) == 0 || len(nodeOSmaj) == 0 {
 			return "", "", "", errs.New("Cannot extract " + os + ".*, is NFD running? Check node labels")
@@ -149,39 +199,40 @@ func getOperatingSystem() (string, string, string, error) {
 		break
 	}
 
-	return renderOperatingSystem(nodeOSrel, nodeOSmaj, nodeOSmin)
-}
-
-func renderOperatingSystem(rel string, maj string, min string) (string, string, string, error) {
-
-	log.Info("OS", "rel", rel)
-	log.Info("OS", "maj", maj)
-	log.Info("OS", "min", min) // this can be empty e.g fedora30
+	log.Info("OS", "rel", nodeOSrel)
+	log.Info("OS", "maj", nodeOSmaj)
+	log.Info("OS", "min", nodeOSmin) // this can be empty e.g fedora30
+	log.Info("OS", "rhel", nodeRHEL)
 
-	// rhcos version is the openshift version running need to translate
-	// into rhel major minor version
-	if strings.Compare(rel, "rhcos") == 0 {
-		rel := "rhel"
+	var overrides map[string]string
+	if nodeOSrel == "rhcos" && nodeRHEL == "" {
+		var err error
+		if overrides, err = getRHCOSOverrides(); err != nil {
+			return "", "", "", err
+		}
+	}
 
-		num, _ := strconv.Atoi(min)
+	return renderOperatingSystem(nodeOSrel, nodeOSmaj, nodeOSmin, nodeRHEL, overrides)
+}
 
-		if strings.Compare(maj, "4") == 0 && num < 4 {
-			maj := "8"
-			return rel + maj, rel + maj + ".0", maj + ".0", nil
-		}
+// renderOperatingSystem returns e.g. rhel8, rhel8.2, 8.2. RHCOS is versioned
+// like OpenShift and is translated into the RHEL version it is built from,
+// either from the RHEL_VERSION of os-release or the rhcosRHELVersions table.
+func renderOperatingSystem(rel string, maj string, min string, rhel string, overrides map[string]string) (string, string, string, error) {
 
-		if strings.Compare(maj, "4") == 0 && strings.Compare(min, "4") == 0 {
-			maj := "8"
-			return rel + maj, rel + maj + ".1", maj + ".1", nil
+	if rel == "rhcos" {
+		if rhel == "" {
+			var err error
+			if rhel, err = rhelVersionFor(maj+"."+min, overrides); err != nil {
+				return "", "", "", err
+			}
 		}
 
-		if strings.Compare(maj, "4") == 0 && strings.Compare(min, "5") == 0 {
-			maj := "8"
-			return rel + maj, rel + maj + ".2", maj + ".2", nil
+		s := strings.SplitN(rhel, ".", 2)
+		if len(s) != 2 {
+			return "", "", "", errs.New("Invalid RHEL version " + rhel + " for rhcos " + maj + "." + min)
 		}
-
-		maj := "8"
-		return rel + maj, rel + maj + ".2", maj + ".2", nil
+		rel, maj, min = "rhel", s[0], s[1]
 	}
 
 	// A Fedora system has no min yet, so if min is empty
@@ -200,7 +251,7 @@ func getKernelVersion() (string, error) {
 	var kernelVersion string
 	// Assuming all nodes are running the same kernel version,
 	// one could easily add driver-kernel-versions for each node.
-	for _, node := range node.list.Items {
+	for _, node := range selectedNodes() {
 		labels := node.GetLabels()
 
 		// We only need to check for the key, the value
@@ -283,8 +334,14 @@ func getOSImageURL(r *SpecialResourceReconciler) (string, error) {
 		return "", errs.Wrap(err, "ConfigMap machine-config-osimageurl -n  openshift-machine-config-operator not found")
 	}
 
+	if err != nil {
+		return "", errs.Wrap(err, "Cannot get ConfigMap machine-config-osimageurl")
+	}
+
 	osImageURL, found, err := unstructured.NestedString(cm.Object, "data", "osImageURL")
-	exitOnErrorOrNotFound(found, err)
+	if err != nil || !found {
+		return "", errs.New("Cannot extract osImageURL from ConfigMap machine-config-osimageurl")
+	}
 
 	return osImageURL, nil
 
@@ -337,89 +394,3 @@ func getProxyConfiguration(r *SpecialResourceReconciler) (proxyConfiguration, er
 
 	return proxy, nil
 }
-
-func setupProxy(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
-
-	if strings.Compare(obj.GetKind(), "Pod") == 0 {
-		if err := setupPodProxy(obj, r); err != nil {
-			return errs.Wrap(err, "Cannot setup Pod Proxy")
-		}
-	}
-	if strings.Compare(obj.GetKind(), "DaemonSet") == 0 {
-		if err := setupDaemonSetProxy(obj, r); err != nil {
-			return errs.Wrap(err, "Cannot setup DaemonSet Proxy")
-		}
-
-	}
-
-	return nil
-}
-
-// We may generalize more depending on how many entities need proxy settings.
-// path... -> Pod, DaemonSet, BuildConfig, etc.
-func setupDaemonSetProxy(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
-	containers, found, err := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
-	exitOnErrorOrNotFound(found, err)
-
-	if err := setupContainersProxy(containers); err != nil {
-		return errs.Wrap(err, "Cannot set proxy for Pod")
-	}
-
-	return nil
-}
-
-func setupPodProxy(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
-
-	containers, found, err := unstructured.NestedSlice(obj.Object, "spec", "containers")
-	exitOnErrorOrNotFound(found, err)
-
-	if err := setupContainersProxy(containers); err != nil {
-		return errs.Wrap(err, "Cannot set proxy for Pod")
-	}
-
-	return nil
-}
-
-func setupContainersProxy(containers []interface{}) error {
-
-	for _, container := range containers {
-		switch container := container.(type) {
-		case map[string]interface{}:
-			env, found, err := unstructured.NestedSlice(container, "env")
-			exitOnError(err)
-
-			// If env not found we are creating a new env slice
-			// otherwise we're appending it to the existing env slice
-			httpproxy := make(map[string]interface{})
-			httpsproxy := make(map[string]interface{})
-			noproxy := make(map[string]interface{})
-
-			httpproxy["name"] = "HTTP_PROXY"
-			httpproxy["value"] = runInfo.Proxy.HttpProxy
-
-			httpsproxy["name"] = "HTTPS_PROXY"
-			httpsproxy["value"] = runInfo.Proxy.HttpsProxy
-
-			noproxy["name"] = "NO_PROXY"
-			noproxy["value"] = runInfo.Proxy.NoProxy
-
-			if !found {
-				env = make([]interface{}, 0)
-			}
-
-			env = append(env, httpproxy)
-			env = append(env, httpsproxy)
-			env = append(env, noproxy)
-
-			if err := unstructured.SetNestedSlice(container, env, "env"); err != nil {
-				errs.Wrap(err, "Cannot set env for container")
-			}
-
-		default:
-			log.Info("container", "DEFAULT NOT THE CORRECT TYPE", container)
-		}
-		break
-	}
-
-	return nil
-}
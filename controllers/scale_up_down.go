@@ -2,76 +2,137 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 
 	"fmt"
 
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// If resource available, label the nodes according to the current state
-// if e.g driver-container ready -> specialresource.openshift.io/driver-container:ready
-func labelNodesAccordingToState(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+// stateHook keeps the state labels in sync after every apply, not only
+// once the DaemonSet is available, so labels of nodes whose Pod failed are
+// removed even while the DaemonSet is not available
+type stateHook struct{}
+
+func (stateHook) Phases() []hookPhase { return []hookPhase{hookPostApply, hookReady} }
+
+func (stateHook) Run(phase hookPhase, obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error {
+	return labelNodesAccordingToState(obj, params["state"], r)
+}
+
+// Label each node according to the state of its Pod, e.g. if the
+// driver-container Pod on the node is Ready -> specialresource.openshift.io/driver-container-<name>:ready
+// and remove the label if the Pod is not Ready or gone
+func labelNodesAccordingToState(obj *unstructured.Unstructured, state string, r *SpecialResourceReconciler) error {
 
 	if obj.GetKind() != "DaemonSet" {
 		return nil
 	}
 
-	cacheNodes(r, true)
+	if state == "" {
+		return nil
+	}
+	labels := map[string]string{stateLabel(runInfo.StateLabelPrefix, state, r.specialresource.Name): "ready"}
 
-	hw := r.specialresource.Name
-	st := runInfo.StateName
+	if _, err := cacheNodes(r, true); err != nil {
+		return err
+	}
 
-	var stateLabels = map[string]map[string]string{
-		"driver-container":   {st.DriverContainer + "-" + hw: "ready"},
-		"runtime-enablement": {st.RuntimeEnablement + "-" + hw: "ready"},
-		"device-plugin":      {st.DevicePlugin + "-" + hw: "ready"},
-		"device-monitoring":  {st.DeviceMonitoring + "-" + hw: "ready"},
+	pods, err := daemonSetPods(obj)
+	if err != nil {
+		return err
 	}
+	ready := readyNodes(pods)
 
-	for _, node := range node.list.Items {
-		labels := node.GetLabels()
+	anyReady := false
 
-		state := obj.GetAnnotations()["specialresource.openshift.io/state"]
+	for _, node := range node.list.Items {
+		// The DaemonSet of a partition only runs on its nodes
+		if !inPartition(node) {
+			continue
+		}
 
-		stateLabel, found := stateLabels[state]
-		if !found {
-			return nil
+		if ready[node.GetName()] {
+			anyReady = true
 		}
 
-		for k := range stateLabel {
+		for k := range labels {
 
-			_, found := labels[k]
-			if found {
-				log.Info("Label", "found", stateLabel, "on ", node.GetName())
-				updateStatus(obj, r, stateLabel)
+			patch, needed, err := nodeStateLabelPatch(node, k, ready[node.GetName()])
+			if err != nil {
+				return err
+			}
+			if !needed {
 				continue
 			}
-			// Label missing update the Node to advance to the next state
-			updated := node.DeepCopy()
-
-			labels[k] = "ready"
 
-			updated.SetLabels(labels)
-
-			err := r.Update(context.TODO(), updated)
+			err = r.Patch(context.TODO(), &node, client.RawPatch(types.MergePatchType, patch))
 			if apierrors.IsForbidden(err) {
 				return fmt.Errorf("Forbidden check Role, ClusterRole and Bindings for operator %s", err)
 			}
-			if apierrors.IsConflict(err) {
-				cacheNodes(r, true)
-				return fmt.Errorf("Node Conflict Label %s err %s", stateLabel, err)
-			}
-
 			if err != nil {
-				log.Error(err, "Node Update", "label", stateLabel)
-				return fmt.Errorf("Couldn't Update Node")
+				log.Error(err, "Node Patch", "label", k)
+				return errs.Wrap(err, "Couldn't patch Node "+node.GetName())
 			}
 
-			log.Info("NODE", "Setting Label ", stateLabel, "on ", updated.GetName())
-
-			updateStatus(obj, r, stateLabel)
+			log.Info("NODE", "Label", k, "Ready", ready[node.GetName()], "on ", node.GetName())
 		}
 	}
+
+	if anyReady {
+		updateStatus(obj, r, labels)
+	}
+
 	return nil
 }
+
+// readyNodes returns the nodes with a Ready Pod that is not terminating
+func readyNodes(pods []corev1.Pod) map[string]bool {
+
+	ready := map[string]bool{}
+
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready[pod.Spec.NodeName] = true
+			}
+		}
+	}
+
+	return ready
+}
+
+// nodeStateLabelPatch returns a merge patch that sets or removes the state
+// label, false if the node is already labelled accordingly
+func nodeStateLabelPatch(node unstructured.Unstructured, label string, ready bool) ([]byte, bool, error) {
+
+	_, labelled := node.GetLabels()[label]
+	if labelled == ready {
+		return nil, false, nil
+	}
+
+	// A null value removes the label
+	var value interface{}
+	if ready {
+		value = "ready"
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{label: value},
+		},
+	})
+	if err != nil {
+		return nil, false, errs.Wrap(err, "Cannot marshal label patch")
+	}
+
+	return patch, true, nil
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPod(node string, ready corev1.ConditionStatus) corev1.Pod {
+	return corev1.Pod{
+		Spec: corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+			{Type: corev1.PodReady, Status: ready},
+		}},
+	}
+}
+
+func TestReadyNodes(t *testing.T) {
+
+	terminating := newPod("worker-3", corev1.ConditionTrue)
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+
+	ready := readyNodes([]corev1.Pod{
+		newPod("worker-1", corev1.ConditionTrue),
+		newPod("worker-2", corev1.ConditionFalse),
+		terminating,
+		newPod("", corev1.ConditionTrue),
+	})
+
+	if len(ready) != 1 || !ready["worker-1"] {
+		t.Errorf("readyNodes = %v, want only worker-1", ready)
+	}
+}
+
+func TestNodeStateLabelPatch(t *testing.T) {
+
+	label := "specialresource.openshift.io/driver-container-nvidia-gpu"
+
+	labelled := newNode("worker-1", map[string]string{label: "ready"})
+	unlabelled := newNode("worker-2", map[string]string{})
+
+	tests := []struct {
+		labelled bool
+		ready    bool
+		needed   bool
+		patch    string
+	}{
+		{true, true, false, ""},
+		{false, true, true, `{"metadata":{"labels":{"` + label + `":"ready"}}}`},
+		{true, false, true, `{"metadata":{"labels":{"` + label + `":null}}}`},
+		{false, false, false, ""},
+	}
+
+	for _, test := range tests {
+		n := unlabelled
+		if test.labelled {
+			n = labelled
+		}
+		patch, needed, err := nodeStateLabelPatch(n, label, test.ready)
+		if err != nil {
+			t.Fatalf("nodeStateLabelPatch failed: %v", err)
+		}
+		if needed != test.needed || string(patch) != test.patch {
+			t.Errorf("nodeStateLabelPatch(labelled=%v, ready=%v) = %s, %v, want %s, %v",
+				test.labelled, test.ready, patch, needed, test.patch, test.needed)
+		}
+	}
+}
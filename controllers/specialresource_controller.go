@@ -35,11 +35,19 @@ var (
 // SpecialResourceReconciler reconciles a SpecialResource object
 type SpecialResourceReconciler struct {
 	client.Client
-	Log             logr.Logger
-	Scheme          *runtime.Scheme
-	specialresource srov1beta1.SpecialResource
-	parent          srov1beta1.SpecialResource
-	dependency      srov1beta1.SpecialResourceDependency
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	BuildRegistry string
+	// StateLabelPrefix prefixes the state labels of nodes
+	StateLabelPrefix string
+	BuildPushSecret  string
+	// InsecureRegistries are looked up without verifying their certificate
+	InsecureRegistries []string
+	// ArtifactsToolsImage has a static /bin/busybox that copies artifacts
+	ArtifactsToolsImage string
+	specialresource     srov1beta1.SpecialResource
+	parent              srov1beta1.SpecialResource
+	dependency          srov1beta1.SpecialResourceDependency
 }
 
 func (r *SpecialResourceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
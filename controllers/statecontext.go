@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// stateContext is what a state running concurrently to other states keeps
+// to itself, it is swapped into the globals while the state holds stateLock
+type stateContext struct {
+	runInfo  runtimeInformation
+	rendered []*unstructured.Unstructured
+	jobHooks []*unstructured.Unstructured
+}
+
+var (
+	// stateLock serializes the goroutines of concurrent states, a state
+	// only releases it while polling i.e. waiting for its objects
+	stateLock sync.Mutex
+	// currentState holds stateLock, nil if no states run concurrently
+	currentState *stateContext
+)
+
+// newStateContext copies the runtime information and hook Jobs of the
+// recipe we are reconciling
+func newStateContext() *stateContext {
+	return &stateContext{
+		runInfo:  runInfo,
+		rendered: []*unstructured.Unstructured{},
+		jobHooks: append([]*unstructured.Unstructured{}, jobHooks...),
+	}
+}
+
+func enterState(ctx *stateContext) {
+
+	if ctx == nil {
+		return
+	}
+	stateLock.Lock()
+	currentState = ctx
+	runInfo, renderedObjects, jobHooks = ctx.runInfo, ctx.rendered, ctx.jobHooks
+}
+
+func leaveState() *stateContext {
+
+	ctx := currentState
+	if ctx == nil {
+		return nil
+	}
+	ctx.runInfo, ctx.rendered, ctx.jobHooks = runInfo, renderedObjects, jobHooks
+	currentState = nil
+	stateLock.Unlock()
+	return ctx
+}
+
+// pollState is wait.Poll that lets other states run between conditions
+func pollState(interval time.Duration, timeout time.Duration, condition wait.ConditionFunc) error {
+
+	ctx := leaveState()
+	defer enterState(ctx)
+
+	return wait.Poll(interval, timeout, func() (bool, error) {
+		enterState(ctx)
+		defer leaveState()
+		return condition()
+	})
+}
+
+// runStates calls execute for states 0 to n-1 concurrently and returns
+// once all are done. The hook Jobs registered by the states apply to all
+// states run afterwards.
+func runStates(n int, execute func(i int)) {
+
+	base := runInfo
+	hooks := jobHooks
+
+	// Copied before any state runs and swaps the globals
+	contexts := make([]*stateContext, n)
+	for i := range contexts {
+		contexts[i] = newStateContext()
+	}
+
+	var wg sync.WaitGroup
+	for i, ctx := range contexts {
+		wg.Add(1)
+		go func(i int, ctx *stateContext) {
+			defer wg.Done()
+			enterState(ctx)
+			defer leaveState()
+			execute(i)
+		}(i, ctx)
+	}
+	wg.Wait()
+
+	runInfo = base
+	renderedObjects = []*unstructured.Unstructured{}
+	jobHooks = hooks
+	for _, ctx := range contexts {
+		for _, h := range ctx.jobHooks {
+			addJobHook(h)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRunStates(t *testing.T) {
+
+	defer func() {
+		runInfo.Partition = ""
+		jobHooks = []*unstructured.Unstructured{}
+	}()
+	runInfo.Partition = "amd64-1c9e2f4b"
+	jobHooks = []*unstructured.Unstructured{}
+
+	hook := func(name string) *unstructured.Unstructured {
+		job := &unstructured.Unstructured{}
+		job.SetKind("Job")
+		job.SetName(name)
+		return job
+	}
+
+	// Each state waits for the other, serial states would time out
+	polled := make([]bool, 2)
+	failures := make([]error, 2)
+
+	runStates(2, func(i int) {
+		runInfo.Partition = "state-" + string(rune('a'+i))
+		addJobHook(hook(runInfo.Partition))
+
+		failures[i] = pollState(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			polled[i] = true
+			return polled[1-i], nil
+		})
+
+		// The globals are the ones of this state again
+		if runInfo.Partition != "state-"+string(rune('a'+i)) || len(jobHooks) != 1 {
+			t.Errorf("state %d resumed with Partition %s and %d hook Jobs", i, runInfo.Partition, len(jobHooks))
+		}
+	})
+
+	for i, err := range failures {
+		if err != nil {
+			t.Errorf("state %d did not run concurrently: %v", i, err)
+		}
+	}
+	if runInfo.Partition != "amd64-1c9e2f4b" {
+		t.Errorf("runInfo.Partition = %s after the states, want it restored", runInfo.Partition)
+	}
+	if len(jobHooks) != 2 || jobHooks[0].GetName() != "state-a" || jobHooks[1].GetName() != "state-b" {
+		t.Errorf("jobHooks = %v, want the hook Jobs of both states", jobHooks)
+	}
+	if currentState != nil {
+		t.Errorf("currentState = %v after the states", currentState)
+	}
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"html/template"
+)
+
+// defaultStateLabelPrefix is used if the operator is not started with
+// --state-label-prefix
+const defaultStateLabelPrefix = "specialresource.openshift.io"
+
+// stateLabel is the node label set once the Pod of state is Ready on the
+// node e.g. specialresource.openshift.io/driver-container-nvidia-gpu, any
+// value of the specialresource.openshift.io/state annotation is a state
+func stateLabel(prefix string, state string, specialresource string) string {
+	if prefix == "" {
+		prefix = defaultStateLabelPrefix
+	}
+	return prefix + "/" + state + "-" + specialresource
+}
+
+// newResourceStateName keeps {{.StateName.DriverContainer}} working for
+// recipes written before states were derived from the annotation
+func newResourceStateName(prefix string) resourceStateName {
+	if prefix == "" {
+		prefix = defaultStateLabelPrefix
+	}
+	return resourceStateName{
+		DriverContainer:   prefix + "/driver-container",
+		RuntimeEnablement: prefix + "/runtime-enablement",
+		DevicePlugin:      prefix + "/device-plugin",
+		DeviceMonitoring:  prefix + "/device-monitoring",
+	}
+}
+
+// templateFuncs are available in recipes, e.g. a state that runs after the
+// csi-driver state selects the nodes with
+//
+//   - key: {{stateLabel "csi-driver"}}
+func (ri runtimeInformation) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"stateLabel": func(state string) string {
+			return stateLabel(ri.StateLabelPrefix, state, ri.SpecialResource.Name)
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestStateLabel(t *testing.T) {
+
+	if got := stateLabel("", "driver-container", "nvidia-gpu"); got != "specialresource.openshift.io/driver-container-nvidia-gpu" {
+		t.Errorf("stateLabel with default prefix = %s", got)
+	}
+	if got := stateLabel("example.com", "csi-driver", "lustre"); got != "example.com/csi-driver-lustre" {
+		t.Errorf("stateLabel with prefix = %s", got)
+	}
+}
+
+func TestStateLabelTemplate(t *testing.T) {
+
+	ri := runtimeInformation{StateLabelPrefix: "example.com"}
+	ri.SpecialResource.Name = "lustre"
+	ri.StateName = newResourceStateName(ri.StateLabelPrefix)
+
+	spec := []byte(`key: {{stateLabel "csi-driver"}}
+legacy: {{.StateName.DriverContainer}}-{{.SpecialResource.Name}}`)
+	if err := templateRuntimeInformation(&spec, ri); err != nil {
+		t.Fatalf("Cannot render: %v", err)
+	}
+
+	want := "key: example.com/csi-driver-lustre\nlegacy: example.com/driver-container-lustre"
+	if string(spec) != want {
+		t.Errorf("rendered %q, want %q", spec, want)
+	}
+}
@@ -3,6 +3,9 @@ package controllers
 import (
 	"context"
 
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -22,3 +25,54 @@ func updateStatus(obj *unstructured.Unstructured, r *SpecialResourceReconciler,
 		return
 	}
 }
+
+// updateNodeStatus replaces the results of check for object on all nodes
+func updateNodeStatus(object string, check string, nodes []srov1beta1.SpecialResourceNodeStatus, r *SpecialResourceReconciler) {
+
+	statuses := []srov1beta1.SpecialResourceNodeStatus{}
+	for _, n := range r.specialresource.Status.Nodes {
+		if n.Object != object || n.Check != check {
+			statuses = append(statuses, n)
+		}
+	}
+	r.specialresource.Status.Nodes = append(statuses, nodes...)
+
+	if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+		log.Error(err, "Failed to update SpecialResource node status")
+	}
+}
+
+// setCondition sets condition in status, SetStatusCondition does not update
+// the ObservedGeneration of an existing condition
+func setCondition(condition metav1.Condition, r *SpecialResourceReconciler) {
+	meta.SetStatusCondition(&r.specialresource.Status.Conditions, condition)
+	meta.FindStatusCondition(r.specialresource.Status.Conditions, condition.Type).ObservedGeneration = condition.ObservedGeneration
+}
+
+// setValidCondition sets conditionType to True if err is nil and to False
+// with err as message otherwise, status is only updated on changes
+func setValidCondition(conditionType string, err error, r *SpecialResourceReconciler) {
+
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Valid",
+		ObservedGeneration: r.specialresource.GetGeneration(),
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	}
+
+	previous := meta.FindStatusCondition(r.specialresource.Status.Conditions, conditionType)
+	if previous != nil && previous.Status == condition.Status && previous.Message == condition.Message &&
+		previous.ObservedGeneration == condition.ObservedGeneration {
+		return
+	}
+	setCondition(condition, r)
+
+	if err := r.Status().Update(context.TODO(), &r.specialresource); err != nil {
+		log.Error(err, "Failed to update SpecialResource condition", "Type", conditionType)
+	}
+}
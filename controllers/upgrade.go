@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Phases of a node during a driver-container upgrade, the phase is kept in
+// a node annotation so an upgrade continues after the operator restarted
+const (
+	upgradeCordon      = "Cordon"
+	upgradeWorkloads   = "Workloads"
+	upgradeReplace     = "Replace"
+	upgradeValidate    = "Validate"
+	upgradeUncordon    = "Uncordon"
+	upgradeDone        = "Done"
+	podTemplateGenLbl  = "pod-template-generation"
+	upgradeAnnotation  = "specialresource.openshift.io/upgrade-"
+	cordonedAnnotation = "specialresource.openshift.io/upgrade-cordoned-"
+)
+
+// templateGenAnnotation is the generation of the Pod template of a
+// DaemonSet, metadata.generation also counts changes outside the template
+// e.g. to the updateStrategy
+const templateGenAnnotation = "deprecated.daemonset.template.generation"
+
+// upgradeHook is configured on the driver-container DaemonSet, it switches
+// the DaemonSet to OnDelete before it is applied and upgrades the nodes
+// whose Pod is outdated after
+type upgradeHook struct{}
+
+func (upgradeHook) Phases() []hookPhase { return []hookPhase{hookPreApply, hookPostApply} }
+
+func (upgradeHook) Run(phase hookPhase, obj *unstructured.Unstructured, params map[string]string, r *SpecialResourceReconciler) error {
+
+	if obj.GetKind() != "DaemonSet" || r.specialresource.Spec.Upgrade == nil {
+		return nil
+	}
+
+	if phase == hookPreApply {
+		return unstructured.SetNestedField(obj.Object, "OnDelete", "spec", "updateStrategy", "type")
+	}
+
+	return upgradeDaemonSet(obj, r)
+}
+
+// upgradeNode is a node running a Pod of the DaemonSet
+type upgradeNode struct {
+	name          string
+	unschedulable bool
+	annotations   map[string]string
+	pod           *corev1.Pod
+}
+
+// upgradeDaemonSet advances every node that is upgrading and starts the
+// upgrade of outdated nodes while less than maxUnavailable are upgrading.
+// It fails while nodes are upgrading so the next states wait for the driver.
+func upgradeDaemonSet(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+
+	upgrade := r.specialresource.Spec.Upgrade
+
+	found := obj.DeepCopy()
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found); err != nil {
+		return errs.Wrap(err, "Cannot get DaemonSet "+obj.GetName())
+	}
+	generation, err := templateGeneration(found)
+	if err != nil {
+		return err
+	}
+
+	key := upgradeAnnotation + r.specialresource.Name
+
+	nodes, err := getUpgradeNodes(found, key, r)
+	if err != nil {
+		return err
+	}
+
+	maxUnavailable, err := upgradeSlots(upgrade.MaxUnavailable, len(nodes))
+	if err != nil {
+		return err
+	}
+
+	upgrading := 0
+	for _, n := range nodes {
+		if n.annotations[key] != "" {
+			upgrading++
+		}
+	}
+
+	statuses := []srov1beta1.SpecialResourceNodeStatus{}
+
+	for _, n := range nodes {
+
+		phase := n.annotations[key]
+
+		if phase == "" && podOutdated(n.pod, generation) && upgrading < maxUnavailable {
+			log.Info("Upgrading driver-container", "Node", n.name, "Generation", generation)
+			phase = upgradeCordon
+			upgrading++
+		}
+
+		if phase != "" {
+			if phase, err = advanceUpgrade(n, phase, generation, upgrade, r); err != nil {
+				return errs.Wrap(err, "Cannot upgrade node "+n.name)
+			}
+			if phase == upgradeDone {
+				upgrading--
+			}
+		}
+
+		status := srov1beta1.SpecialResourceNodeStatus{
+			Node:    n.name,
+			Object:  obj.GetKind() + "/" + obj.GetName(),
+			Check:   "upgrade",
+			Ready:   phase == "" || phase == upgradeDone,
+			Message: phase,
+		}
+		if phase == "" && podOutdated(n.pod, generation) {
+			status.Ready = false
+			status.Message = "Pending"
+		}
+		statuses = append(statuses, status)
+	}
+
+	updateNodeStatus(obj.GetKind()+"/"+obj.GetName(), "upgrade", statuses, r)
+
+	for _, s := range statuses {
+		if !s.Ready {
+			return errs.New("Upgrade of " + obj.GetName() + " in progress")
+		}
+	}
+
+	return nil
+}
+
+// advanceUpgrade runs the phases of a node until one has to wait, the
+// returned phase is stored on the node
+func advanceUpgrade(n upgradeNode, phase string, generation string, upgrade *srov1beta1.SpecialResourceUpgrade, r *SpecialResourceReconciler) (string, error) {
+
+	key := upgradeAnnotation + r.specialresource.Name
+	cordoned := cordonedAnnotation + r.specialresource.Name
+
+	for {
+		log.Info("Upgrade", "Node", n.name, "Phase", phase)
+		next := phase
+
+		switch phase {
+		case upgradeCordon:
+			// Nodes cordoned by the admin stay cordoned after the upgrade.
+			// The phase is stored with the cordon, a failure until the next
+			// phase resumes here instead of leaving the node cordoned.
+			if !n.unschedulable && (upgrade.Cordon == nil || *upgrade.Cordon) {
+				cordon := true
+				if err := patchNode(n.name, &cordon, map[string]interface{}{cordoned: "true", key: phase}); err != nil {
+					return phase, err
+				}
+				n.annotations[cordoned] = "true"
+			}
+			next = upgradeWorkloads
+
+		case upgradeWorkloads:
+			workloads, err := nodeWorkloads(n.name, upgrade.ResourceName)
+			if err != nil {
+				return phase, err
+			}
+			if len(workloads) == 0 {
+				next = upgradeReplace
+				break
+			}
+			if !upgrade.Drain {
+				log.Info("Waiting for workloads to finish", "Node", n.name, "Pods", len(workloads))
+				break
+			}
+			for _, pod := range workloads {
+				if err := evictPod(pod); err != nil {
+					return phase, err
+				}
+			}
+
+		case upgradeReplace:
+			if n.pod != nil && podOutdated(n.pod, generation) {
+				if err := kubeclient.CoreV1().Pods(n.pod.Namespace).Delete(context.TODO(), n.pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+					return phase, errs.Wrap(err, "Cannot delete Pod "+n.pod.Name)
+				}
+			}
+			next = upgradeValidate
+
+		case upgradeValidate:
+			pod, err := nodeDaemonSetPod(n, generation)
+			if err != nil {
+				return phase, err
+			}
+			if pod != nil && len(readyNodes([]corev1.Pod{*pod})) == 1 {
+				next = upgradeUncordon
+			}
+
+		case upgradeUncordon:
+			var uncordon *bool
+			if n.annotations[cordoned] == "true" {
+				uncordon = new(bool)
+			}
+			if err := patchNode(n.name, uncordon, map[string]interface{}{key: nil, cordoned: nil}); err != nil {
+				return phase, err
+			}
+			return upgradeDone, nil
+
+		default:
+			return phase, errs.New("Unknown upgrade phase " + phase)
+		}
+
+		if next == phase {
+			return phase, patchNode(n.name, nil, map[string]interface{}{key: phase})
+		}
+		phase = next
+	}
+}
+
+// getUpgradeNodes returns the nodes running a Pod of the DaemonSet and
+// nodes that are upgrading whose Pod is being replaced, the phase of a node
+// is kept in the annotation key
+func getUpgradeNodes(ds *unstructured.Unstructured, key string, r *SpecialResourceReconciler) ([]upgradeNode, error) {
+
+	// The phases are annotations, the cached nodes are outdated
+	list, err := cacheNodes(r, true)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := daemonSetPods(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	byNode := map[string]*corev1.Pod{}
+	for i := range pods {
+		if pods[i].Spec.NodeName != "" && pods[i].DeletionTimestamp == nil {
+			byNode[pods[i].Spec.NodeName] = &pods[i]
+		}
+	}
+
+	nodes := []upgradeNode{}
+	for _, n := range list.Items {
+		if !inPartition(n) {
+			continue
+		}
+		pod, running := byNode[n.GetName()]
+		annotations := n.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		if _, upgrading := annotations[key]; !running && !upgrading {
+			continue
+		}
+		unschedulable, _, _ := unstructured.NestedBool(n.Object, "spec", "unschedulable")
+		nodes = append(nodes, upgradeNode{
+			name:          n.GetName(),
+			unschedulable: unschedulable,
+			annotations:   annotations,
+			pod:           pod,
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+
+	return nodes, nil
+}
+
+// upgradeSlots resolves maxUnavailable for total nodes, at least one node
+// is upgraded at a time
+func upgradeSlots(maxUnavailable *intstr.IntOrString, total int) (int, error) {
+
+	if maxUnavailable == nil {
+		return 1, nil
+	}
+
+	n, err := intstr.GetValueFromIntOrPercent(maxUnavailable, total, false)
+	if err != nil {
+		return 0, errs.Wrap(err, "Cannot resolve maxUnavailable")
+	}
+	if n < 1 {
+		return 1, nil
+	}
+
+	return n, nil
+}
+
+// templateGeneration returns the generation of the Pod template of a
+// DaemonSet, Pods of the current template have it as pod-template-generation
+func templateGeneration(ds *unstructured.Unstructured) (string, error) {
+
+	generation := ds.GetAnnotations()[templateGenAnnotation]
+	if generation == "" {
+		return "", errs.New("DaemonSet " + ds.GetName() + " has no " + templateGenAnnotation + " yet")
+	}
+	return generation, nil
+}
+
+// podOutdated is true if the Pod was created for an older DaemonSet spec
+func podOutdated(pod *corev1.Pod, generation string) bool {
+	return pod != nil && pod.Labels[podTemplateGenLbl] != generation
+}
+
+// nodeDaemonSetPod returns the current Pod of the DaemonSet on the node
+func nodeDaemonSetPod(n upgradeNode, generation string) (*corev1.Pod, error) {
+
+	if n.pod == nil || podOutdated(n.pod, generation) {
+		return nil, nil
+	}
+	pod, err := kubeclient.CoreV1().Pods(n.pod.Namespace).Get(context.TODO(), n.pod.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot get Pod "+n.pod.Name)
+	}
+
+	return pod, nil
+}
+
+// nodeWorkloads returns the running Pods on the node that request the
+// special resource
+func nodeWorkloads(node string, resource string) ([]corev1.Pod, error) {
+
+	if resource == "" {
+		return nil, nil
+	}
+
+	pods, err := kubeclient.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot list Pods on node "+node)
+	}
+
+	workloads := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if podUsesResource(pod, corev1.ResourceName(resource)) {
+			workloads = append(workloads, pod)
+		}
+	}
+
+	return workloads, nil
+}
+
+func podUsesResource(pod corev1.Pod, resource corev1.ResourceName) bool {
+
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if _, found := c.Resources.Limits[resource]; found {
+			return true
+		}
+		if _, found := c.Resources.Requests[resource]; found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evictPod respects PodDisruptionBudgets, a blocked eviction is retried
+// in the next reconcile
+func evictPod(pod corev1.Pod) error {
+
+	log.Info("Evicting workload", "Namespace", pod.Namespace, "Pod", pod.Name, "Node", pod.Spec.NodeName)
+
+	eviction := &policyv1beta1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	err := kubeclient.PolicyV1beta1().Evictions(pod.Namespace).Evict(context.TODO(), eviction)
+	if apierrors.IsNotFound(err) || apierrors.IsTooManyRequests(err) {
+		return nil
+	}
+	if err != nil {
+		return errs.Wrap(err, "Cannot evict Pod "+pod.Name)
+	}
+
+	return nil
+}
+
+// patchNode sets spec.unschedulable unless nil and annotations, a nil
+// annotation is removed
+func patchNode(name string, unschedulable *bool, annotations map[string]interface{}) error {
+
+	node := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	}
+	if unschedulable != nil {
+		node["spec"] = map[string]interface{}{"unschedulable": *unschedulable}
+	}
+
+	patch, err := json.Marshal(node)
+	if err != nil {
+		return errs.Wrap(err, "Cannot marshal Node patch")
+	}
+
+	if _, err := kubeclient.CoreV1().Nodes().Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return errs.Wrap(err, "Cannot patch Node "+name)
+	}
+
+	return nil
+}
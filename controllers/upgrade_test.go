@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestUpgradeSlots(t *testing.T) {
+
+	percent := intstr.FromString("25%")
+	two := intstr.FromInt(2)
+	zero := intstr.FromInt(0)
+
+	tests := []struct {
+		maxUnavailable *intstr.IntOrString
+		total          int
+		want           int
+	}{
+		{nil, 10, 1},
+		{&two, 10, 2},
+		{&percent, 10, 2},
+		{&percent, 2, 1},
+		{&zero, 10, 1},
+	}
+
+	for _, test := range tests {
+		got, err := upgradeSlots(test.maxUnavailable, test.total)
+		if err != nil {
+			t.Errorf("upgradeSlots(%v, %d) failed: %v", test.maxUnavailable, test.total, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("upgradeSlots(%v, %d) = %d, want %d", test.maxUnavailable, test.total, got, test.want)
+		}
+	}
+}
+
+func TestPodOutdated(t *testing.T) {
+
+	pod := &corev1.Pod{}
+	pod.Labels = map[string]string{podTemplateGenLbl: "3"}
+
+	if podOutdated(pod, "3") {
+		t.Errorf("Pod of the current generation is not outdated")
+	}
+	if !podOutdated(pod, "4") {
+		t.Errorf("Pod of an older generation is outdated")
+	}
+	if podOutdated(nil, "4") {
+		t.Errorf("A missing Pod is not outdated")
+	}
+}
+
+func TestTemplateGeneration(t *testing.T) {
+
+	// Switching to OnDelete bumps metadata.generation but not the template
+	ds := &unstructured.Unstructured{}
+	ds.SetKind("DaemonSet")
+	ds.SetName("simple-kmod-driver-container")
+	ds.SetGeneration(4)
+	ds.SetAnnotations(map[string]string{templateGenAnnotation: "3"})
+
+	generation, err := templateGeneration(ds)
+	if err != nil {
+		t.Fatalf("templateGeneration failed: %v", err)
+	}
+	if generation != "3" {
+		t.Errorf("templateGeneration = %s, want 3", generation)
+	}
+
+	pod := &corev1.Pod{}
+	pod.Labels = map[string]string{podTemplateGenLbl: "3"}
+	if podOutdated(pod, generation) {
+		t.Errorf("Pod of the current template is outdated after a change outside the template")
+	}
+
+	ds.SetAnnotations(nil)
+	if _, err := templateGeneration(ds); err == nil {
+		t.Errorf("templateGeneration without annotation succeeded")
+	}
+}
+
+func TestPodUsesResource(t *testing.T) {
+
+	gpu := corev1.ResourceName("nvidia.com/gpu")
+
+	workload := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{gpu: resource.MustParse("1")}},
+	}}}}
+	if !podUsesResource(workload, gpu) {
+		t.Errorf("Pod with a GPU limit uses the GPU")
+	}
+
+	workload.Status.Phase = corev1.PodSucceeded
+	if podUsesResource(workload, gpu) {
+		t.Errorf("Completed Pod does not use the GPU anymore")
+	}
+
+	other := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+	}}}}
+	if podUsesResource(other, gpu) {
+		t.Errorf("Pod without a GPU request does not use the GPU")
+	}
+}
+
+func newUpgradePod(name string, node string, generation string, ready bool) *corev1.Pod {
+
+	pod := &corev1.Pod{}
+	pod.Name = name
+	pod.Namespace = "driver"
+	pod.Labels = map[string]string{podTemplateGenLbl: generation}
+	pod.Spec.NodeName = node
+	if ready {
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
+
+// loadUpgradeNode reads the node like getUpgradeNodes does at the start of
+// every reconcile
+func loadUpgradeNode(t *testing.T, name string, key string) (upgradeNode, string) {
+
+	n, err := kubeclient.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Cannot get Node %s: %v", name, err)
+	}
+	pods, err := kubeclient.CoreV1().Pods("driver").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Cannot list Pods: %v", err)
+	}
+
+	un := upgradeNode{name: name, unschedulable: n.Spec.Unschedulable, annotations: n.Annotations}
+	if un.annotations == nil {
+		un.annotations = map[string]string{}
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Spec.NodeName == name && pods.Items[i].Labels["app"] == "driver" {
+			un.pod = &pods.Items[i]
+		}
+	}
+
+	return un, un.annotations[key]
+}
+
+func TestAdvanceUpgrade(t *testing.T) {
+
+	gpu := corev1.ResourceName("nvidia.com/gpu")
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name          string
+		unschedulable bool
+		cordon        *bool
+		// failWorkloads fails the first lookup of workloads, right after
+		// the node was cordoned
+		failWorkloads bool
+		cordoned      bool
+	}{
+		{name: "cordon", cordoned: true},
+		{name: "explicit cordon", cordon: &enabled, cordoned: true},
+		{name: "cordoned by the admin", unschedulable: true, cordoned: true},
+		{name: "no cordon", cordon: &disabled},
+		{name: "resume after failure", failWorkloads: true, cordoned: true},
+	}
+
+	saved := kubeclient
+	defer func() { kubeclient = saved }()
+
+	for _, test := range tests {
+
+		worker := &corev1.Node{}
+		worker.Name = "worker-0"
+		worker.Spec.Unschedulable = test.unschedulable
+
+		driver := newUpgradePod("driver-old", worker.Name, "1", true)
+		driver.Labels["app"] = "driver"
+
+		workload := newUpgradePod("workload", worker.Name, "", true)
+		workload.Namespace = "default"
+		workload.Spec.Containers = []corev1.Container{{
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{gpu: resource.MustParse("1")}},
+		}}
+
+		clientset := fake.NewSimpleClientset(worker, driver, workload)
+		kubeclient = clientset
+
+		if test.failWorkloads {
+			failed := false
+			clientset.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				// Workloads are listed in all namespaces
+				if failed || action.GetNamespace() != "" {
+					return false, nil, nil
+				}
+				failed = true
+				return true, nil, errors.New("connection refused")
+			})
+		}
+
+		r := &SpecialResourceReconciler{}
+		r.specialresource.Name = "gpu"
+		key := upgradeAnnotation + r.specialresource.Name
+		upgrade := &srov1beta1.SpecialResourceUpgrade{ResourceName: string(gpu), Cordon: test.cordon}
+
+		reconcile := func(want string) {
+			t.Helper()
+			n, phase := loadUpgradeNode(t, worker.Name, key)
+			if phase == "" {
+				phase = upgradeCordon
+			}
+			got, err := advanceUpgrade(n, phase, "2", upgrade, r)
+			if err != nil {
+				t.Fatalf("%s: advanceUpgrade failed in %s: %v", test.name, phase, err)
+			}
+			if got != want {
+				t.Fatalf("%s: advanceUpgrade = %s, want %s", test.name, got, want)
+			}
+			if _, stored := loadUpgradeNode(t, worker.Name, key); got != upgradeDone && stored != got {
+				t.Fatalf("%s: phase %s not stored on the node, got %s", test.name, got, stored)
+			}
+		}
+
+		if test.failWorkloads {
+			n, _ := loadUpgradeNode(t, worker.Name, key)
+			if _, err := advanceUpgrade(n, upgradeCordon, "2", upgrade, r); err == nil {
+				t.Fatalf("%s: advanceUpgrade should fail to look up workloads", test.name)
+			}
+			if _, stored := loadUpgradeNode(t, worker.Name, key); stored != upgradeCordon {
+				t.Fatalf("%s: phase after failure = %s, want %s", test.name, stored, upgradeCordon)
+			}
+		}
+
+		// The workload keeps the node in Workloads across reconciles
+		reconcile(upgradeWorkloads)
+		if n, _ := loadUpgradeNode(t, worker.Name, key); n.unschedulable != test.cordoned {
+			t.Errorf("%s: unschedulable = %v while upgrading, want %v", test.name, n.unschedulable, test.cordoned)
+		}
+		reconcile(upgradeWorkloads)
+
+		workload.Status.Phase = corev1.PodSucceeded
+		if _, err := clientset.CoreV1().Pods("default").UpdateStatus(context.TODO(), workload, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Cannot update workload: %v", err)
+		}
+
+		// The outdated Pod is deleted, the DaemonSet has not created the
+		// new one yet
+		reconcile(upgradeValidate)
+		if _, err := clientset.CoreV1().Pods("driver").Get(context.TODO(), driver.Name, metav1.GetOptions{}); err == nil {
+			t.Errorf("%s: outdated driver Pod not deleted", test.name)
+		}
+
+		current := newUpgradePod("driver-new", worker.Name, "2", false)
+		current.Labels["app"] = "driver"
+		if _, err := clientset.CoreV1().Pods("driver").Create(context.TODO(), current, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Cannot create driver Pod: %v", err)
+		}
+		reconcile(upgradeValidate)
+
+		current.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		if _, err := clientset.CoreV1().Pods("driver").UpdateStatus(context.TODO(), current, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Cannot update driver Pod: %v", err)
+		}
+		reconcile(upgradeDone)
+
+		n, _ := loadUpgradeNode(t, worker.Name, key)
+		if n.unschedulable != test.unschedulable {
+			t.Errorf("%s: unschedulable = %v after the upgrade, want %v", test.name, n.unschedulable, test.unschedulable)
+		}
+		if len(n.annotations) != 0 {
+			t.Errorf("%s: annotations left after the upgrade: %v", test.name, n.annotations)
+		}
+	}
+}
@@ -1,23 +1,20 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"regexp"
 	"strings"
 	"time"
 
 	errs "github.com/pkg/errors"
-	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+type resourceCallbacks map[string]func(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error
+
 var waitFor resourceCallbacks
 
 func init() {
@@ -26,6 +23,7 @@ func init() {
 	waitFor["Pod"] = waitForPod
 	waitFor["DaemonSet"] = waitForDaemonSet
 	waitFor["BuildConfig"] = waitForBuild
+	waitFor["Job"] = waitForJob
 }
 
 type statusCallback func(obj *unstructured.Unstructured) bool
@@ -80,11 +78,25 @@ func makeStatusCallback(obj *unstructured.Unstructured, status interface{}, fiel
 
 var waitCallback resourceCallbacks
 
-func waitForResource(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+func waitForResource(obj *unstructured.Unstructured, r *SpecialResourceReconciler, params map[string]string) error {
 
 	log.Info("WaitForResource", "Kind", obj.GetKind())
 
-	var err error = nil
+	// A wait-for expression or the default expression of the kind
+	callback, found, err := readinessCallback(obj, params)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := waitForResourceAvailability(obj, r); err != nil {
+			return errs.Wrap(err, "Waiting too long for resource")
+		}
+		if err := waitForResourceFullAvailability(obj, r, callback); err != nil {
+			return errs.Wrap(err, "Waiting too long for resource")
+		}
+		return nil
+	}
+
 	// Wait for general availability, Pods Complete, Running
 	// DaemonSet NumberUnavailable == 0, etc
 	if wait, ok := waitFor[obj.GetKind()]; ok {
@@ -165,10 +177,27 @@ func waitForBuild(obj *unstructured.Unstructured, r *SpecialResourceReconciler)
 	return nil
 }
 
+func waitForJobCallback(obj *unstructured.Unstructured) bool {
+
+	succeeded, found, err := unstructured.NestedInt64(obj.Object, "status", "succeeded")
+	if err != nil || !found {
+		return false
+	}
+	return succeeded > 0
+}
+
+func waitForJob(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
+	if err := waitForResourceAvailability(obj, r); err != nil {
+		return err
+	}
+
+	return waitForResourceFullAvailability(obj, r, waitForJobCallback)
+}
+
 func waitForResourceAvailability(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
 
 	found := obj.DeepCopy()
-	err := wait.Poll(retryInterval, timeout, func() (done bool, err error) {
+	err := pollState(retryInterval, timeout, func() (done bool, err error) {
 		err = r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
@@ -186,7 +215,7 @@ func waitForResourceFullAvailability(obj *unstructured.Unstructured, r *SpecialR
 
 	found := obj.DeepCopy()
 
-	if err := wait.Poll(retryInterval, timeout, func() (done bool, err error) {
+	if err := pollState(retryInterval, timeout, func() (done bool, err error) {
 		err = r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found)
 		if err != nil {
 			log.Error(err, "")
@@ -204,67 +233,42 @@ func waitForResourceFullAvailability(obj *unstructured.Unstructured, r *SpecialR
 	return nil
 }
 
-func waitForDaemonSetLogs(obj *unstructured.Unstructured, r *SpecialResourceReconciler, pattern string) error {
-
-	log.Info("WaitForDaemonSetLogs", "Name", obj.GetName())
-
-	pods := &unstructured.UnstructuredList{}
-	pods.SetAPIVersion("v1")
-	pods.SetKind("pod")
-
-	label := make(map[string]string)
-
-	var found bool
-	var selector string
+// jobFailed is true if the Job gave up i.e. has a Failed condition or
+// failed more often than its backoffLimit allows
+func jobFailed(obj *unstructured.Unstructured) bool {
 
-	if selector, found = obj.GetLabels()["app"]; !found {
-		errs.New("Cannot find Label app=, missing take a look at the manifests")
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]interface{})
+		if ok && condition["type"] == "Failed" && condition["status"] == "True" {
+			return true
+		}
 	}
 
-	log.Info("Looking for Pods with label app=" + selector)
-	label["app"] = selector
-
-	opts := []client.ListOption{
-		client.InNamespace(r.specialresource.Spec.Namespace),
-		client.MatchingLabels(label),
-	}
-
-	err := r.List(context.TODO(), pods, opts...)
-	if err != nil {
-		return errs.Wrap(err, "Could not get PodList")
+	failed, _, _ := unstructured.NestedInt64(obj.Object, "status", "failed")
+	backoffLimit, found, _ := unstructured.NestedInt64(obj.Object, "spec", "backoffLimit")
+	if !found {
+		// The default of the Job API
+		backoffLimit = 6
 	}
+	return failed > backoffLimit
+}
 
-	for _, pod := range pods.Items {
-		log.Info("WaitForDaemonSetLogs", "Pod", pod.GetName())
-		podLogOpts := corev1.PodLogOptions{}
-		req := kubeclient.CoreV1().Pods(pod.GetNamespace()).GetLogs(pod.GetName(), &podLogOpts)
-		podLogs, err := req.Stream(context.TODO())
-		if err != nil {
-			return errs.Wrap(err, "Error in opening stream")
-		}
-		defer podLogs.Close()
+// waitForDeletion waits until obj is gone, a terminating object would be
+// found by CRUD and waited on instead of being recreated
+func waitForDeletion(obj *unstructured.Unstructured, r *SpecialResourceReconciler) error {
 
-		buf := new(bytes.Buffer)
-		_, err = io.Copy(buf, podLogs)
-		if err != nil {
-			return errs.Wrap(err, "Error in copy information from podLogs to buf")
-		}
+	found := obj.DeepCopy()
 
-		cutoff := 100
-		if buf.Len() <= 100 {
-			cutoff = 0
+	return pollState(retryInterval, timeout, func() (done bool, err error) {
+		err = r.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, found)
+		if apierrors.IsNotFound(err) {
+			return true, nil
 		}
-
-		logs := buf.String()
-		lastBytes := logs[len(logs)-cutoff:]
-		log.Info("WaitForDaemonSetLogs", "LastBytes", lastBytes)
-
-		if match, _ := regexp.MatchString(pattern, lastBytes); !match {
-			return errs.New("Not yet done. Not matched against: " + pattern)
+		if err != nil {
+			return false, err
 		}
-		// We're only checking one Pod not all of them
-		break
-	}
-
-	return nil
+		log.Info("Waiting for deletion of ", "Kind", obj.GetKind()+": "+obj.GetNamespace()+"/"+obj.GetName())
+		return false, nil
+	})
 }
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	srov1beta1 "github.com/openshift-psap/special-resource-operator/api/v1beta1"
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// maxLogLineBytes is the longest log line that is matched, longer lines
+// fail the match
+const maxLogLineBytes = 1024 * 1024
+
+// defaultLogTailLines bounds how much of the log of each Pod is read, the
+// pattern is expected near the end
+const defaultLogTailLines = 100
+
+// logPatternOptions are the parameters of the wait-for-logs hook, quorum
+// is a number or percentage of Pods that have to match, all by default,
+// since drops log lines older than a duration e.g. 10m
+type logPatternOptions struct {
+	pattern   *regexp.Regexp
+	quorum    string
+	tailLines int64
+	since     time.Duration
+}
+
+func parseLogPatternOptions(params map[string]string) (logPatternOptions, error) {
+
+	opts := logPatternOptions{quorum: params["quorum"], tailLines: defaultLogTailLines}
+
+	if params["pattern"] == "" {
+		return opts, errs.New("wait-for-logs needs a pattern")
+	}
+	re, err := regexp.Compile(params["pattern"])
+	if err != nil {
+		return opts, errs.Wrap(err, "Cannot compile wait-for-logs pattern")
+	}
+	opts.pattern = re
+
+	if lines, found := params["tailLines"]; found {
+		if opts.tailLines, err = strconv.ParseInt(lines, 10, 64); err != nil || opts.tailLines <= 0 {
+			return opts, errs.New("wait-for-logs tailLines must be a positive number: " + lines)
+		}
+	}
+
+	if since, found := params["since"]; found {
+		if opts.since, err = time.ParseDuration(since); err != nil {
+			return opts, errs.Wrap(err, "Cannot parse wait-for-logs since")
+		}
+	}
+
+	return opts, nil
+}
+
+// logQuorum returns how many of total Pods have to match, quorum is empty
+// for all, a number e.g. 2 or a percentage e.g. 50%
+func logQuorum(quorum string, total int) (int, error) {
+
+	if quorum == "" {
+		return total, nil
+	}
+
+	if strings.HasSuffix(quorum, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(quorum, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, errs.New("wait-for-logs quorum is not a percentage: " + quorum)
+		}
+		return int(math.Ceil(percent * float64(total) / 100)), nil
+	}
+
+	n, err := strconv.Atoi(quorum)
+	if err != nil || n < 0 {
+		return 0, errs.New("wait-for-logs quorum is not a number: " + quorum)
+	}
+	if n > total {
+		return total, nil
+	}
+
+	return n, nil
+}
+
+// matchLines reads logs line by line and returns the last matching line
+func matchLines(logs io.Reader, pattern *regexp.Regexp) (bool, string, error) {
+
+	matched := false
+	last := ""
+
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineBytes)
+	for scanner.Scan() {
+		if pattern.Match(scanner.Bytes()) {
+			matched = true
+			last = scanner.Text()
+		}
+	}
+
+	return matched, last, scanner.Err()
+}
+
+// podLogsMatch streams the bounded tail of the log of pod
+func podLogsMatch(pod corev1.Pod, opts logPatternOptions) (bool, string, error) {
+
+	logOpts := corev1.PodLogOptions{TailLines: &opts.tailLines}
+	if opts.since > 0 {
+		since := metav1.NewTime(time.Now().Add(-opts.since))
+		logOpts.SinceTime = &since
+	}
+
+	logs, err := kubeclient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &logOpts).Stream(context.TODO())
+	if err != nil {
+		return false, "", errs.Wrap(err, "Cannot open logs of Pod "+pod.Name)
+	}
+	defer logs.Close()
+
+	return matchLines(logs, opts.pattern)
+}
+
+// daemonSetPods returns the Pods selected by the DaemonSet, the app label
+// if the DaemonSet has no selector
+func daemonSetPods(obj *unstructured.Unstructured) ([]corev1.Pod, error) {
+
+	selector, _, err := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels")
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot extract selector of "+obj.GetName())
+	}
+	if len(selector) == 0 {
+		app, found := obj.GetLabels()["app"]
+		if !found {
+			return nil, errs.New("Cannot find selector or label app of DaemonSet " + obj.GetName())
+		}
+		selector = map[string]string{"app": app}
+	}
+
+	pods, err := kubeclient.CoreV1().Pods(obj.GetNamespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, "Could not get PodList")
+	}
+
+	return pods.Items, nil
+}
+
+// waitForDaemonSetLogs checks the pattern on every Pod of the DaemonSet and
+// records the result per node, it fails until the quorum of Pods matched
+func waitForDaemonSetLogs(obj *unstructured.Unstructured, r *SpecialResourceReconciler, params map[string]string) error {
+
+	opts, err := parseLogPatternOptions(params)
+	if err != nil {
+		return err
+	}
+
+	pods, err := daemonSetPods(obj)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return errs.New("No Pods of DaemonSet " + obj.GetName() + " yet")
+	}
+
+	quorum, err := logQuorum(opts.quorum, len(pods))
+	if err != nil {
+		return err
+	}
+
+	statuses := []srov1beta1.SpecialResourceNodeStatus{}
+	matched := 0
+	failed := []string{}
+
+	for _, pod := range pods {
+
+		status := srov1beta1.SpecialResourceNodeStatus{
+			Node:   pod.Spec.NodeName,
+			Object: obj.GetKind() + "/" + obj.GetName(),
+			Check:  "wait-for-logs",
+		}
+
+		match, line, err := podLogsMatch(pod, opts)
+		switch {
+		case err != nil:
+			status.Message = err.Error()
+		case match:
+			status.Ready = true
+			status.Message = line
+			matched++
+		default:
+			status.Message = "Pod " + pod.Name + " did not log " + opts.pattern.String()
+		}
+		if !status.Ready {
+			failed = append(failed, pod.Spec.NodeName)
+		}
+
+		log.Info("WaitForDaemonSetLogs", "Pod", pod.Name, "Node", pod.Spec.NodeName, "Matched", status.Ready)
+		statuses = append(statuses, status)
+	}
+
+	updateNodeStatus(obj.GetKind()+"/"+obj.GetName(), "wait-for-logs", statuses, r)
+
+	if matched < quorum {
+		return errs.New("Not yet done, " + strconv.Itoa(matched) + "/" + strconv.Itoa(quorum) +
+			" Pods matched " + opts.pattern.String() + ", waiting for nodes " + strings.Join(failed, ","))
+	}
+
+	return nil
+}
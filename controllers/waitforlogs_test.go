@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLogQuorum(t *testing.T) {
+
+	tests := []struct {
+		quorum string
+		total  int
+		want   int
+	}{
+		{"", 5, 5},
+		{"2", 5, 2},
+		{"7", 5, 5},
+		{"50%", 5, 3},
+		{"100%", 4, 4},
+		{"0%", 4, 0},
+	}
+
+	for _, test := range tests {
+		got, err := logQuorum(test.quorum, test.total)
+		if err != nil {
+			t.Errorf("logQuorum(%s, %d) failed: %v", test.quorum, test.total, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("logQuorum(%s, %d) = %d, want %d", test.quorum, test.total, got, test.want)
+		}
+	}
+
+	for _, quorum := range []string{"half", "150%", "-1"} {
+		if _, err := logQuorum(quorum, 3); err == nil {
+			t.Errorf("logQuorum(%s) should fail", quorum)
+		}
+	}
+}
+
+func TestMatchLines(t *testing.T) {
+
+	logs := "+ modprobe nvidia\n+ wait 42\nloaded\n"
+
+	matched, line, err := matchLines(strings.NewReader(logs), regexp.MustCompile(`\+ wait \d+`))
+	if err != nil || !matched || line != "+ wait 42" {
+		t.Errorf("matchLines = %v, %q, %v, want the wait line", matched, line, err)
+	}
+
+	// Patterns are matched per line, not across lines
+	matched, _, _ = matchLines(strings.NewReader(logs), regexp.MustCompile(`nvidia\n\+ wait`))
+	if matched {
+		t.Errorf("matchLines should not match across lines")
+	}
+
+	// Lines longer than the default 64KB of a scanner
+	long := strings.Repeat("x", 128*1024) + " driver loaded\n"
+	matched, _, err = matchLines(strings.NewReader(long), regexp.MustCompile(`driver loaded`))
+	if err != nil || !matched {
+		t.Errorf("matchLines of a long line = %v, %v", matched, err)
+	}
+}
+
+func TestParseLogPatternOptions(t *testing.T) {
+
+	opts, err := parseLogPatternOptions(map[string]string{"pattern": "ready", "quorum": "50%", "tailLines": "20", "since": "10m"})
+	if err != nil {
+		t.Fatalf("parseLogPatternOptions failed: %v", err)
+	}
+	if opts.tailLines != 20 || opts.since.Minutes() != 10 || opts.quorum != "50%" {
+		t.Errorf("parseLogPatternOptions = %+v", opts)
+	}
+
+	if opts, _ := parseLogPatternOptions(map[string]string{"pattern": "ready"}); opts.tailLines != defaultLogTailLines {
+		t.Errorf("tailLines should default to %d", defaultLogTailLines)
+	}
+
+	for _, params := range []map[string]string{
+		{"quorum": "1"},
+		{"pattern": "("},
+		{"pattern": "ready", "tailLines": "0"},
+		{"pattern": "ready", "since": "yesterday"},
+	} {
+		if _, err := parseLogPatternOptions(params); err == nil {
+			t.Errorf("parseLogPatternOptions(%v) should fail", params)
+		}
+	}
+}
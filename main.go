@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -51,10 +52,25 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var buildRegistry string
+	var buildPushSecret string
+	var stateLabelPrefix string
+	var insecureRegistries string
+	var artifactsToolsImage string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&buildRegistry, "build-registry", "",
+		"The registry driver-containers are pushed to on clusters without OpenShift builds.")
+	flag.StringVar(&buildPushSecret, "build-push-secret", "",
+		"The dockerconfigjson Secret in the specialresource namespace used to push to the build registry.")
+	flag.StringVar(&stateLabelPrefix, "state-label-prefix", "specialresource.openshift.io",
+		"The prefix of the node labels that gate the states of a recipe e.g. <prefix>/driver-container-<name>.")
+	flag.StringVar(&insecureRegistries, "insecure-registries", "",
+		"Comma separated registries images are looked up in without verifying their certificate, in addition to insecureRegistries of the cluster image config.")
+	flag.StringVar(&artifactsToolsImage, "artifacts-tools-image", "",
+		"The image with a static /bin/busybox that copies artifacts, the operator image by default.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -71,10 +87,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	if artifactsToolsImage == "" {
+		if artifactsToolsImage, err = controllers.OperatorImage(); err != nil {
+			setupLog.Info("Cannot get operator image, copying artifacts with "+controllers.DefaultArtifactsToolsImage, "error", err.Error())
+			artifactsToolsImage = controllers.DefaultArtifactsToolsImage
+		}
+	}
+
 	if err = (&controllers.SpecialResourceReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log,
-		Scheme: mgr.GetScheme(),
+		Client:              mgr.GetClient(),
+		Log:                 ctrl.Log,
+		Scheme:              mgr.GetScheme(),
+		BuildRegistry:       buildRegistry,
+		BuildPushSecret:     buildPushSecret,
+		StateLabelPrefix:    stateLabelPrefix,
+		InsecureRegistries:  strings.FieldsFunc(insecureRegistries, func(c rune) bool { return c == ',' }),
+		ArtifactsToolsImage: artifactsToolsImage,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "SpecialResource")
 		os.Exit(1)
@@ -0,0 +1,354 @@
+package registry
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	errs "github.com/pkg/errors"
+)
+
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// Credentials used to authenticate against a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Reference is a parsed image reference host/repository:tag or @digest
+type Reference struct {
+	Host       string
+	Repository string
+	Tag        string
+}
+
+// Client checks if images are available in a registry, it only talks the
+// registry v2 API and does not pull any layers.
+type Client struct {
+	HTTPClient *http.Client
+	// Auths maps a registry host to its credentials
+	Auths map[string]Credentials
+	// Insecure registries are talked to without verifying their certificate
+	// or over plain HTTP e.g. registry.local:5000 or *.example.com, like
+	// insecureRegistries of the cluster image config
+	Insecure []string
+}
+
+// NewClient returns a client with a sane timeout and the given credentials
+func NewClient(auths map[string]Credentials) *Client {
+	if auths == nil {
+		auths = map[string]Credentials{}
+	}
+	return &Client{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Auths:      auths,
+	}
+}
+
+// ParseReference splits an image into host, repository and tag or digest,
+// images without a host are resolved against docker.io
+func ParseReference(image string) (Reference, error) {
+
+	ref := Reference{}
+
+	if len(image) == 0 {
+		return ref, errs.New("Empty image reference")
+	}
+
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	if ref.Tag == "" {
+		ref.Tag = "latest"
+	}
+
+	s := strings.SplitN(name, "/", 2)
+	// The first component is a host if it has a dot, a port or is localhost
+	if len(s) == 2 && (strings.ContainsAny(s[0], ".:") || s[0] == "localhost") {
+		ref.Host = s[0]
+		ref.Repository = s[1]
+	} else {
+		ref.Host = dockerHub
+		ref.Repository = name
+	}
+
+	if ref.Host == dockerHub && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	if len(ref.Repository) == 0 {
+		return ref, errs.New("Image reference has no repository: " + image)
+	}
+
+	return ref, nil
+}
+
+// ParseDockerConfig extracts the credentials from a .dockerconfigjson or
+// .dockercfg secret payload
+func ParseDockerConfig(data []byte) (map[string]Credentials, error) {
+
+	type auth struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	cfg := struct {
+		Auths map[string]auth `json:"auths"`
+	}{}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, errs.Wrap(err, "Cannot unmarshal docker config")
+	}
+	// .dockercfg has no auths wrapper
+	if cfg.Auths == nil {
+		if err := json.Unmarshal(data, &cfg.Auths); err != nil {
+			return nil, errs.Wrap(err, "Cannot unmarshal docker config")
+		}
+	}
+
+	auths := map[string]Credentials{}
+
+	for host, a := range cfg.Auths {
+		creds := Credentials{Username: a.Username, Password: a.Password}
+		if a.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, errs.Wrap(err, "Cannot decode auth for "+host)
+			}
+			s := strings.SplitN(string(decoded), ":", 2)
+			if len(s) != 2 {
+				return nil, errs.New("Malformed auth for " + host)
+			}
+			creds.Username, creds.Password = s[0], s[1]
+		}
+		auths[normalizeHost(host)] = creds
+	}
+
+	return auths, nil
+}
+
+func normalizeHost(host string) string {
+
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	host = strings.TrimSuffix(host, "/")
+
+	if host == "index.docker.io" || host == dockerHubRegistry {
+		return dockerHub
+	}
+	return host
+}
+
+// Exists returns true if the manifest of image can be found, false if the
+// registry answers with not found and an error for everything else
+func (c *Client) Exists(image string) (bool, error) {
+
+	ref, err := ParseReference(image)
+	if err != nil {
+		return false, err
+	}
+
+	host := ref.Host
+	if host == dockerHub {
+		host = dockerHubRegistry
+	}
+
+	manifest := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, ref.Repository, ref.Tag)
+	insecure := c.IsInsecure(ref.Host)
+
+	resp, err := c.head(manifest, "", insecure)
+	// Insecure registries may not speak TLS at all
+	if err != nil && insecure {
+		manifest = "http://" + strings.TrimPrefix(manifest, "https://")
+		resp, err = c.head(manifest, "", insecure)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := c.authorize(ref, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return false, err
+		}
+		if resp, err = c.head(manifest, authorization, insecure); err != nil {
+			return false, err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("Unexpected status %s for %s", resp.Status, image)
+}
+
+// IsInsecure is true if host is one of the insecure registries, a
+// repository e.g. registry.local/vendor marks its whole registry insecure
+func (c *Client) IsInsecure(host string) bool {
+
+	host = normalizeHost(host)
+	for _, insecure := range c.Insecure {
+		insecure = strings.SplitN(normalizeHost(insecure), "/", 2)[0]
+		if insecure == host {
+			return true
+		}
+		if strings.HasPrefix(insecure, "*.") && strings.HasSuffix(host, insecure[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClient skips verifying the certificate of insecure registries
+func (c *Client) httpClient(insecure bool) *http.Client {
+
+	if !insecure {
+		return c.HTTPClient
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if t, ok := c.HTTPClient.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+
+	return &http.Client{Timeout: c.HTTPClient.Timeout, Transport: transport}
+}
+
+func (c *Client) head(manifest string, authorization string, insecure bool) (*http.Response, error) {
+
+	req, err := http.NewRequest(http.MethodHead, manifest, nil)
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot create request")
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.httpClient(insecure).Do(req)
+	if err != nil {
+		return nil, errs.Wrap(err, "Cannot reach registry")
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// authorize answers a WWW-Authenticate challenge with either basic auth or
+// a bearer token from the realm of the registry
+func (c *Client) authorize(ref Reference, challenge string) (string, error) {
+
+	creds, hasCreds := c.Auths[ref.Host]
+
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCreds {
+			return "", errs.New("Registry requires credentials for " + ref.Host)
+		}
+		return "Basic " + basicAuth(creds), nil
+
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", errs.New("Registry sent no valid realm: " + challenge)
+		}
+		query := realm.Query()
+		if service, ok := params["service"]; ok {
+			query.Set("service", service)
+		}
+		query.Set("scope", "repository:"+ref.Repository+":pull")
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", errs.Wrap(err, "Cannot create token request")
+		}
+		if hasCreds {
+			req.Header.Set("Authorization", "Basic "+basicAuth(creds))
+		}
+
+		resp, err := c.httpClient(c.IsInsecure(realm.Host)).Do(req)
+		if err != nil {
+			return "", errs.Wrap(err, "Cannot reach token realm")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Token realm answered %s", resp.Status)
+		}
+
+		token := struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", errs.Wrap(err, "Cannot decode token")
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return "Bearer " + token.Token, nil
+	}
+
+	return "", errs.New("Unsupported registry authentication: " + challenge)
+}
+
+func basicAuth(creds Credentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into its scheme
+// and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+
+	params := map[string]string{}
+
+	s := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(s) < 2 {
+		return s[0], params
+	}
+
+	for _, param := range strings.Split(s[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+
+	return s[0], params
+}
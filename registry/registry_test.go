@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newRegistry starts a local registry serving the given tags of repository
+// driver, if token is set every request needs a bearer token issued for
+// user:pass.
+func newRegistry(t *testing.T, tags []string, token bool) (*httptest.Server, *Client) {
+
+	mux := http.NewServeMux()
+	srv := httptest.NewTLSServer(mux)
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("scope") != "repository:vendor/driver:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"token": "secret"}`)
+	})
+
+	mux.HandleFunc("/v2/vendor/driver/manifests/", func(w http.ResponseWriter, req *http.Request) {
+		if token && req.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tag := strings.TrimPrefix(req.URL.Path, "/v2/vendor/driver/manifests/")
+		for _, t := range tags {
+			if t == tag {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	client := NewClient(nil)
+	client.HTTPClient = srv.Client()
+
+	return srv, client
+}
+
+func TestExists(t *testing.T) {
+
+	srv, client := newRegistry(t, []string{"4.18.0-193.el8.x86_64"}, false)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	tests := []struct {
+		image  string
+		exists bool
+	}{
+		{host + "/vendor/driver:4.18.0-193.el8.x86_64", true},
+		{host + "/vendor/driver:4.18.0-240.el8.x86_64", false},
+	}
+
+	for _, test := range tests {
+		exists, err := client.Exists(test.image)
+		if err != nil {
+			t.Fatalf("Exists(%s) failed: %v", test.image, err)
+		}
+		if exists != test.exists {
+			t.Errorf("Exists(%s) = %v, want %v", test.image, exists, test.exists)
+		}
+	}
+}
+
+func TestExistsWithToken(t *testing.T) {
+
+	srv, client := newRegistry(t, []string{"latest"}, true)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	image := host + "/vendor/driver"
+
+	if _, err := client.Exists(image); err == nil {
+		t.Errorf("Exists(%s) without credentials should fail", image)
+	}
+
+	client.Auths[host] = Credentials{Username: "user", Password: "pass"}
+
+	exists, err := client.Exists(image)
+	if err != nil {
+		t.Fatalf("Exists(%s) failed: %v", image, err)
+	}
+	if !exists {
+		t.Errorf("Exists(%s) = false, want true", image)
+	}
+}
+
+func TestExistsInsecure(t *testing.T) {
+
+	srv, _ := newRegistry(t, []string{"latest"}, false)
+	defer srv.Close()
+
+	// The certificate of the test server is not trusted by default
+	client := NewClient(nil)
+	image := strings.TrimPrefix(srv.URL, "https://") + "/vendor/driver"
+	if _, err := client.Exists(image); err == nil {
+		t.Errorf("Exists(%s) with an untrusted certificate should fail", image)
+	}
+
+	client.Insecure = []string{strings.TrimPrefix(srv.URL, "https://")}
+	if exists, err := client.Exists(image); err != nil || !exists {
+		t.Errorf("Exists(%s) of an insecure registry = %v, %v", image, exists, err)
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer plain.Close()
+
+	image = strings.TrimPrefix(plain.URL, "http://") + "/vendor/driver"
+	if _, err := client.Exists(image); err == nil {
+		t.Errorf("Exists(%s) over plain HTTP should fail", image)
+	}
+
+	client.Insecure = []string{image}
+	if exists, err := client.Exists(image); err != nil || !exists {
+		t.Errorf("Exists(%s) of an insecure registry over plain HTTP = %v, %v", image, exists, err)
+	}
+}
+
+func TestIsInsecure(t *testing.T) {
+
+	client := NewClient(nil)
+	client.Insecure = []string{"registry.local:5000", "*.example.com", "mirror.local/vendor"}
+
+	tests := []struct {
+		host     string
+		insecure bool
+	}{
+		{"registry.local:5000", true},
+		{"registry.local", false},
+		{"quay.example.com", true},
+		{"example.com", false},
+		{"mirror.local", true},
+		{"quay.io", false},
+	}
+
+	for _, test := range tests {
+		if got := client.IsInsecure(test.host); got != test.insecure {
+			t.Errorf("IsInsecure(%s) = %v, want %v", test.host, got, test.insecure)
+		}
+	}
+}
+
+func TestParseReference(t *testing.T) {
+
+	tests := []struct {
+		image string
+		want  Reference
+	}{
+		{"ubi8", Reference{"docker.io", "library/ubi8", "latest"}},
+		{"nvidia/driver:450", Reference{"docker.io", "nvidia/driver", "450"}},
+		{"quay.io/vendor/driver:4.18-8.2", Reference{"quay.io", "vendor/driver", "4.18-8.2"}},
+		{"localhost:5000/driver", Reference{"localhost:5000", "driver", "latest"}},
+		{"quay.io/vendor/driver@sha256:abc", Reference{"quay.io", "vendor/driver", "sha256:abc"}},
+	}
+
+	for _, test := range tests {
+		got, err := ParseReference(test.image)
+		if err != nil {
+			t.Fatalf("ParseReference(%s) failed: %v", test.image, err)
+		}
+		if got != test.want {
+			t.Errorf("ParseReference(%s) = %+v, want %+v", test.image, got, test.want)
+		}
+	}
+}
+
+func TestParseDockerConfig(t *testing.T) {
+
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	data := []byte(`{"auths": {"https://index.docker.io/v1/": {"auth": "` + auth + `"}, "quay.io": {"username": "robot", "password": "token"}}}`)
+
+	auths, err := ParseDockerConfig(data)
+	if err != nil {
+		t.Fatalf("ParseDockerConfig failed: %v", err)
+	}
+
+	if auths["docker.io"] != (Credentials{"user", "pass"}) {
+		t.Errorf("docker.io credentials = %+v", auths["docker.io"])
+	}
+	if auths["quay.io"] != (Credentials{"robot", "token"}) {
+		t.Errorf("quay.io credentials = %+v", auths["quay.io"])
+	}
+}